@@ -0,0 +1,100 @@
+// migrate 执行目录中的 SQL 迁移（MySQL）：
+//
+//	migrate -dsn "user:pass@tcp(127.0.0.1:3306)/app?parseTime=true" -dir ./migrations status
+//	migrate -dir ./migrations -dry-run up
+//	migrate -lock redis -redis 127.0.0.1:6379 down 1
+//
+// DSN 也可通过环境变量 MIGRATE_DSN 提供。包含 Go 迁移的服务请在自己的 main 中调用 migrate.Command。
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"os"
+	"os/signal"
+	"syscall"
+	"time"
+
+	"github.com/xsda-pixel/common-infra/dal"
+	"github.com/xsda-pixel/common-infra/logs"
+	"github.com/xsda-pixel/common-infra/migrate"
+
+	rds "github.com/redis/go-redis/v9"
+	"gorm.io/driver/mysql"
+	"gorm.io/gorm"
+	"gorm.io/gorm/logger"
+)
+
+func main() {
+	var (
+		dsn         = flag.String("dsn", os.Getenv("MIGRATE_DSN"), "MySQL DSN，默认取环境变量 MIGRATE_DSN")
+		dir         = flag.String("dir", "./migrations", "SQL 迁移目录")
+		table       = flag.String("table", "", "版本表，默认 schema_migrations")
+		lockMode    = flag.String("lock", migrate.LockDB, "锁方式：db / redis / none")
+		lockTimeout = flag.Duration("lock-timeout", time.Minute, "等待锁的最长时间")
+		redisAddr   = flag.String("redis", "", "Redis 地址，-lock redis 时必填")
+		outOfOrder  = flag.Bool("allow-out-of-order", false, "允许执行版本号小于已执行最大版本的迁移")
+		dryRun      = flag.Bool("dry-run", false, "只打印将要执行的 SQL")
+		logDir      = flag.String("log-dir", logs.LogFilePath, "日志目录")
+	)
+	flag.Usage = func() {
+		fmt.Fprintf(flag.CommandLine.Output(), "usage: migrate [flags] <command>\n\n%s\n\nflags:\n", migrate.Usage)
+		flag.PrintDefaults()
+	}
+	flag.Parse()
+
+	if *dsn == "" || flag.NArg() == 0 {
+		flag.Usage()
+		os.Exit(2)
+	}
+
+	logs.LogFilePath = *logDir
+	if err := logs.Init(false, false, false); err != nil {
+		fatal(err)
+	}
+
+	db, err := gorm.Open(mysql.Open(*dsn), &gorm.Config{Logger: logger.Discard})
+	if err != nil {
+		fatal(err)
+	}
+
+	var rdsClient *rds.Client
+	if *redisAddr != "" {
+		rdsClient = rds.NewClient(&rds.Options{Addr: *redisAddr})
+		defer rdsClient.Close()
+	}
+
+	migrations, e := migrate.LoadFS(os.DirFS(*dir), ".")
+	if e != nil {
+		fatal(e)
+	}
+
+	opts := []func(*migrate.Config){
+		migrate.WithTable(*table),
+		migrate.WithLock(*lockMode, "", *lockTimeout),
+	}
+	if *outOfOrder {
+		opts = append(opts, migrate.WithAllowOutOfOrder())
+	}
+	if *dryRun {
+		opts = append(opts, migrate.WithDryRun(os.Stdout))
+	}
+	m, e := migrate.New(dal.NewDB(db, rdsClient), migrations, opts...)
+	if e != nil {
+		fatal(e)
+	}
+
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+
+	if e := migrate.Command(ctx, m, flag.Args(), os.Stdout); e != nil {
+		stop()
+		fatal(e)
+	}
+}
+
+func fatal(err error) {
+	fmt.Fprintln(os.Stderr, err)
+	os.Exit(1)
+}
@@ -0,0 +1,244 @@
+package dal
+
+import (
+	"database/sql"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"github.com/xsda-pixel/common-infra/errors"
+	"github.com/xsda-pixel/common-infra/types/amount"
+
+	"gorm.io/gorm/clause"
+)
+
+// 支持的聚合函数
+const (
+	AggSum   = "SUM"
+	AggMin   = "MIN"
+	AggMax   = "MAX"
+	AggAvg   = "AVG"
+	AggCount = "COUNT"
+)
+
+var aggFuncs = map[string]struct{}{AggSum: {}, AggMin: {}, AggMax: {}, AggAvg: {}, AggCount: {}}
+
+// Agg 单个聚合项，Column 为 * 时仅可用于 COUNT
+type Agg struct {
+	Func   string // AggSum / AggMin / AggMax / AggAvg / AggCount
+	Column string
+	Alias  string // 结果名，对应 GroupRow.Values（COUNT 为 GroupRow.Counts）的 key，可在 Having / Order 中引用
+}
+
+// GroupOption 分组聚合查询参数
+type GroupOption struct {
+	GroupBy []string
+	Aggs    []Agg
+	Where   WhereOption
+	Having  WhereOption // 列名不加表名前缀，可引用 Agg.Alias
+	Order   *string
+	Limit   *int
+}
+
+// GroupRow 分组聚合的一行：分组键 + 聚合值
+type GroupRow struct {
+	Keys   map[string]any           // key 为 GroupBy 中的列名，[]byte 会转为 string
+	Values map[string]amount.Amount // SUM / MIN / MAX / AVG 的结果，key 为 Agg.Alias
+	Counts map[string]int64         // COUNT 的结果（行数而非金额），key 为 Agg.Alias
+}
+
+// Sum 金额列求和，列中存储的是 Amount 的原始值（见 amount.Amount.Value），无数据时返回 Zero
+func (db *RepoDB[T]) Sum(tableName, column string, where WhereOption) (amount.Amount, errors.Error) {
+	return db.aggregate(tableName, AggSum, column, where)
+}
+
+// Min 金额列最小值，无数据时返回 Zero
+func (db *RepoDB[T]) Min(tableName, column string, where WhereOption) (amount.Amount, errors.Error) {
+	return db.aggregate(tableName, AggMin, column, where)
+}
+
+// Max 金额列最大值，无数据时返回 Zero
+func (db *RepoDB[T]) Max(tableName, column string, where WhereOption) (amount.Amount, errors.Error) {
+	return db.aggregate(tableName, AggMax, column, where)
+}
+
+// Avg 金额列平均值，小数部分（小于 Amount 最小精度）向零截断，无数据时返回 Zero
+func (db *RepoDB[T]) Avg(tableName, column string, where WhereOption) (amount.Amount, errors.Error) {
+	return db.aggregate(tableName, AggAvg, column, where)
+}
+
+var aggOps = map[string]string{AggSum: OpSum, AggMin: OpMin, AggMax: OpMax, AggAvg: OpAvg}
+
+func (db *RepoDB[T]) aggregate(tableName, fn, column string, where WhereOption) (amount.Amount, errors.Error) {
+	op := &Operation{Kind: aggOps[fn], Table: tableName, Fields: []string{column}, Where: where}
+	return intercept(db, op, func(op *Operation) (amount.Amount, errors.Error) {
+		if len(op.Fields) == 0 {
+			return amount.Zero(), invalidQuery("%s: column is empty", op.Kind)
+		}
+		return db.aggregateQuery(op.Table, fn, op.Fields[0], op.Where)
+	})
+}
+
+func (db *RepoDB[T]) aggregateQuery(tableName, fn, column string, where WhereOption) (amount.Amount, errors.Error) {
+	rs := db.table(db.conn(), tableName).
+		Select(fn+"(?)", clause.Column{Name: column})
+
+	rs = applyWhere(rs, where)
+
+	var result sql.NullString
+	if err := rs.Scan(&result).Error; err != nil {
+		return amount.Zero(), db.wrapErr(err)
+	}
+
+	a, err := parseAmount(result)
+	if err != nil {
+		return amount.Zero(), db.wrapErr(err)
+	}
+	return a, nil
+}
+
+// GroupAggregate 分组聚合：SELECT group..., FN(col) AS alias ... GROUP BY group... HAVING ...
+func (db *RepoDB[T]) GroupAggregate(tableName string, opt GroupOption) ([]GroupRow, errors.Error) {
+	op := &Operation{Kind: OpGroupAggregate, Table: tableName, Fields: opt.GroupBy, Where: opt.Where, Having: opt.Having, Order: opt.Order, Limit: opt.Limit, Args: opt.Aggs}
+	return intercept(db, op, func(op *Operation) ([]GroupRow, errors.Error) {
+		opt.GroupBy, opt.Where, opt.Having, opt.Order, opt.Limit = op.Fields, op.Where, op.Having, op.Order, op.Limit
+		return db.groupAggregate(op.Table, opt)
+	})
+}
+
+func (db *RepoDB[T]) groupAggregate(tableName string, opt GroupOption) ([]GroupRow, errors.Error) {
+	if len(opt.Aggs) == 0 {
+		return nil, errors.NewError(http.StatusBadRequest, errors.NewMsg("GroupAggregate: aggs is empty"))
+	}
+	if err := db.checkQuery(opt.GroupBy, opt.Order, nil); err != nil {
+		return nil, err
+	}
+
+	selects := make([]string, 0, len(opt.GroupBy)+len(opt.Aggs))
+	vars := make([]any, 0, len(opt.GroupBy)+2*len(opt.Aggs))
+	for _, col := range opt.GroupBy {
+		selects = append(selects, "?")
+		vars = append(vars, clause.Column{Name: col})
+	}
+	for _, agg := range opt.Aggs {
+		fn := strings.ToUpper(agg.Func)
+		if _, ok := aggFuncs[fn]; !ok {
+			return nil, invalidQuery("aggregate func %s is not allowed", agg.Func)
+		}
+		if !identRe.MatchString(agg.Alias) {
+			return nil, invalidQuery("aggregate alias %s is invalid", agg.Alias)
+		}
+		if agg.Column == "*" {
+			if fn != AggCount {
+				return nil, invalidQuery("aggregate %s(*) is not allowed", fn)
+			}
+			selects = append(selects, "COUNT(*) AS ?")
+			vars = append(vars, clause.Column{Name: agg.Alias})
+			continue
+		}
+		selects = append(selects, fn+"(?) AS ?")
+		vars = append(vars, clause.Column{Name: agg.Column}, clause.Column{Name: agg.Alias})
+	}
+
+	rs := db.table(db.conn(), tableName).
+		Select(strings.Join(selects, ", "), vars...)
+
+	rs = applyWhere(rs, opt.Where)
+
+	for _, col := range opt.GroupBy {
+		rs = rs.Group(dialectSQL(rs, col))
+	}
+
+	rs = applyHaving(rs, opt.Having)
+
+	if opt.Order != nil && *opt.Order != "" {
+		rs = rs.Order(dialectSQL(rs, *opt.Order))
+	}
+
+	if opt.Limit != nil && *opt.Limit > 0 {
+		rs = rs.Limit(*opt.Limit)
+	}
+
+	rows, err := rs.Rows()
+	if err != nil {
+		return nil, db.wrapErr(err)
+	}
+	defer rows.Close()
+
+	var list []GroupRow
+	for rows.Next() {
+		keys := make([]any, len(opt.GroupBy))
+		aggs := make([]sql.NullString, len(opt.Aggs))
+		dest := make([]any, 0, len(keys)+len(aggs))
+		for i := range keys {
+			dest = append(dest, &keys[i])
+		}
+		for i := range aggs {
+			dest = append(dest, &aggs[i])
+		}
+		if err := rows.Scan(dest...); err != nil {
+			return nil, db.wrapErr(err)
+		}
+
+		row := GroupRow{
+			Keys:   make(map[string]any, len(keys)),
+			Values: make(map[string]amount.Amount, len(aggs)),
+			Counts: make(map[string]int64),
+		}
+		for i, col := range opt.GroupBy {
+			if b, ok := keys[i].([]byte); ok {
+				keys[i] = string(b)
+			}
+			row.Keys[col] = keys[i]
+		}
+		for i, agg := range opt.Aggs {
+			if strings.EqualFold(agg.Func, AggCount) {
+				n, err := parseCount(aggs[i])
+				if err != nil {
+					return nil, db.wrapErr(err)
+				}
+				row.Counts[agg.Alias] = n
+				continue
+			}
+			a, err := parseAmount(aggs[i])
+			if err != nil {
+				return nil, db.wrapErr(err)
+			}
+			row.Values[agg.Alias] = a
+		}
+		list = append(list, row)
+	}
+
+	if err := rows.Err(); err != nil {
+		return nil, db.wrapErr(err)
+	}
+	return list, nil
+}
+
+// parseCount 解析 COUNT 结果，NULL 视为 0
+func parseCount(v sql.NullString) (int64, error) {
+	if !v.Valid {
+		return 0, nil
+	}
+	return strconv.ParseInt(strings.TrimSpace(v.String), 10, 64)
+}
+
+// parseAmount 解析聚合结果（整数或 DECIMAL 字符串）为 Amount，只取整数部分以保持 big.Int 精度
+func parseAmount(v sql.NullString) (amount.Amount, error) {
+	if !v.Valid {
+		return amount.Zero(), nil
+	}
+	s := strings.TrimSpace(v.String)
+	if i := strings.IndexByte(s, '.'); i >= 0 {
+		s = s[:i]
+	}
+	if s == "" || s == "-" {
+		return amount.Zero(), nil
+	}
+
+	var a amount.Amount
+	if err := a.Scan(s); err != nil {
+		return amount.Zero(), err
+	}
+	return a, nil
+}
@@ -0,0 +1,371 @@
+package dal
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"reflect"
+	"time"
+
+	"github.com/xsda-pixel/common-infra/errors"
+
+	"gorm.io/gorm"
+)
+
+const defaultAuditTable = "audit_log"
+
+// 审计动作
+const (
+	AuditCreate  = "create"
+	AuditUpdate  = "update"
+	AuditDelete  = "delete"
+	AuditRestore = "restore"
+)
+
+type (
+	actorCtxKey     struct{}
+	requestIDCtxKey struct{}
+)
+
+// AuditRecord 一行数据的一次变更；Before / After / Diff 为 JSON，新增时 Before 为空，物理删除时 After 为空。
+// 默认写入的审计表结构：
+//
+//	CREATE TABLE audit_log (
+//	  id BIGINT PRIMARY KEY AUTO_INCREMENT,
+//	  table_name VARCHAR(64) NOT NULL, action VARCHAR(16) NOT NULL, row_key VARCHAR(64) NOT NULL,
+//	  actor VARCHAR(64) NOT NULL, request_id VARCHAR(64) NOT NULL,
+//	  before_image JSON NULL, after_image JSON NULL, diff JSON NULL, created_at DATETIME(3) NOT NULL,
+//	  KEY idx_table_row (table_name, row_key)
+//	);
+type AuditRecord struct {
+	ID        int64     `json:"id"`
+	Table     string    `json:"table" gorm:"column:table_name"`
+	Action    string    `json:"action"`
+	RowKey    string    `json:"row_key"` // 行主键值
+	Actor     string    `json:"actor"`
+	RequestID string    `json:"request_id"`
+	Before    *string   `json:"before" gorm:"column:before_image"`
+	After     *string   `json:"after" gorm:"column:after_image"`
+	Diff      *string   `json:"diff"` // {"列": [旧值, 新值]}
+	CreatedAt time.Time `json:"created_at"`
+}
+
+// AuditSink 审计记录的写入目标，在业务写入所在的事务中调用，返回错误会使整个事务回滚
+type AuditSink interface {
+	WriteAudit(tx *gorm.DB, records []*AuditRecord) error
+}
+
+// AuditSinkFunc 函数形式的 AuditSink
+type AuditSinkFunc func(tx *gorm.DB, records []*AuditRecord) error
+
+func (f AuditSinkFunc) WriteAudit(tx *gorm.DB, records []*AuditRecord) error {
+	return f(tx, records)
+}
+
+// AuditConfig 行级审计配置
+type AuditConfig struct {
+	Table    string    // 审计表，Sink 为 nil 时写入该表，默认 audit_log
+	Sink     AuditSink // 自定义写入目标，优先于 Table
+	PKColumn string    // 行主键列，默认取模型主键，模型没有主键时为 id
+}
+
+// WithAudit 为 CreateOne / Update / Delete / HardDelete / Restore 开启行级审计：
+// 在同一事务中 SELECT ... FOR UPDATE 读取前镜像，写入限定在这些行上，再读取后镜像，与 ctx 中的操作人、请求 ID 一起写入审计。
+// 传入的 db 不是事务时自动开启事务。CreateMany / Upsert / BulkUpdateByKey 不记录审计。
+func WithAudit(cfg AuditConfig) func(*RepoConfig) {
+	return func(c *RepoConfig) {
+		if cfg.Table == "" {
+			cfg.Table = defaultAuditTable
+		}
+		c.Audit = &cfg
+	}
+}
+
+// WithActor 将操作人写入 ctx，用于审计
+func WithActor(ctx context.Context, actor string) context.Context {
+	if ctx == nil {
+		ctx = context.Background()
+	}
+	return context.WithValue(ctx, actorCtxKey{}, actor)
+}
+
+// WithRequestID 将请求 ID 写入 ctx，用于审计
+func WithRequestID(ctx context.Context, requestID string) context.Context {
+	if ctx == nil {
+		ctx = context.Background()
+	}
+	return context.WithValue(ctx, requestIDCtxKey{}, requestID)
+}
+
+// ActorFromContext 返回 ctx 中的操作人
+func ActorFromContext(ctx context.Context) string {
+	if ctx == nil {
+		return ""
+	}
+	s, _ := ctx.Value(actorCtxKey{}).(string)
+	return s
+}
+
+// RequestIDFromContext 返回 ctx 中的请求 ID
+func RequestIDFromContext(ctx context.Context) string {
+	if ctx == nil {
+		return ""
+	}
+	s, _ := ctx.Value(requestIDCtxKey{}).(string)
+	return s
+}
+
+// audited 执行 write 并记录审计；未开启审计时直接执行。write 收到的 where 已限定为前镜像中的行
+func (db *RepoDB[T]) audited(
+	dbs *gorm.DB,
+	tableName string,
+	action string,
+	where WhereOption,
+	write func(tx *gorm.DB, where WhereOption) (int64, errors.Error),
+) (int64, errors.Error) {
+	if db.config.Audit == nil {
+		return write(dbs, where)
+	}
+
+	pk := db.auditPK()
+	var rows int64
+	err := db.inTx(dbs, func(tx *gorm.DB) errors.Error {
+		before, err := db.auditImage(tx, tableName, where, true)
+		if err != nil || len(before) == 0 {
+			return err
+		}
+
+		keys := make([]any, 0, len(before))
+		for _, row := range before {
+			keys = append(keys, row[pk])
+		}
+		byKey := WhereOption{Cond: In(pk, keys)}
+
+		scoped := where
+		scoped.Cond = And(scoped.Cond, byKey.Cond)
+		if rows, err = write(tx, scoped); err != nil {
+			return err
+		}
+
+		after, err := db.auditImage(tx, tableName, byKey, false)
+		if err != nil {
+			return err
+		}
+		return db.writeAudit(tx, db.auditRecords(tableName, action, pk, before, after))
+	})
+	if err != nil {
+		return 0, err
+	}
+	return rows, nil
+}
+
+// auditedCreate 执行 create 并以新行作为后镜像记录审计
+func (db *RepoDB[T]) auditedCreate(
+	dbs *gorm.DB,
+	tableName string,
+	item *T,
+	create func(tx *gorm.DB) errors.Error,
+) errors.Error {
+	if db.config.Audit == nil {
+		return create(dbs)
+	}
+
+	pk := db.auditPK()
+	return db.inTx(dbs, func(tx *gorm.DB) errors.Error {
+		if err := create(tx); err != nil {
+			return err
+		}
+
+		after, err := db.createdImage(tx, tableName, pk, item)
+		if err != nil {
+			return err
+		}
+		return db.writeAudit(tx, db.auditRecords(tableName, AuditCreate, pk, nil, after))
+	})
+}
+
+// inTx dbs 已是事务时直接在其中执行 fn，否则开启新事务
+func (db *RepoDB[T]) inTx(dbs *gorm.DB, fn func(tx *gorm.DB) errors.Error) errors.Error {
+	conn := db.bind(dbs)
+	if _, ok := conn.Statement.ConnPool.(gorm.TxCommitter); ok {
+		return fn(conn)
+	}
+
+	var e errors.Error
+	err := conn.Transaction(func(tx *gorm.DB) error {
+		if e = fn(tx); e != nil {
+			return e
+		}
+		return nil
+	})
+	if e != nil {
+		return e
+	}
+	if err != nil {
+		return db.wrapErr(err)
+	}
+	return nil
+}
+
+// auditImage 按 where（含租户条件）读取整行，lock 为 true 时加 FOR UPDATE
+func (db *RepoDB[T]) auditImage(tx *gorm.DB, tableName string, where WhereOption, lock bool) ([]map[string]any, errors.Error) {
+	rs, err := db.writeTable(tx, tableName)
+	if err != nil {
+		return nil, err
+	}
+
+	rs = applyWhere(rs, where)
+
+	if lock {
+		rs = forUpdate(rs, "")
+	}
+
+	var rows []map[string]any
+	if err := rs.Find(&rows).Error; err != nil {
+		return nil, db.wrapErr(err)
+	}
+	return rows, nil
+}
+
+// createdImage 按主键回读新行，取不到主键时使用 item 自身的字段
+func (db *RepoDB[T]) createdImage(tx *gorm.DB, tableName, pk string, item *T) ([]map[string]any, errors.Error) {
+	sch, err := db.modelSchema()
+	if err != nil {
+		return nil, db.wrapErr(err)
+	}
+
+	if field := lookupField(sch, pk); field != nil {
+		if v, zero := field.ValueOf(db.Context(), reflect.ValueOf(item).Elem()); !zero {
+			return db.auditImage(tx, tableName, WhereOption{Cond: Eq(pk, v)}, false)
+		}
+	}
+
+	row := make(map[string]any, len(sch.DBNames))
+	for _, name := range sch.DBNames {
+		row[name] = db.fieldValue(sch.FieldsByDBName[name], item)
+	}
+	return []map[string]any{row}, nil
+}
+
+// auditRecords 按主键对齐前后镜像生成审计记录；更新前后完全相同的行不记录
+func (db *RepoDB[T]) auditRecords(tableName, action, pk string, before, after []map[string]any) []*AuditRecord {
+	ctx := db.Context()
+	now := time.Now()
+
+	afterByKey := make(map[string]map[string]any, len(after))
+	for _, row := range after {
+		afterByKey[auditKey(row[pk])] = row
+	}
+
+	newRecord := func(key string, b, a map[string]any) *AuditRecord {
+		return &AuditRecord{
+			Table:     cacheTable(tableName),
+			Action:    action,
+			RowKey:    key,
+			Actor:     ActorFromContext(ctx),
+			RequestID: RequestIDFromContext(ctx),
+			Before:    auditJSON(b),
+			After:     auditJSON(a),
+			Diff:      auditJSON(auditDiff(b, a)),
+			CreatedAt: now,
+		}
+	}
+
+	var records []*AuditRecord
+	if before == nil {
+		for _, a := range after {
+			records = append(records, newRecord(auditKey(a[pk]), nil, normalizeImage(a)))
+		}
+		return records
+	}
+
+	for _, b := range before {
+		key := auditKey(b[pk])
+		b = normalizeImage(b)
+		a := normalizeImage(afterByKey[key])
+		diff := auditDiff(b, a)
+		if a != nil && len(diff) == 0 {
+			continue
+		}
+		records = append(records, newRecord(key, b, a))
+	}
+	return records
+}
+
+func (db *RepoDB[T]) writeAudit(tx *gorm.DB, records []*AuditRecord) errors.Error {
+	if len(records) == 0 {
+		return nil
+	}
+	cfg := db.config.Audit
+	var err error
+	if cfg.Sink != nil {
+		err = cfg.Sink.WriteAudit(tx, records)
+	} else {
+		err = tx.Table(cfg.Table).Create(records).Error
+	}
+	if err != nil {
+		return db.wrapErr(err)
+	}
+	return nil
+}
+
+func (db *RepoDB[T]) auditPK() string {
+	if db.config.Audit.PKColumn != "" {
+		return db.config.Audit.PKColumn
+	}
+	if sch, err := db.modelSchema(); err == nil && sch.PrioritizedPrimaryField != nil {
+		return sch.PrioritizedPrimaryField.DBName
+	}
+	return "id"
+}
+
+// auditDiff 变化的列：{"列": [旧值, 新值]}；新增与物理删除时包含全部列
+func auditDiff(before, after map[string]any) map[string][2]any {
+	diff := make(map[string][2]any)
+	for col, b := range before {
+		a, ok := after[col]
+		if after == nil || !ok || !reflect.DeepEqual(b, a) {
+			diff[col] = [2]any{b, a}
+		}
+	}
+	for col, a := range after {
+		if _, ok := before[col]; !ok {
+			diff[col] = [2]any{nil, a}
+		}
+	}
+	return diff
+}
+
+// normalizeImage 驱动返回的 []byte 转为字符串，便于比较与 JSON 序列化
+func normalizeImage(row map[string]any) map[string]any {
+	if row == nil {
+		return nil
+	}
+	out := make(map[string]any, len(row))
+	for k, v := range row {
+		if b, ok := v.([]byte); ok {
+			v = string(b)
+		}
+		out[k] = v
+	}
+	return out
+}
+
+func auditKey(v any) string {
+	if b, ok := v.([]byte); ok {
+		return string(b)
+	}
+	return fmt.Sprint(v)
+}
+
+func auditJSON[V any](v map[string]V) *string {
+	if v == nil {
+		return nil
+	}
+	b, err := json.Marshal(v)
+	if err != nil {
+		return nil
+	}
+	s := string(b)
+	return &s
+}
@@ -0,0 +1,265 @@
+package dal
+
+import (
+	"net/http"
+	"sort"
+	"strings"
+
+	"github.com/xsda-pixel/common-infra/errors"
+
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+const defaultChunkSize = 500
+
+// UpsertOption INSERT ... ON DUPLICATE KEY UPDATE 配置
+type UpsertOption struct {
+	ConflictColumns []string // 冲突判定列（唯一键）；MySQL 以表上的唯一索引为准，SQLite / PostgreSQL 为空时使用模型主键
+	UpdateColumns   []string // 冲突时更新的列，为空时更新除冲突列外的全部列
+	ChunkSize       int      // 每批行数，<= 0 时使用默认值 500
+}
+
+// KeyedUpdate BulkUpdateByKey 的单行更新：主键值 + 该行要更新的列
+type KeyedUpdate struct {
+	Key    any
+	Values map[string]any
+}
+
+// CreateMany 分批插入，返回每批影响行数。
+// 多批之间不保证原子性，需要原子性时传入事务；出错时返回已完成批次的影响行数与错误。
+func (db *RepoDB[T]) CreateMany(
+	dbs *gorm.DB,
+	tableName string,
+	items []*T,
+	chunkSize int,
+) ([]int64, errors.Error) {
+	op := &Operation{Kind: OpCreateMany, DB: dbs, Table: tableName, Item: items, Args: chunkSize}
+	return intercept(db, op, func(op *Operation) ([]int64, errors.Error) {
+		return db.createMany(op.DB, op.Table, items, chunkSize)
+	})
+}
+
+func (db *RepoDB[T]) createMany(
+	dbs *gorm.DB,
+	tableName string,
+	items []*T,
+	chunkSize int,
+) ([]int64, errors.Error) {
+	return db.insertChunks(dbs, tableName, items, chunkSize, nil)
+}
+
+// Upsert 分批 INSERT ... ON DUPLICATE KEY UPDATE（SQLite / PostgreSQL 为 ON CONFLICT ... DO UPDATE），返回每批影响行数：
+// MySQL 中插入计 1，更新计 2，未变化计 0；SQLite / PostgreSQL 中插入与更新均计 1。
+// SQLite / PostgreSQL 通过 RETURNING 回填主键，冲突更新的行同样回填已有行的主键，MySQL 只保证插入行的主键
+func (db *RepoDB[T]) Upsert(
+	dbs *gorm.DB,
+	tableName string,
+	items []*T,
+	opt UpsertOption,
+) ([]int64, errors.Error) {
+	op := &Operation{Kind: OpUpsert, DB: dbs, Table: tableName, Item: items, Args: opt}
+	return intercept(db, op, func(op *Operation) ([]int64, errors.Error) {
+		return db.upsert(op.DB, op.Table, items, opt)
+	})
+}
+
+func (db *RepoDB[T]) upsert(
+	dbs *gorm.DB,
+	tableName string,
+	items []*T,
+	opt UpsertOption,
+) ([]int64, errors.Error) {
+	onConflict := clause.OnConflict{}
+	for _, col := range opt.ConflictColumns {
+		onConflict.Columns = append(onConflict.Columns, clause.Column{Name: col})
+	}
+	if len(opt.UpdateColumns) > 0 {
+		onConflict.DoUpdates = clause.AssignmentColumns(opt.UpdateColumns)
+	} else {
+		onConflict.UpdateAll = true
+	}
+
+	onConflict, err := db.tenantUpsert(dbs, onConflict)
+	if err != nil {
+		return nil, err
+	}
+	if onConflict, err = db.upsertConflict(dbs, onConflict); err != nil {
+		return nil, err
+	}
+
+	return db.insertChunks(dbs, tableName, items, opt.ChunkSize, onConflict)
+}
+
+func (db *RepoDB[T]) insertChunks(
+	dbs *gorm.DB,
+	tableName string,
+	items []*T,
+	chunkSize int,
+	onConflict clause.Expression,
+) ([]int64, errors.Error) {
+	if len(items) == 0 {
+		return nil, nil
+	}
+	for _, item := range items {
+		if item == nil {
+			return nil, errors.NewError(http.StatusBadRequest, errors.NewMsg("insert: item is nil"))
+		}
+	}
+	if err := db.stampTenant(items...); err != nil {
+		return nil, err
+	}
+
+	if chunkSize <= 0 {
+		chunkSize = defaultChunkSize
+	}
+
+	counts := make([]int64, 0, (len(items)+chunkSize-1)/chunkSize)
+	var total int64
+	for start := 0; start < len(items); start += chunkSize {
+		end := start + chunkSize
+		if end > len(items) {
+			end = len(items)
+		}
+
+		rs := db.bind(dbs).Table(tableName)
+		if onConflict != nil {
+			rs = rs.Clauses(onConflict)
+		}
+
+		rs = rs.Create(items[start:end])
+		if rs.Error != nil {
+			if total > 0 {
+				db.afterWrite(tableName)
+			}
+			return counts, db.wrapErr(rs.Error)
+		}
+		counts = append(counts, rs.RowsAffected)
+		total += rs.RowsAffected
+	}
+
+	if total > 0 {
+		db.afterWrite(tableName)
+	}
+	return counts, nil
+}
+
+// BulkUpdateByKey 按主键批量更新，每行可更新不同的值，每批一条语句：
+//
+//	UPDATE t SET c1 = CASE key WHEN ? THEN ? ... ELSE c1 END, ... WHERE key IN (...)
+//
+// 某行未给出的列保持原值。返回每批影响行数。
+func (db *RepoDB[T]) BulkUpdateByKey(
+	dbs *gorm.DB,
+	tableName string,
+	keyColumn string,
+	rows []KeyedUpdate,
+	chunkSize int,
+) ([]int64, errors.Error) {
+	op := &Operation{Kind: OpBulkUpdateByKey, DB: dbs, Table: tableName, Fields: []string{keyColumn}, Item: rows, Args: chunkSize}
+	return intercept(db, op, func(op *Operation) ([]int64, errors.Error) {
+		return db.bulkUpdateByKey(op.DB, op.Table, keyColumn, rows, chunkSize)
+	})
+}
+
+func (db *RepoDB[T]) bulkUpdateByKey(
+	dbs *gorm.DB,
+	tableName string,
+	keyColumn string,
+	rows []KeyedUpdate,
+	chunkSize int,
+) ([]int64, errors.Error) {
+	if len(rows) == 0 {
+		return nil, nil
+	}
+	if strings.TrimSpace(keyColumn) == "" {
+		return nil, errors.NewError(http.StatusBadRequest, errors.NewMsg("BulkUpdateByKey: key column is empty"))
+	}
+
+	for _, row := range rows {
+		if err := db.checkTenantUpdates(row.Values); err != nil {
+			return nil, err
+		}
+	}
+
+	if chunkSize <= 0 {
+		chunkSize = defaultChunkSize
+	}
+
+	counts := make([]int64, 0, (len(rows)+chunkSize-1)/chunkSize)
+	var total int64
+	for start := 0; start < len(rows); start += chunkSize {
+		end := start + chunkSize
+		if end > len(rows) {
+			end = len(rows)
+		}
+
+		keys, updates := caseWhenUpdates(keyColumn, rows[start:end])
+		if len(updates) == 0 {
+			counts = append(counts, 0)
+			continue
+		}
+
+		rs, err := db.writeTable(dbs, tableName)
+		if err != nil {
+			return counts, err
+		}
+		rs = rs.Where(clause.IN{Column: clause.Column{Name: keyColumn}, Values: keys}).
+			Updates(updates)
+		if rs.Error != nil {
+			if total > 0 {
+				db.afterWrite(tableName)
+			}
+			return counts, db.wrapErr(rs.Error)
+		}
+		counts = append(counts, rs.RowsAffected)
+		total += rs.RowsAffected
+	}
+
+	if total > 0 {
+		db.afterWrite(tableName)
+	}
+	return counts, nil
+}
+
+// caseWhenUpdates 为一批行生成 IN 的主键列表与每列的 CASE WHEN 表达式
+func caseWhenUpdates(keyColumn string, rows []KeyedUpdate) ([]any, map[string]any) {
+	keys := make([]any, 0, len(rows))
+	colSet := make(map[string]struct{})
+	for _, row := range rows {
+		if len(row.Values) == 0 {
+			continue
+		}
+		keys = append(keys, row.Key)
+		for col := range row.Values {
+			colSet[col] = struct{}{}
+		}
+	}
+
+	cols := make([]string, 0, len(colSet))
+	for col := range colSet {
+		cols = append(cols, col)
+	}
+	sort.Strings(cols)
+
+	key := clause.Column{Name: keyColumn}
+	updates := make(map[string]any, len(cols))
+	for _, col := range cols {
+		var sql strings.Builder
+		vars := []any{key}
+		sql.WriteString("CASE ?")
+		for _, row := range rows {
+			v, ok := row.Values[col]
+			if !ok {
+				continue
+			}
+			sql.WriteString(" WHEN ? THEN ?")
+			vars = append(vars, row.Key, v)
+		}
+		sql.WriteString(" ELSE ? END")
+		vars = append(vars, clause.Column{Name: col})
+		updates[col] = gorm.Expr(sql.String(), vars...)
+	}
+
+	return keys, updates
+}
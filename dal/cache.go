@@ -0,0 +1,242 @@
+package dal
+
+import (
+	"context"
+	"crypto/sha1"
+	"encoding/hex"
+	"encoding/json"
+	"math/rand"
+	"strings"
+	"time"
+
+	"github.com/xsda-pixel/common-infra/errors"
+	"github.com/xsda-pixel/common-infra/logs"
+
+	rds "github.com/redis/go-redis/v9"
+	"gorm.io/gorm"
+)
+
+const (
+	defaultCachePrefix      = "dal:cache"
+	defaultCacheLoadTimeout = 10 * time.Second
+)
+
+// 缓存的操作类型，参与 key 计算
+const (
+	cacheOpOne   = "one"
+	cacheOpMany  = "many"
+	cacheOpCount = "count"
+)
+
+// CacheConfig 读穿缓存配置，基于 DBS.RDS
+type CacheConfig struct {
+	TTL         time.Duration // 缓存有效期
+	Jitter      time.Duration // TTL 随机抖动上限，避免大量 key 同时过期
+	NegativeTTL time.Duration // 空结果（FindOne 为 nil、FindMany 为空、Count 为 0）的缓存时长，0 表示不缓存
+	Prefix      string        // key 前缀，默认 dal:cache
+	LoadTimeout time.Duration // 未命中时合并回源的超时，默认 10s；回源不受单个调用方 ctx 取消的影响
+}
+
+// WithCache 为 FindOne / FindMany / Count 开启读穿缓存；CreateOne / Update / Delete 成功后自动失效整表缓存。
+// T 需要能被 encoding/json 正确序列化。
+// key 中包含表版本号，每次读取先 GET 版本号再 GET 数据，命中时也是两次 Redis 往返。
+// 配置了从库时未命中的回源读主库，见 cachedConn。
+func WithCache(cfg CacheConfig) func(*RepoConfig) {
+	return func(c *RepoConfig) {
+		if cfg.TTL <= 0 {
+			return
+		}
+		if cfg.Prefix == "" {
+			cfg.Prefix = defaultCachePrefix
+		}
+		c.Cache = &cfg
+	}
+}
+
+// NoCache 返回跳过缓存读取的仓库副本，写操作仍会失效缓存
+func (db *RepoDB[T]) NoCache() *RepoDB[T] {
+	repo := *db
+	repo.noCache = true
+	return &repo
+}
+
+// cacheEnabled 处于 WithTx 中时不读写缓存，避免把未提交（可能回滚）的数据写入缓存
+func (db *RepoDB[T]) cacheEnabled() bool {
+	return db.config.Cache != nil && db.RDS != nil && !db.noCache && !db.boundTx()
+}
+
+// cachedConn 读穿缓存的查询使用的连接。开启缓存时回源读主库：失效后从库可能尚未同步，
+// 从库读到的旧数据会以新版本号写回缓存，直到 TTL 过期都读不到新数据；命中缓存时不访问数据库
+func (db *RepoDB[T]) cachedConn() *gorm.DB {
+	if db.cacheEnabled() {
+		return db.Primary().conn()
+	}
+	return db.conn()
+}
+
+func (db *RepoDB[T]) boundTx() bool {
+	_, ok := TxFromContext(db.ctx)
+	return ok
+}
+
+// cacheEntry singleflight 中传递的结果；raw 用于给共享结果的调用方各自反序列化一份，避免共用指针
+type cacheEntry[R any] struct {
+	val R
+	raw []byte
+}
+
+// readThrough 读穿缓存：命中直接返回；未命中时同一 key 的并发请求合并为一次 load，结果回写缓存。
+// Redis 异常只记录日志并回源，不影响读取。
+func readThrough[T, R any](db *RepoDB[T], op, tableName string, rs *gorm.DB, empty func(R) bool, load func(*gorm.DB) (R, errors.Error)) (R, errors.Error) {
+	if !db.cacheEnabled() || rs.Error != nil {
+		return load(rs) // 构建查询时已出错（如缺少租户）则直接返回该错误
+	}
+
+	var zero R
+	ctx := db.Context()
+	cfg := db.config.Cache
+	table := cacheTable(tableName)
+
+	key, err := db.cacheKey(rs, op, table)
+	if err != nil {
+		logs.Logger.Warn(err)
+		return load(rs)
+	}
+
+	if raw, err := db.RDS.Get(ctx, key).Bytes(); err == nil {
+		var val R
+		if err := json.Unmarshal(raw, &val); err == nil {
+			return val, nil
+		}
+	} else if err != rds.Nil {
+		logs.Logger.Warn(err)
+		return load(rs)
+	}
+
+	v, err, shared := db.share(key, func(ctx context.Context) (any, error) {
+		val, e := load(rs.WithContext(ctx))
+		if e != nil {
+			return nil, e
+		}
+
+		raw, err := json.Marshal(val)
+		if err != nil {
+			logs.Logger.Warn(err)
+			return cacheEntry[R]{val: val}, nil
+		}
+
+		ttl := cacheTTL(cfg)
+		if empty(val) {
+			ttl = cfg.NegativeTTL
+		}
+		if ttl > 0 {
+			if err := db.RDS.Set(ctx, key, raw, ttl).Err(); err != nil {
+				logs.Logger.Warn(err)
+			}
+		}
+		return cacheEntry[R]{val: val, raw: raw}, nil
+	})
+	if err != nil {
+		return zero, err.(errors.Error)
+	}
+
+	entry := v.(cacheEntry[R])
+	if !shared || entry.raw == nil {
+		return entry.val, nil
+	}
+	var val R
+	if err := json.Unmarshal(entry.raw, &val); err != nil {
+		return entry.val, nil
+	}
+	return val, nil
+}
+
+// share 按 key 合并并发回源。load 在脱离调用方取消、超时为 LoadTimeout 的 ctx 上执行，
+// 调用方 ctx 结束时只有它自己提前返回，共享同一次回源的其他调用方不受影响
+func (db *RepoDB[T]) share(key string, load func(ctx context.Context) (any, error)) (any, error, bool) {
+	ctx := db.Context()
+	timeout := defaultCacheLoadTimeout
+	if cfg := db.config.Cache; cfg != nil && cfg.LoadTimeout > 0 {
+		timeout = cfg.LoadTimeout
+	}
+
+	ch := db.flight.DoChan(key, func() (any, error) {
+		loadCtx, cancel := context.WithTimeout(context.WithoutCancel(ctx), timeout)
+		defer cancel()
+		return load(loadCtx)
+	})
+	select {
+	case r := <-ch:
+		return r.Val, r.Err, r.Shared
+	case <-ctx.Done():
+		return nil, ClassifyError(ctx, ctx.Err()), false
+	}
+}
+
+// cacheKey 以表名 + 表版本 + 规范化后的 SQL 作为 key；SQL 由 DryRun 生成，Eq 的 map 顺序等差异会被消除。
+// 版本号需要单独一次 GET：数据 key 依赖版本号，无法与数据读取合并到同一个 MGET / pipeline
+func (db *RepoDB[T]) cacheKey(rs *gorm.DB, op, table string) (string, error) {
+	var list []*T
+	stmt := rs.Session(&gorm.Session{DryRun: true}).Find(&list).Statement
+
+	vars, err := json.Marshal(stmt.Vars)
+	if err != nil {
+		return "", err
+	}
+
+	ver, err := db.RDS.Get(db.Context(), db.cacheVersionKey(table)).Result()
+	if err == rds.Nil {
+		ver, err = "0", nil
+	}
+	if err != nil {
+		return "", err
+	}
+
+	h := sha1.New()
+	h.Write([]byte(stmt.SQL.String()))
+	h.Write([]byte{0})
+	h.Write(vars)
+
+	return db.cachePrefix() + ":" + table + ":v" + ver + ":" + op + ":" + hex.EncodeToString(h.Sum(nil)), nil
+}
+
+// cachePrefix 只开启总数缓存（WithTotal）时使用默认前缀
+func (db *RepoDB[T]) cachePrefix() string {
+	if db.config.Cache != nil {
+		return db.config.Cache.Prefix
+	}
+	return defaultCachePrefix
+}
+
+func (db *RepoDB[T]) cacheVersionKey(table string) string {
+	return db.cachePrefix() + ":" + table + ":ver"
+}
+
+// invalidateCache 递增表版本号，使该表已有缓存（含总数缓存）全部失效（旧 key 随 TTL 自然过期）
+func (db *RepoDB[T]) invalidateCache(tableName string) {
+	if (db.config.Cache == nil && !db.totalCacheEnabled()) || db.RDS == nil {
+		return
+	}
+	if err := db.RDS.Incr(db.Context(), db.cacheVersionKey(cacheTable(tableName))).Err(); err != nil {
+		logs.Logger.Error(err)
+	}
+}
+
+func cacheTTL(cfg *CacheConfig) time.Duration {
+	if cfg.Jitter <= 0 {
+		return cfg.TTL
+	}
+	return cfg.TTL + time.Duration(rand.Int63n(int64(cfg.Jitter)))
+}
+
+func isNilItem[T any](item *T) bool     { return item == nil }
+func isEmptyList[T any](list []*T) bool { return len(list) == 0 }
+func isZeroCount(n int64) bool          { return n == 0 }
+
+// cacheTable 去掉表别名："user u" -> "user"
+func cacheTable(tableName string) string {
+	if parts := strings.Fields(tableName); len(parts) > 0 {
+		return parts[0]
+	}
+	return tableName
+}
@@ -0,0 +1,223 @@
+package dal_test
+
+import (
+	"context"
+	stdErrors "errors"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/xsda-pixel/common-infra/dal"
+	"github.com/xsda-pixel/common-infra/dal/daltest"
+
+	"github.com/alicebob/miniredis/v2"
+	rds "github.com/redis/go-redis/v9"
+	"gorm.io/gorm"
+)
+
+// newCachedOrders SQLite + miniredis 上开启读穿缓存的订单仓库，数据同 memOrders；
+// 测试直接通过返回的 *gorm.DB 改库来区分命中缓存与回源
+func newCachedOrders(t *testing.T, cfg dal.CacheConfig) (*dal.RepoDB[daltest.Order], *gorm.DB, *miniredis.Miniredis) {
+	t.Helper()
+	db := openSQLite(t)
+	migrateOrders(t, db)
+	mr := miniredis.RunT(t)
+	client := rds.NewClient(&rds.Options{Addr: mr.Addr()})
+	t.Cleanup(func() { _ = client.Close() })
+
+	repo := dal.NewRepoDB[daltest.Order](dal.NewDB(db, client), dal.WithCache(cfg))
+	if _, err := repo.CreateMany(db, daltest.OrderTable, memOrders(), 0); err != nil {
+		t.Fatal(err)
+	}
+	return repo, db, mr
+}
+
+func byOrderNo(no string) dal.WhereOption {
+	return dal.WhereOption{Eq: map[string]any{"order_no": no}}
+}
+
+// dataKeys 缓存的数据 key（不含表版本号 key）
+func dataKeys(mr *miniredis.Miniredis) []string {
+	var keys []string
+	for _, k := range mr.Keys() {
+		if !strings.HasSuffix(k, ":ver") {
+			keys = append(keys, k)
+		}
+	}
+	return keys
+}
+
+func amountOf(t *testing.T, repo *dal.RepoDB[daltest.Order], no string) int64 {
+	t.Helper()
+	o, err := repo.FindOne(daltest.OrderTable, nil, byOrderNo(no))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if o == nil {
+		return -1
+	}
+	return o.Amount
+}
+
+func TestCacheHitAndInvalidate(t *testing.T) {
+	repo, db, mr := newCachedOrders(t, dal.CacheConfig{TTL: time.Minute})
+
+	if got := amountOf(t, repo, "NO001"); got != 100 {
+		t.Fatalf("first read = %d, want 100", got)
+	}
+	keys := dataKeys(mr)
+	if len(keys) != 1 || mr.TTL(keys[0]) != time.Minute {
+		t.Fatalf("cache keys = %v, want one with ttl 1m", keys)
+	}
+
+	// 绕过仓库改库：命中缓存时仍读到旧值，NoCache 读到新值
+	db.Exec("UPDATE "+daltest.OrderTable+" SET amount = ? WHERE order_no = ?", 111, "NO001")
+	if got := amountOf(t, repo, "NO001"); got != 100 {
+		t.Fatalf("second read = %d, want cached 100", got)
+	}
+	if got := amountOf(t, repo.NoCache(), "NO001"); got != 111 {
+		t.Fatalf("NoCache read = %d, want 111", got)
+	}
+	n, err := repo.Count(daltest.OrderTable, dal.WhereOption{Cond: dal.Gte("amount", 500)})
+	if err != nil || n != 6 {
+		t.Fatalf("Count = %d, %v; want 6", n, err)
+	}
+
+	// 经仓库写入后整表缓存失效
+	if _, err := repo.Update(db, daltest.OrderTable, byOrderNo("NO001"), map[string]any{"amount": 222}); err != nil {
+		t.Fatal(err)
+	}
+	if got := amountOf(t, repo, "NO001"); got != 222 {
+		t.Fatalf("read after Update = %d, want 222", got)
+	}
+	if err := repo.CreateOne(db, daltest.OrderTable, &daltest.Order{OrderNo: "NO011", Amount: 1100}); err != nil {
+		t.Fatal(err)
+	}
+	if n, _ = repo.Count(daltest.OrderTable, dal.WhereOption{Cond: dal.Gte("amount", 500)}); n != 7 {
+		t.Fatalf("Count after CreateOne = %d, want 7", n)
+	}
+	if _, err := repo.Delete(db, daltest.OrderTable, byOrderNo("NO001")); err != nil {
+		t.Fatal(err)
+	}
+	if got := amountOf(t, repo, "NO001"); got != -1 {
+		t.Fatalf("read after Delete = %d, want not found", got)
+	}
+}
+
+func TestCacheNegativeTTL(t *testing.T) {
+	repo, db, mr := newCachedOrders(t, dal.CacheConfig{TTL: time.Minute, NegativeTTL: 5 * time.Second})
+
+	if got := amountOf(t, repo, "NO404"); got != -1 {
+		t.Fatalf("read missing row = %d", got)
+	}
+	keys := dataKeys(mr)
+	if len(keys) != 1 || mr.TTL(keys[0]) != 5*time.Second {
+		t.Fatalf("negative cache keys = %v, want one with ttl 5s", keys)
+	}
+
+	db.Exec("INSERT INTO "+daltest.OrderTable+" (order_no, amount) VALUES (?, ?)", "NO404", 404)
+	if got := amountOf(t, repo, "NO404"); got != -1 {
+		t.Fatalf("read within NegativeTTL = %d, want cached miss", got)
+	}
+	mr.FastForward(6 * time.Second)
+	if got := amountOf(t, repo, "NO404"); got != 404 {
+		t.Fatalf("read after NegativeTTL = %d, want 404", got)
+	}
+}
+
+// NegativeTTL 为 0 时空结果不缓存
+func TestCacheNoNegativeTTL(t *testing.T) {
+	repo, _, mr := newCachedOrders(t, dal.CacheConfig{TTL: time.Minute})
+	list, err := repo.FindMany(daltest.OrderTable, nil, dal.WhereOption{Cond: dal.Gt("amount", 5000)}, nil, nil)
+	if err != nil || len(list) != 0 {
+		t.Fatalf("FindMany = %v, %v", list, err)
+	}
+	if n, _ := repo.Count(daltest.OrderTable, dal.WhereOption{Cond: dal.Gt("amount", 5000)}); n != 0 || len(dataKeys(mr)) != 0 {
+		t.Fatalf("empty results cached without NegativeTTL: %v", dataKeys(mr))
+	}
+}
+
+func TestCacheBypassedInTx(t *testing.T) {
+	repo, _, mr := newCachedOrders(t, dal.CacheConfig{TTL: time.Minute, NegativeTTL: time.Minute})
+	if got := amountOf(t, repo, "NO002"); got != 200 {
+		t.Fatalf("read = %d", got)
+	}
+	cached := len(dataKeys(mr))
+
+	boom := stdErrors.New("boom")
+	err := repo.DBS.WithTx(context.Background(), func(ctx context.Context, tx *gorm.DB) error {
+		r := repo.WithContext(ctx)
+		if _, err := r.Update(tx, daltest.OrderTable, byOrderNo("NO002"), map[string]any{"amount": 999}); err != nil {
+			return err
+		}
+		// 事务内读到自己未提交的写入，而不是缓存中的旧值
+		if got := amountOf(t, r, "NO002"); got != 999 {
+			t.Errorf("read in tx = %d, want 999", got)
+		}
+		if got := amountOf(t, r, "NO003"); got != 300 {
+			t.Errorf("read in tx = %d, want 300", got)
+		}
+		return boom
+	})
+	if !stdErrors.Is(err, boom) {
+		t.Fatalf("WithTx error = %v", err)
+	}
+	if got := len(dataKeys(mr)); got != cached {
+		t.Fatalf("cache keys after tx = %d, want %d: reads in tx were cached", got, cached)
+	}
+	if got := amountOf(t, repo, "NO002"); got != 200 {
+		t.Fatalf("read after rollback = %d, want 200", got)
+	}
+}
+
+func TestCacheSingleflight(t *testing.T) {
+	repo, db, _ := newCachedOrders(t, dal.CacheConfig{TTL: time.Minute})
+
+	// 回源查询放慢并计数；DryRun 生成缓存 key 的语句不计
+	var loads atomic.Int32
+	err := db.Callback().Query().Before("gorm:query").Register("test:slow_load", func(tx *gorm.DB) {
+		if !tx.DryRun && tx.Statement.Table == daltest.OrderTable {
+			loads.Add(1)
+			time.Sleep(100 * time.Millisecond)
+		}
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	const callers = 10
+	var wg sync.WaitGroup
+	results := make([][]*daltest.Order, callers)
+	for i := 0; i < callers; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			list, err := repo.FindMany(daltest.OrderTable, nil, dal.WhereOption{Eq: map[string]any{"user_id": 1}}, ptr("id"), nil)
+			if err != nil {
+				t.Error(err)
+			}
+			results[i] = list
+		}(i)
+	}
+	wg.Wait()
+
+	if n := loads.Load(); n != 1 {
+		t.Fatalf("concurrent misses loaded %d times, want 1", n)
+	}
+	// 共享同一次回源的调用方各自拿到独立的副本
+	results[0][0].Amount = -1
+	for i := 1; i < callers; i++ {
+		if len(results[i]) != 3 || results[i][0].Amount != 300 {
+			t.Fatalf("caller %d got %+v", i, results[i])
+		}
+	}
+
+	if _, err := repo.FindMany(daltest.OrderTable, nil, dal.WhereOption{Eq: map[string]any{"user_id": 1}}, ptr("id"), nil); err != nil {
+		t.Fatal(err)
+	}
+	if n := loads.Load(); n != 1 {
+		t.Fatalf("read after load hit the database, loads = %d", n)
+	}
+}
@@ -0,0 +1,114 @@
+package dal
+
+import (
+	"context"
+	"net/http"
+
+	stdErrors "errors"
+
+	"github.com/xsda-pixel/common-infra/batch"
+	"github.com/xsda-pixel/common-infra/errors"
+
+	"gorm.io/gorm/clause"
+)
+
+var ErrChunkFailed = errors.NewError(http.StatusInternalServerError, errors.NewMsg("chunk processing failed"))
+
+// ChunkOption FindInChunks 的断点与进度配置
+type ChunkOption struct {
+	StartAfter  any                        // 从该主键之后开始（不含），用于断点续跑；nil 表示从头开始
+	OnChunkDone func(lastPK any, rows int) // 每块处理完成后回调，lastPK 为已完成块的最大主键，可持久化用于续跑
+}
+
+// FindInChunks 按主键递增分块遍历表：每次取 pk > lastPK 的 chunkSize 行，交给 exec 在其并发与限流配置下逐行执行 handler，
+// 整块成功后才推进 lastPK 并回调 OnChunkDone。返回最后完成的主键，出错时可用它作为 StartAfter 续跑。
+// handler 的错误会原样返回（若为 errors.Error），否则包装为 ErrChunkFailed。
+func (db *RepoDB[T]) FindInChunks(
+	tableName string,
+	pkColumn string,
+	chunkSize int,
+	where WhereOption,
+	exec *batch.BatchExecutor[*T],
+	handler func(context.Context, *T) error,
+	opt ChunkOption,
+) (any, errors.Error) {
+	op := &Operation{Kind: OpFindInChunks, Table: tableName, Fields: []string{pkColumn}, Where: where, Limit: &chunkSize, Args: opt}
+	return intercept(db, op, func(op *Operation) (any, errors.Error) {
+		return db.findInChunks(op.Table, pkColumn, pageSize(op.Limit), op.Where, exec, handler, opt)
+	})
+}
+
+func (db *RepoDB[T]) findInChunks(
+	tableName string,
+	pkColumn string,
+	chunkSize int,
+	where WhereOption,
+	exec *batch.BatchExecutor[*T],
+	handler func(context.Context, *T) error,
+	opt ChunkOption,
+) (any, errors.Error) {
+	if chunkSize <= 0 {
+		chunkSize = defaultChunkSize
+	}
+	if exec == nil {
+		exec = batch.NewBatchExecutor[*T]()
+	}
+
+	sch, err := db.modelSchema()
+	if err != nil {
+		return opt.StartAfter, db.wrapErr(err)
+	}
+	field := lookupField(sch, pkColumn)
+	if field == nil {
+		return opt.StartAfter, errors.NewError(http.StatusBadRequest, errors.NewMsg("pk column %s not found in model", pkColumn))
+	}
+
+	ctx := db.Context()
+	last := opt.StartAfter
+
+	for {
+		if err := ctx.Err(); err != nil {
+			return last, ClassifyError(ctx, err)
+		}
+
+		w := where
+		if last != nil {
+			w.Cond = And(w.Cond, Gt(pkColumn, last))
+		}
+
+		rs := db.table(db.conn(), tableName)
+
+		rs = applyWhere(rs, w)
+
+		rs = rs.Order(clause.OrderByColumn{Column: clause.Column{Name: pkColumn}})
+
+		var list []*T
+		if err := rs.Limit(chunkSize).Find(&list).Error; err != nil {
+			return last, db.wrapErr(err)
+		}
+		if len(list) == 0 {
+			return last, nil
+		}
+
+		if err := exec.Execute(ctx, list, handler); err != nil {
+			var e errors.Error
+			if stdErrors.As(err, &e) {
+				return last, e
+			}
+			return last, errors.WithCause(ErrChunkFailed, err)
+		}
+		// Execute 在 ctx 取消时提前结束且不返回错误，本块可能有行未处理，不能推进 last
+		if err := ctx.Err(); err != nil {
+			return last, ClassifyError(ctx, err)
+		}
+
+		last = db.fieldValue(field, list[len(list)-1])
+		if opt.OnChunkDone != nil {
+			opt.OnChunkDone(last, len(list))
+		}
+
+		if len(list) < chunkSize {
+			return last, nil
+		}
+	}
+}
@@ -0,0 +1,270 @@
+package dal
+
+import (
+	"reflect"
+
+	"gorm.io/gorm/clause"
+)
+
+// Cond 可组合的查询条件，由 applyWhere 编译为参数化的 gorm 子句，列名会按方言转义
+type Cond interface {
+	expr() clause.Expression
+}
+
+// 比较运算符
+const (
+	opEq  = "="
+	opNe  = "<>"
+	opGt  = ">"
+	opGte = ">="
+	opLt  = "<"
+	opLte = "<="
+)
+
+// 恒成立 / 恒不成立的条件，constExpr 据此识别不起过滤作用的 Cond
+var (
+	exprTrue  = clause.Expr{SQL: "1 = 1"}
+	exprFalse = clause.Expr{SQL: "1 = 0"}
+)
+
+type cmpCond struct {
+	op     string
+	column string
+	value  any
+}
+
+type inCond struct {
+	column string
+	values []any
+	not    bool
+}
+
+type betweenCond struct {
+	column    string
+	low, high any
+}
+
+type likeCond struct {
+	column  string
+	pattern string
+}
+
+type nullCond struct {
+	column string
+	not    bool
+}
+
+type groupCond struct {
+	or    bool
+	conds []Cond
+}
+
+type notCond struct {
+	cond Cond
+}
+
+// Eq column = value；value 为 nil 时等价于 IsNull
+func Eq(column string, value any) Cond { return cmpCond{op: opEq, column: column, value: value} }
+
+// Ne column <> value；value 为 nil 时等价于 NotNull
+func Ne(column string, value any) Cond { return cmpCond{op: opNe, column: column, value: value} }
+
+func Gt(column string, value any) Cond  { return cmpCond{op: opGt, column: column, value: value} }
+func Gte(column string, value any) Cond { return cmpCond{op: opGte, column: column, value: value} }
+func Lt(column string, value any) Cond  { return cmpCond{op: opLt, column: column, value: value} }
+func Lte(column string, value any) Cond { return cmpCond{op: opLte, column: column, value: value} }
+
+// In column IN (values...)；values 可以是任意切片/数组，为空时恒不成立
+func In(column string, values any) Cond {
+	return inCond{column: column, values: toAnySlice(values)}
+}
+
+// NotIn column NOT IN (values...)；values 为空时恒成立
+func NotIn(column string, values any) Cond {
+	return inCond{column: column, values: toAnySlice(values), not: true}
+}
+
+// Between column BETWEEN low AND high（闭区间）
+func Between(column string, low, high any) Cond {
+	return betweenCond{column: column, low: low, high: high}
+}
+
+// Like column LIKE pattern，通配符由调用方自行拼接
+func Like(column, pattern string) Cond { return likeCond{column: column, pattern: pattern} }
+
+func IsNull(column string) Cond  { return nullCond{column: column} }
+func NotNull(column string) Cond { return nullCond{column: column, not: true} }
+
+// And 以 AND 组合条件，nil 条件会被忽略
+func And(conds ...Cond) Cond { return groupCond{conds: conds} }
+
+// Or 以 OR 组合条件，整体会加括号，不会与外层条件混淆
+func Or(conds ...Cond) Cond { return groupCond{or: true, conds: conds} }
+
+// Not 对条件取反
+func Not(cond Cond) Cond { return notCond{cond: cond} }
+
+func (c cmpCond) expr() clause.Expression {
+	col := clause.Column{Name: c.column}
+	switch c.op {
+	case opNe:
+		return clause.Neq{Column: col, Value: c.value}
+	case opGt:
+		return clause.Gt{Column: col, Value: c.value}
+	case opGte:
+		return clause.Gte{Column: col, Value: c.value}
+	case opLt:
+		return clause.Lt{Column: col, Value: c.value}
+	case opLte:
+		return clause.Lte{Column: col, Value: c.value}
+	default:
+		return clause.Eq{Column: col, Value: c.value}
+	}
+}
+
+func (c inCond) expr() clause.Expression {
+	// gorm 对空集合生成 IN (NULL) / IS NOT NULL，语义与集合运算不一致，这里单独处理
+	if len(c.values) == 0 {
+		if c.not {
+			return exprTrue
+		}
+		return exprFalse
+	}
+	in := clause.IN{Column: clause.Column{Name: c.column}, Values: c.values}
+	if c.not {
+		return clause.Not(in)
+	}
+	return in
+}
+
+func (c betweenCond) expr() clause.Expression {
+	return clause.Expr{SQL: "? BETWEEN ? AND ?", Vars: []any{clause.Column{Name: c.column}, c.low, c.high}}
+}
+
+func (c likeCond) expr() clause.Expression {
+	return clause.Like{Column: clause.Column{Name: c.column}, Value: c.pattern}
+}
+
+func (c nullCond) expr() clause.Expression {
+	if c.not {
+		return clause.Neq{Column: clause.Column{Name: c.column}, Value: nil}
+	}
+	return clause.Eq{Column: clause.Column{Name: c.column}, Value: nil}
+}
+
+func (c groupCond) expr() clause.Expression {
+	exprs := make([]clause.Expression, 0, len(c.conds))
+	for _, sub := range c.conds {
+		if sub == nil {
+			continue
+		}
+		if e := sub.expr(); e != nil {
+			exprs = append(exprs, e)
+		}
+	}
+	switch {
+	case len(exprs) == 0:
+		return nil
+	case len(exprs) == 1:
+		// 单个 OrConditions 会被 gorm 当作 OR 连接到前一个条件上，这里直接返回子条件
+		return exprs[0]
+	case c.or:
+		return clause.Or(exprs...)
+	default:
+		return clause.And(exprs...)
+	}
+}
+
+func (c notCond) expr() clause.Expression {
+	if c.cond == nil {
+		return nil
+	}
+	e := c.cond.expr()
+	if e == nil {
+		return nil
+	}
+	return clause.Not(e)
+}
+
+// condEmpty Cond 不起过滤作用：为 nil、编译后没有谓词（如 And()）或恒成立（如 NotIn(col, 空集合)）
+func condEmpty(c Cond) bool {
+	if c == nil {
+		return true
+	}
+	e := c.expr()
+	if e == nil {
+		return true
+	}
+	v, ok := constExpr(e)
+	return ok && v
+}
+
+// constExpr 计算编译结果的常量值，ok 为 false 表示结果取决于数据
+func constExpr(e clause.Expression) (val, ok bool) {
+	switch x := e.(type) {
+	case clause.Expr:
+		if len(x.Vars) == 0 {
+			switch x.SQL {
+			case exprTrue.SQL:
+				return true, true
+			case exprFalse.SQL:
+				return false, true
+			}
+		}
+	case clause.AndConditions:
+		all := true
+		for _, sub := range x.Exprs {
+			v, ok := constExpr(sub)
+			if ok && !v {
+				return false, true
+			}
+			all = all && ok
+		}
+		return true, all
+	case clause.OrConditions:
+		all := true
+		for _, sub := range x.Exprs {
+			v, ok := constExpr(sub)
+			if ok && v {
+				return true, true
+			}
+			all = all && ok
+		}
+		return false, all
+	case clause.NotConditions:
+		// 多个子条件时 gorm 生成 NOT (a AND b)，只在全部为常量时求值
+		all := true
+		for _, sub := range x.Exprs {
+			v, ok := constExpr(sub)
+			if !ok {
+				return false, false
+			}
+			all = all && v
+		}
+		return !all, true
+	}
+	return false, false
+}
+
+// toAnySlice 将任意切片/数组展开为 []any，非切片值视为单元素
+func toAnySlice(values any) []any {
+	if values == nil {
+		return nil
+	}
+	if vs, ok := values.([]any); ok {
+		return vs
+	}
+	rv := reflect.ValueOf(values)
+	if rv.Kind() != reflect.Slice && rv.Kind() != reflect.Array {
+		return []any{values}
+	}
+	// []byte 是单个值而不是集合
+	if rv.Type().Elem().Kind() == reflect.Uint8 {
+		return []any{values}
+	}
+	out := make([]any, rv.Len())
+	for i := range out {
+		out[i] = rv.Index(i).Interface()
+	}
+	return out
+}
@@ -0,0 +1,341 @@
+package dal
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"net/http"
+	"reflect"
+	"strings"
+
+	"github.com/xsda-pixel/common-infra/errors"
+
+	"gorm.io/gorm/clause"
+	"gorm.io/gorm/schema"
+)
+
+var (
+	ErrInvalidCursor = errors.NewError(http.StatusBadRequest, errors.NewMsg("invalid cursor"))
+	ErrEncodeCursor  = errors.NewError(http.StatusInternalServerError, errors.NewMsg("encode cursor failed"))
+	// ErrNoCursorSecret 未通过 WithCursorSecret 配置游标签名密钥
+	ErrNoCursorSecret = errors.NewError(http.StatusInternalServerError, errors.NewMsg("cursor secret not configured"))
+)
+
+// OrderBy 结构化排序项
+type OrderBy struct {
+	Column string // 列名，联表时带别名，如 o.created_at
+	Desc   bool
+}
+
+// CursorPage 游标分页结果
+type CursorPage[T any] struct {
+	List       []*T
+	NextCursor string // 下一页游标，为空表示没有更多数据
+	PrevCursor string // 上一页游标，为空表示已是第一页
+}
+
+// cursorToken 游标内容：排序签名 + 方向 + 边界行的排序列取值
+type cursorToken struct {
+	Order  string            `json:"o"`
+	Prev   bool              `json:"p,omitempty"`
+	Values []json.RawMessage `json:"v"`
+}
+
+type seekKey struct {
+	OrderBy
+	field *schema.Field
+}
+
+// FindPageByCursor 游标（keyset）分页：按 orders 列做 seek，不使用 OFFSET，深翻页性能稳定且不受并发写入影响。
+// orders 末尾应为唯一列作为 tie-breaker，未包含主键时自动追加主键；排序列需为 NOT NULL。
+// fields 未包含排序列（含追加的主键）时会自动补进 SELECT，以便生成游标。
+// cursor 为空表示第一页，否则传入上次返回的 NextCursor / PrevCursor。
+// 游标以 HMAC 签名防篡改，仓库需通过 WithCursorSecret 配置密钥，否则返回 ErrNoCursorSecret。
+func (db *RepoDB[T]) FindPageByCursor(
+	tableName string,
+	fields []string,
+	where WhereOption,
+	orders []OrderBy,
+	cursor string,
+	limit int,
+) (*CursorPage[T], errors.Error) {
+	return db.FindPageByCursorWithJoin(tableName, fields, nil, where, orders, cursor, limit)
+}
+
+// FindPageByCursorWithJoin 联表版本的游标分页；排序列去掉别名后需能对应到 T 的字段
+func (db *RepoDB[T]) FindPageByCursorWithJoin(
+	tableName string,
+	fields []string,
+	joins []JoinOption,
+	where WhereOption,
+	orders []OrderBy,
+	cursor string,
+	limit int,
+) (*CursorPage[T], errors.Error) {
+	op := &Operation{Kind: OpFindPageByCursor, Table: tableName, Fields: fields, Joins: joins, Where: where, Limit: &limit, Item: cursor, Args: orders}
+	return intercept(db, op, func(op *Operation) (*CursorPage[T], errors.Error) {
+		return db.findPageByCursorWithJoin(op.Table, op.Fields, op.Joins, op.Where, orders, cursor, pageSize(op.Limit))
+	})
+}
+
+func (db *RepoDB[T]) findPageByCursorWithJoin(
+	tableName string,
+	fields []string,
+	joins []JoinOption,
+	where WhereOption,
+	orders []OrderBy,
+	cursor string,
+	limit int,
+) (*CursorPage[T], errors.Error) {
+	if err := db.checkQuery(fields, nil, joins); err != nil {
+		return nil, err
+	}
+	if err := db.checkOrderBy(orders); err != nil {
+		return nil, err
+	}
+	if len(db.config.CursorSecret) == 0 {
+		return nil, ErrNoCursorSecret
+	}
+
+	page := &CursorPage[T]{}
+
+	if limit < 1 {
+		return page, nil
+	}
+
+	sch, err := db.modelSchema()
+	if err != nil {
+		return nil, db.wrapErr(err)
+	}
+
+	keys, e := seekKeys(sch, tableName, orders)
+	if e != nil {
+		return nil, e
+	}
+	sig := orderSignature(keys)
+
+	var values []any
+	backward := false
+	if cursor != "" {
+		token, ok := db.decodeCursor(cursor)
+		if !ok || token.Order != sig || len(token.Values) != len(keys) {
+			return nil, ErrInvalidCursor
+		}
+		if values, ok = decodeSeekValues(keys, token.Values); !ok {
+			return nil, ErrInvalidCursor
+		}
+		backward = token.Prev
+		where.Cond = And(where.Cond, seekCond(keys, values, backward))
+	}
+
+	rs := db.table(db.conn(), tableName)
+
+	if len(fields) > 0 {
+		rs = rs.Select(dialectFields(rs, seekFields(fields, keys)))
+	}
+
+	rs = db.applyJoins(rs, joins)
+
+	rs = applyWhere(rs, where)
+
+	// 向前翻页时反转排序，取出后再倒序还原
+	for _, k := range keys {
+		rs = rs.Order(clause.OrderByColumn{Column: clause.Column{Name: k.Column}, Desc: k.Desc != backward})
+	}
+
+	var list []*T
+	if err := rs.Limit(limit + 1).Find(&list).Error; err != nil {
+		return nil, db.wrapErr(err)
+	}
+
+	more := len(list) > limit
+	if more {
+		list = list[:limit]
+	}
+	if backward {
+		for i, j := 0, len(list)-1; i < j; i, j = i+1, j-1 {
+			list[i], list[j] = list[j], list[i]
+		}
+	}
+
+	page.List = list
+	if len(list) == 0 {
+		return page, nil
+	}
+
+	first, last := list[0], list[len(list)-1]
+	if backward {
+		if more {
+			page.PrevCursor, e = db.encodeCursor(sig, keys, first, true)
+		}
+		if e == nil {
+			page.NextCursor, e = db.encodeCursor(sig, keys, last, false)
+		}
+	} else {
+		if more {
+			page.NextCursor, e = db.encodeCursor(sig, keys, last, false)
+		}
+		if e == nil && cursor != "" {
+			page.PrevCursor, e = db.encodeCursor(sig, keys, first, true)
+		}
+	}
+	if e != nil {
+		return nil, e
+	}
+
+	return page, nil
+}
+
+// seekFields 把 fields 中缺少的排序列追加到末尾，否则游标会编码零值；* / t.* 视为包含全部列
+func seekFields(fields []string, keys []seekKey) []string {
+	selected := make(map[string]struct{}, len(fields))
+	for _, f := range fields {
+		f = strings.TrimSpace(f)
+		if f == "*" || strings.HasSuffix(f, ".*") {
+			return fields
+		}
+		// "expr AS alias" 取别名
+		if i := strings.LastIndex(strings.ToLower(f), " as "); i >= 0 {
+			f = f[i+4:]
+		}
+		selected[bareColumn(f)] = struct{}{}
+	}
+
+	out := fields
+	for _, k := range keys {
+		if _, ok := selected[k.field.DBName]; ok {
+			continue
+		}
+		if len(out) == len(fields) {
+			out = append(append([]string(nil), fields...), k.Column)
+		} else {
+			out = append(out, k.Column)
+		}
+		selected[k.field.DBName] = struct{}{}
+	}
+	return out
+}
+
+// seekKeys 将排序项映射到模型字段，并在缺少主键时追加主键作为 tie-breaker
+func seekKeys(sch *schema.Schema, tableName string, orders []OrderBy) ([]seekKey, errors.Error) {
+	keys := make([]seekKey, 0, len(orders)+1)
+	hasPK := false
+	for _, o := range orders {
+		field := lookupField(sch, o.Column)
+		if field == nil {
+			return nil, errors.NewError(http.StatusBadRequest, errors.NewMsg("cursor order column %s not found in model", o.Column))
+		}
+		if field.PrimaryKey {
+			hasPK = true
+		}
+		keys = append(keys, seekKey{OrderBy: o, field: field})
+	}
+
+	if !hasPK {
+		pk := sch.PrioritizedPrimaryField
+		if pk == nil {
+			if len(keys) == 0 {
+				return nil, errors.NewError(http.StatusBadRequest, errors.NewMsg("cursor pagination requires order columns"))
+			}
+			return keys, nil // 没有主键时由调用方保证排序列组合唯一
+		}
+		desc := len(keys) > 0 && keys[len(keys)-1].Desc
+		keys = append(keys, seekKey{
+			OrderBy: OrderBy{Column: tableAlias(tableName) + "." + pk.DBName, Desc: desc},
+			field:   pk,
+		})
+	}
+
+	return keys, nil
+}
+
+// seekCond 生成 (c1 > v1) OR (c1 = v1 AND c2 > v2) OR ... 形式的 seek 条件
+func seekCond(keys []seekKey, values []any, backward bool) Cond {
+	ors := make([]Cond, 0, len(keys))
+	for i, k := range keys {
+		ands := make([]Cond, 0, i+1)
+		for j := 0; j < i; j++ {
+			ands = append(ands, Eq(keys[j].Column, values[j]))
+		}
+		if k.Desc != backward {
+			ands = append(ands, Lt(k.Column, values[i]))
+		} else {
+			ands = append(ands, Gt(k.Column, values[i]))
+		}
+		ors = append(ors, And(ands...))
+	}
+	return Or(ors...)
+}
+
+// orderSignature 排序签名，防止游标被用于另一种排序
+func orderSignature(keys []seekKey) string {
+	var b strings.Builder
+	for _, k := range keys {
+		b.WriteString(k.Column)
+		if k.Desc {
+			b.WriteString(" desc")
+		}
+		b.WriteByte(',')
+	}
+	sum := sha256.Sum256([]byte(b.String()))
+	return base64.RawURLEncoding.EncodeToString(sum[:8])
+}
+
+func (db *RepoDB[T]) encodeCursor(sig string, keys []seekKey, item *T, prev bool) (string, errors.Error) {
+	token := cursorToken{Order: sig, Prev: prev, Values: make([]json.RawMessage, len(keys))}
+	for i, k := range keys {
+		raw, err := json.Marshal(db.fieldValue(k.field, item))
+		if err != nil {
+			return "", errors.WithCause(ErrEncodeCursor, err)
+		}
+		token.Values[i] = raw
+	}
+
+	payload, err := json.Marshal(token)
+	if err != nil {
+		return "", errors.WithCause(ErrEncodeCursor, err)
+	}
+	return base64.RawURLEncoding.EncodeToString(payload) + "." + base64.RawURLEncoding.EncodeToString(db.cursorMAC(payload)), nil
+}
+
+// decodeCursor 校验签名并解析游标，签名不符视为被篡改
+func (db *RepoDB[T]) decodeCursor(cursor string) (*cursorToken, bool) {
+	payloadPart, macPart, ok := strings.Cut(cursor, ".")
+	if !ok {
+		return nil, false
+	}
+	payload, err := base64.RawURLEncoding.DecodeString(payloadPart)
+	if err != nil {
+		return nil, false
+	}
+	mac, err := base64.RawURLEncoding.DecodeString(macPart)
+	if err != nil || !hmac.Equal(mac, db.cursorMAC(payload)) {
+		return nil, false
+	}
+
+	var token cursorToken
+	if err := json.Unmarshal(payload, &token); err != nil {
+		return nil, false
+	}
+	return &token, true
+}
+
+// decodeSeekValues 按字段类型还原游标中的取值，保证时间、金额等类型以原类型参与比较
+func decodeSeekValues(keys []seekKey, raws []json.RawMessage) ([]any, bool) {
+	values := make([]any, len(keys))
+	for i, k := range keys {
+		ptr := reflect.New(k.field.FieldType)
+		if err := json.Unmarshal(raws[i], ptr.Interface()); err != nil {
+			return nil, false
+		}
+		values[i] = ptr.Elem().Interface()
+	}
+	return values, true
+}
+
+func (db *RepoDB[T]) cursorMAC(payload []byte) []byte {
+	h := hmac.New(sha256.New, db.config.CursorSecret)
+	h.Write(payload)
+	return h.Sum(nil)
+}
@@ -1,13 +1,15 @@
 package dal
 
 import (
+	"context"
 	"net/http"
+	"sort"
 
 	"github.com/xsda-pixel/common-infra/errors"
-	"github.com/xsda-pixel/common-infra/logs"
 
 	stdErrors "errors"
 
+	"golang.org/x/sync/errgroup"
 	"gorm.io/gorm"
 	"gorm.io/gorm/clause"
 )
@@ -15,8 +17,15 @@ import (
 var dbErr = errors.NewError(http.StatusBadRequest, errors.NewMsg("db error"))
 
 type WhereOption struct {
-	Eq  map[string]any // 等值条件
-	Raw *RawWhere      // 原生 SQL 条件
+	Eq   map[string]any // 等值条件
+	Raw  *RawWhere      // 原生 SQL 条件
+	Cond Cond           // 组合条件（In/Between/Or 等），与 Eq、Raw 以 AND 连接
+}
+
+// IsEmpty 是否没有任何过滤条件；Cond 编译后没有谓词或恒成立（如 And()、NotIn(col, 空集合)）同样视为空，
+// 删除与恢复据此拒绝会作用于整表的条件
+func (w WhereOption) IsEmpty() bool {
+	return len(w.Eq) == 0 && w.Raw == nil && condEmpty(w.Cond)
 }
 
 type RawWhere struct {
@@ -25,12 +34,16 @@ type RawWhere struct {
 }
 
 type JoinOption struct {
-	Type  string // LEFT / INNER / RIGHT
-	Table string // 关联表
-	On    string // 关联条件
+	Type       string // LEFT / INNER / RIGHT
+	Table      string // 关联表
+	On         string // 关联条件
+	SkipTenant bool   // 关联表没有租户列（如全局字典表）时跳过租户条件
 }
 
+// ReadRepo / WriteRepo 的方法不显式接收 ctx；需要超时/取消控制时，通过 ReadWithContext / WriteWithContext 得到绑定 ctx 的仓库
 type ReadRepo[T any] interface {
+	ReadWithContext(ctx context.Context) ReadRepo[T]
+
 	FindOne(
 		tableName string,
 		fields []string,
@@ -72,6 +85,8 @@ type ReadRepo[T any] interface {
 }
 
 type WriteRepo[T any] interface {
+	WriteWithContext(ctx context.Context) WriteRepo[T]
+
 	CreateOne(
 		db *gorm.DB,
 		tableName string,
@@ -90,6 +105,28 @@ type WriteRepo[T any] interface {
 		tableName string,
 		where WhereOption,
 	) (int64, errors.Error)
+
+	CreateMany(
+		db *gorm.DB,
+		tableName string,
+		items []*T,
+		chunkSize int,
+	) ([]int64, errors.Error)
+
+	Upsert(
+		db *gorm.DB,
+		tableName string,
+		items []*T,
+		opt UpsertOption,
+	) ([]int64, errors.Error)
+
+	BulkUpdateByKey(
+		db *gorm.DB,
+		tableName string,
+		keyColumn string,
+		rows []KeyedUpdate,
+		chunkSize int,
+	) ([]int64, errors.Error)
 }
 
 var (
@@ -98,38 +135,63 @@ var (
 )
 
 func (db *RepoDB[T]) CreateOne(dbs *gorm.DB, tbName string, item *T) errors.Error {
+	_, err := intercept(db, &Operation{Kind: OpCreateOne, DB: dbs, Table: tbName, Item: item}, func(op *Operation) (any, errors.Error) {
+		return nil, db.createOne(op.DB, op.Table, item)
+	})
+	return err
+}
+
+func (db *RepoDB[T]) createOne(dbs *gorm.DB, tbName string, item *T) errors.Error {
 	if item == nil {
 		return errors.NewError(http.StatusBadRequest, errors.NewMsg("CreateOne: item is nil"))
 	}
-	rs := dbs.Table(tbName).Create(item)
-	if rs.Error != nil {
-		logs.Logger.Error(rs.Error)
-		return dbErr
+	if err := db.stampTenant(item); err != nil {
+		return err
+	}
+	err := db.auditedCreate(dbs, tbName, item, func(tx *gorm.DB) errors.Error {
+		if err := db.bind(tx).Table(tbName).Create(item).Error; err != nil {
+			return db.wrapErr(err)
+		}
+		return nil
+	})
+	if err != nil {
+		return err
 	}
+	db.afterWrite(tbName)
 	return nil
 }
 
 func (db *RepoDB[T]) FindOne(tableName string, fields []string, where WhereOption) (*T, errors.Error) {
-	var item T
+	op := &Operation{Kind: OpFindOne, Table: tableName, Fields: fields, Where: where}
+	return intercept(db, op, func(op *Operation) (*T, errors.Error) {
+		return db.findOne(op.Table, op.Fields, op.Where)
+	})
+}
 
-	rs := db.MySQL.Table(tableName)
+func (db *RepoDB[T]) findOne(tableName string, fields []string, where WhereOption) (*T, errors.Error) {
+	if err := db.checkQuery(fields, nil, nil); err != nil {
+		return nil, err
+	}
+
+	rs := db.table(db.cachedConn(), tableName)
 
 	if len(fields) > 0 {
-		rs = rs.Select(fields)
+		rs = rs.Select(dialectFields(rs, fields))
 	}
 
 	rs = applyWhere(rs, where)
 
-	if err := rs.Take(&item).Error; err != nil {
-		if stdErrors.Is(err, gorm.ErrRecordNotFound) {
-			// 正常业务分支：没查到
-			return nil, nil
+	return readThrough(db, cacheOpOne, tableName, rs, isNilItem[T], func(rs *gorm.DB) (*T, errors.Error) {
+		var item T
+		if err := rs.Take(&item).Error; err != nil {
+			if stdErrors.Is(err, gorm.ErrRecordNotFound) {
+				// 正常业务分支：没查到
+				return nil, nil
+			}
+			return nil, db.wrapErr(err)
 		}
-		logs.Logger.Error(err)
-		return nil, dbErr
-	}
-
-	return &item, nil
+		return &item, nil
+	})
 }
 
 func (db *RepoDB[T]) FindOneForUpdate(
@@ -138,85 +200,140 @@ func (db *RepoDB[T]) FindOneForUpdate(
 	fields []string,
 	where WhereOption,
 ) (*T, errors.Error) {
+	op := &Operation{Kind: OpFindOneForUpdate, DB: tx, Table: tableName, Fields: fields, Where: where}
+	return intercept(db, op, func(op *Operation) (*T, errors.Error) {
+		return db.findOneForUpdate(op.DB, op.Table, op.Fields, op.Where)
+	})
+}
+
+func (db *RepoDB[T]) findOneForUpdate(
+	tx *gorm.DB,
+	tableName string,
+	fields []string,
+	where WhereOption,
+) (*T, errors.Error) {
+	if err := db.checkQuery(fields, nil, nil); err != nil {
+		return nil, err
+	}
+
 	var item T
 
-	rs := tx.Table(tableName)
+	rs := db.table(db.bind(tx), tableName)
 
 	if len(fields) > 0 {
-		rs = rs.Select(fields)
+		rs = rs.Select(dialectFields(rs, fields))
 	}
 
 	rs = applyWhere(rs, where)
 
-	rs = rs.Clauses(clause.Locking{
-		Strength: "UPDATE", // FOR UPDATE
-	})
+	rs = forUpdate(rs, "") // FOR UPDATE，SQLite 不加锁
 
 	if err := rs.Take(&item).Error; err != nil {
 		if stdErrors.Is(err, gorm.ErrRecordNotFound) {
 			// 正常业务分支：没查到
 			return nil, nil
 		}
-		logs.Logger.Error(err)
-		return nil, dbErr
+		return nil, db.wrapErr(err)
 	}
 
 	return &item, nil
 }
 
 func (db *RepoDB[T]) FindMany(tableName string, fields []string, where WhereOption, order *string, limit *int) ([]*T, errors.Error) {
-	var list []*T
+	op := &Operation{Kind: OpFindMany, Table: tableName, Fields: fields, Where: where, Order: order, Limit: limit}
+	return intercept(db, op, func(op *Operation) ([]*T, errors.Error) {
+		return db.findMany(op.Table, op.Fields, op.Where, op.Order, op.Limit)
+	})
+}
+
+func (db *RepoDB[T]) findMany(tableName string, fields []string, where WhereOption, order *string, limit *int) ([]*T, errors.Error) {
+	if err := db.checkQuery(fields, order, nil); err != nil {
+		return nil, err
+	}
 
-	rs := db.MySQL.Table(tableName)
+	rs := db.table(db.cachedConn(), tableName)
 
 	if len(fields) > 0 {
-		rs = rs.Select(fields)
+		rs = rs.Select(dialectFields(rs, fields))
 	}
 
 	rs = applyWhere(rs, where)
 
 	if order != nil && *order != "" {
-		rs = rs.Order(*order)
+		rs = rs.Order(dialectSQL(rs, *order))
 	}
 
 	if limit != nil && *limit > 0 {
 		rs = rs.Limit(*limit)
 	}
 
-	rs = rs.Find(&list)
+	return readThrough(db, cacheOpMany, tableName, rs, isEmptyList[T], func(rs *gorm.DB) ([]*T, errors.Error) {
+		var list []*T
+		if err := rs.Find(&list).Error; err != nil {
+			return nil, db.wrapErr(err)
+		}
+		return list, nil
+	})
+}
 
-	if rs.Error != nil {
-		logs.Logger.Error(rs.Error)
-		return nil, dbErr
-	}
+func (db *RepoDB[T]) FindManyWithGroupBy(
+	tableName string,
+	fields []string,
+	groupBy string,
+	where WhereOption,
+	order *string,
+	limit *int,
+) ([]*T, errors.Error) {
+	return db.FindManyWithGroupByHaving(tableName, fields, groupBy, where, WhereOption{}, order, limit)
+}
 
-	return list, nil
+// FindManyWithGroupByHaving 分组查询并按 having 过滤分组；having 中的列名不加表名前缀，可直接引用聚合别名
+func (db *RepoDB[T]) FindManyWithGroupByHaving(
+	tableName string,
+	fields []string,
+	groupBy string,
+	where WhereOption,
+	having WhereOption,
+	order *string,
+	limit *int,
+) ([]*T, errors.Error) {
+	op := &Operation{Kind: OpFindManyWithGroupByHaving, Table: tableName, Fields: fields, Where: where, Having: having, Order: order, Limit: limit, Args: groupBy}
+	return intercept(db, op, func(op *Operation) ([]*T, errors.Error) {
+		return db.findManyWithGroupByHaving(op.Table, op.Fields, groupBy, op.Where, op.Having, op.Order, op.Limit)
+	})
 }
 
-func (db *RepoDB[T]) FindManyWithGroupBy(
+func (db *RepoDB[T]) findManyWithGroupByHaving(
 	tableName string,
 	fields []string,
 	groupBy string,
 	where WhereOption,
+	having WhereOption,
 	order *string,
 	limit *int,
 ) ([]*T, errors.Error) {
+	if err := db.checkQuery(fields, order, nil); err != nil {
+		return nil, err
+	}
+
 	var list []*T
 
-	rs := db.MySQL.Table(tableName)
+	rs := db.table(db.conn(), tableName)
 
 	if len(fields) > 0 {
-		rs = rs.Select(fields)
+		rs = rs.Select(dialectFields(rs, fields))
 	}
 
 	if groupBy != "" {
-		rs = rs.Group(groupBy)
+		rs = rs.Group(dialectSQL(rs, groupBy))
 	}
 
 	rs = applyWhere(rs, where)
 
+	rs = applyHaving(rs, having)
+
 	if order != nil && *order != "" {
-		rs = rs.Order(*order)
+		rs = rs.Order(dialectSQL(rs, *order))
 	}
 
 	if limit != nil && *limit > 0 {
@@ -226,8 +343,7 @@ func (db *RepoDB[T]) FindManyWithGroupBy(
 	rs = rs.Find(&list)
 
 	if rs.Error != nil {
-		logs.Logger.Error(rs.Error)
-		return nil, dbErr
+		return nil, db.wrapErr(rs.Error)
 	}
 
 	return list, nil
@@ -240,6 +356,23 @@ func (db *RepoDB[T]) FindPage(
 	where WhereOption,
 	order *string,
 ) ([]*T, errors.Error) {
+	op := &Operation{Kind: OpFindPage, Table: tableName, Page: page, Limit: &limit, Fields: fields, Where: where, Order: order}
+	return intercept(db, op, func(op *Operation) ([]*T, errors.Error) {
+		return db.findPage(op.Table, op.Page, pageSize(op.Limit), op.Fields, op.Where, op.Order)
+	})
+}
+
+func (db *RepoDB[T]) findPage(
+	tableName string,
+	page, limit int,
+	fields []string,
+	where WhereOption,
+	order *string,
+) ([]*T, errors.Error) {
+	if err := db.checkQuery(fields, order, nil); err != nil {
+		return nil, err
+	}
+
 	var (
 		list []*T
 	)
@@ -253,29 +386,28 @@ func (db *RepoDB[T]) FindPage(
 		return list, nil // 防止 (page-1)*limit 溢出
 	}
 
-	rs := db.MySQL.Table(tableName)
+	rs := db.table(db.conn(), tableName)
 
 	if len(fields) > 0 {
-		rs = rs.Select(fields)
+		rs = rs.Select(dialectFields(rs, fields))
 	}
 
 	rs = applyWhere(rs, where)
 
 	if order != nil && *order != "" {
-		rs = rs.Order(*order)
+		rs = rs.Order(dialectSQL(rs, *order))
 	}
 
 	rs = rs.Limit(limit).Offset(offset).Find(&list)
 
 	if rs.Error != nil {
-		logs.Logger.Error(rs.Error)
-		return nil, dbErr
+		return nil, db.wrapErr(rs.Error)
 	}
 
 	return list, nil
 }
 
-// FindPageWithTotal 分页查询并返回当前页数据和符合条件的总条数
+// FindPageWithTotal 分页查询并返回当前页数据和符合条件的总条数；两条查询并发执行（WithTx 中串行），总数的缓存与估算见 WithTotal
 func (db *RepoDB[T]) FindPageWithTotal(
 	tableName string,
 	page, limit int,
@@ -283,6 +415,31 @@ func (db *RepoDB[T]) FindPageWithTotal(
 	where WhereOption,
 	order *string,
 ) ([]*T, int64, errors.Error) {
+	op := &Operation{Kind: OpFindPageWithTotal, Table: tableName, Page: page, Limit: &limit, Fields: fields, Where: where, Order: order}
+	res, err := intercept(db, op, func(op *Operation) (*PageResult[T], errors.Error) {
+		list, total, err := db.findPageWithTotal(op.Table, op.Page, pageSize(op.Limit), op.Fields, op.Where, op.Order)
+		if err != nil {
+			return nil, err
+		}
+		return &PageResult[T]{List: list, Total: total}, nil
+	})
+	if err != nil || res == nil {
+		return nil, 0, err
+	}
+	return res.List, res.Total, nil
+}
+
+func (db *RepoDB[T]) findPageWithTotal(
+	tableName string,
+	page, limit int,
+	fields []string,
+	where WhereOption,
+	order *string,
+) ([]*T, int64, errors.Error) {
+	if err := db.checkQuery(fields, order, nil); err != nil {
+		return nil, 0, err
+	}
+
 	var list []*T
 
 	if page < 1 || limit < 1 {
@@ -294,28 +451,34 @@ func (db *RepoDB[T]) FindPageWithTotal(
 		return list, 0, nil // 防止 (page-1)*limit 溢出
 	}
 
-	total, err := db.Count(tableName, where)
-	if err != nil {
-		return nil, 0, err
+	// 总数与当前页并发查询，任一出错时通过 ctx 取消另一个
+	var total int64
+	g, ctx := errgroup.WithContext(db.Context())
+	if _, inTx := txStateFrom(ctx); inTx {
+		g.SetLimit(1) // 同一事务连接不能并发使用
 	}
+	repo := db.WithContext(ctx)
 
-	rs := db.MySQL.Table(tableName)
-	if len(fields) > 0 {
-		rs = rs.Select(fields)
-	}
-
-	rs = applyWhere(rs, where)
-	if order != nil && *order != "" {
-		rs = rs.Order(*order)
-	}
-
-	rs = rs.Limit(limit).Offset(offset).Find(&list)
+	g.Go(func() error {
+		n, e := repo.total(tableName, where)
+		if e != nil {
+			return e
+		}
+		total = n
+		return nil
+	})
+	g.Go(func() error {
+		rows, e := repo.findPage(tableName, page, limit, fields, where, order)
+		if e != nil {
+			return e
+		}
+		list = rows
+		return nil
+	})
 
-	if rs.Error != nil {
-		logs.Logger.Error(rs.Error)
-		return nil, 0, dbErr
+	if err := g.Wait(); err != nil {
+		return nil, 0, err.(errors.Error)
 	}
-
 	return list, total, nil
 }
 
@@ -323,38 +486,57 @@ func (db *RepoDB[T]) Count(
 	tableName string,
 	where WhereOption,
 ) (int64, errors.Error) {
+	op := &Operation{Kind: OpCount, Table: tableName, Where: where}
+	return intercept(db, op, func(op *Operation) (int64, errors.Error) {
+		return db.count(op.Table, op.Where)
+	})
+}
 
-	var count int64
-
-	rs := db.MySQL.Table(tableName)
+func (db *RepoDB[T]) count(
+	tableName string,
+	where WhereOption,
+) (int64, errors.Error) {
+	rs := db.table(db.cachedConn(), tableName)
 
 	rs = applyWhere(rs, where)
 
-	rs = rs.Count(&count)
-
-	if rs.Error != nil {
-		logs.Logger.Error(rs.Error)
-		return 0, dbErr
-	}
-
-	return count, nil
+	return readThrough(db, cacheOpCount, tableName, rs, isZeroCount, func(rs *gorm.DB) (int64, errors.Error) {
+		var count int64
+		if err := rs.Count(&count).Error; err != nil {
+			return 0, db.wrapErr(err)
+		}
+		return count, nil
+	})
 }
 
 func (db *RepoDB[T]) SumInt64(
 	tableName string,
 	expr string,
 	where WhereOption,
+) (int64, errors.Error) {
+	op := &Operation{Kind: OpSumInt64, Table: tableName, Fields: []string{expr}, Where: where}
+	return intercept(db, op, func(op *Operation) (int64, errors.Error) {
+		if len(op.Fields) == 0 {
+			return 0, invalidQuery("SumInt64: expr is empty")
+		}
+		return db.sumInt64(op.Table, op.Fields[0], op.Where)
+	})
+}
+
+func (db *RepoDB[T]) sumInt64(
+	tableName string,
+	expr string,
+	where WhereOption,
 ) (int64, errors.Error) {
 	var result int64
 
-	rs := db.MySQL.Table(tableName).
+	rs := db.table(db.conn(), tableName).
 		Select(expr)
 
 	rs = applyWhere(rs, where)
 
 	if err := rs.Scan(&result).Error; err != nil {
-		logs.Logger.Error(err)
-		return 0, dbErr
+		return 0, db.wrapErr(err)
 	}
 
 	return result, nil
@@ -363,18 +545,27 @@ func (db *RepoDB[T]) SumInt64(
 func (db *RepoDB[T]) Exists(
 	tableName string,
 	where WhereOption,
+) (bool, errors.Error) {
+	op := &Operation{Kind: OpExists, Table: tableName, Where: where}
+	return intercept(db, op, func(op *Operation) (bool, errors.Error) {
+		return db.exists(op.Table, op.Where)
+	})
+}
+
+func (db *RepoDB[T]) exists(
+	tableName string,
+	where WhereOption,
 ) (bool, errors.Error) {
 	var tmp int
 
-	rs := db.MySQL.Table(tableName).Select("1")
+	rs := db.table(db.conn(), tableName).Select("1")
 
 	rs = applyWhere(rs, where)
 
 	rs = rs.Limit(1).Scan(&tmp)
 
 	if rs.Error != nil {
-		logs.Logger.Error(rs.Error)
-		return false, dbErr
+		return false, db.wrapErr(rs.Error)
 	}
 
 	return rs.RowsAffected > 0, nil
@@ -385,46 +576,120 @@ func (db *RepoDB[T]) Update(
 	tableName string,
 	where WhereOption,
 	updates map[string]any,
+) (int64, errors.Error) {
+	op := &Operation{Kind: OpUpdate, DB: dbs, Table: tableName, Where: where, Updates: updates}
+	return intercept(db, op, func(op *Operation) (int64, errors.Error) {
+		return db.update(op.DB, op.Table, op.Where, op.Updates)
+	})
+}
+
+func (db *RepoDB[T]) update(
+	dbs *gorm.DB,
+	tableName string,
+	where WhereOption,
+	updates map[string]any,
 ) (int64, errors.Error) {
 	if len(updates) == 0 {
 		return 0, nil
 	}
+	if err := db.checkTenantUpdates(updates); err != nil {
+		return 0, err
+	}
 
-	rs := dbs.Table(tableName)
+	rows, err := db.audited(dbs, tableName, AuditUpdate, where, func(tx *gorm.DB, where WhereOption) (int64, errors.Error) {
+		rs, err := db.writeTable(tx, tableName)
+		if err != nil {
+			return 0, err
+		}
 
-	rs = applyWhere(rs, where)
+		rs = applyWhere(rs, where)
 
-	rs = rs.Updates(updates)
+		rs = rs.Updates(updates)
 
-	if rs.Error != nil {
-		logs.Logger.Error(rs.Error)
-		return 0, dbErr
+		if rs.Error != nil {
+			return 0, db.wrapErr(rs.Error)
+		}
+		return rs.RowsAffected, nil
+	})
+	if err != nil {
+		return 0, err
+	}
+
+	if rows > 0 {
+		db.afterWrite(tableName)
 	}
 
-	return rs.RowsAffected, nil
+	return rows, nil
 }
 
+// Delete 删除符合条件的行；配置了软删除时改为标记删除，物理删除使用 HardDelete
 func (db *RepoDB[T]) Delete(
 	dbs *gorm.DB,
 	tableName string,
 	where WhereOption,
 ) (int64, errors.Error) {
-	rs := dbs.Table(tableName)
+	op := &Operation{Kind: OpDelete, DB: dbs, Table: tableName, Where: where}
+	return intercept(db, op, func(op *Operation) (int64, errors.Error) {
+		return db.deleteRows(op.DB, op.Table, op.Where)
+	})
+}
 
-	if len(where.Eq) == 0 && where.Raw == nil {
+func (db *RepoDB[T]) deleteRows(
+	dbs *gorm.DB,
+	tableName string,
+	where WhereOption,
+) (int64, errors.Error) {
+	if db.config.SoftDelete != nil {
+		return db.softDelete(dbs, tableName, where)
+	}
+	return db.hardDelete(dbs, tableName, where)
+}
+
+// HardDelete 物理删除，不受软删除配置影响
+func (db *RepoDB[T]) HardDelete(
+	dbs *gorm.DB,
+	tableName string,
+	where WhereOption,
+) (int64, errors.Error) {
+	op := &Operation{Kind: OpHardDelete, DB: dbs, Table: tableName, Where: where}
+	return intercept(db, op, func(op *Operation) (int64, errors.Error) {
+		return db.hardDelete(op.DB, op.Table, op.Where)
+	})
+}
+
+func (db *RepoDB[T]) hardDelete(
+	dbs *gorm.DB,
+	tableName string,
+	where WhereOption,
+) (int64, errors.Error) {
+	if where.IsEmpty() {
 		return 0, errors.NewError(http.StatusBadRequest, errors.NewMsg("delete without where is forbidden")) // 防止误删
 	}
 
-	rs = applyWhere(rs, where)
+	rows, err := db.audited(dbs, tableName, AuditDelete, where, func(tx *gorm.DB, where WhereOption) (int64, errors.Error) {
+		rs, err := db.writeTable(tx, tableName)
+		if err != nil {
+			return 0, err
+		}
 
-	rs = rs.Delete(nil)
+		rs = applyWhere(rs, where)
 
-	if rs.Error != nil {
-		logs.Logger.Error(rs.Error)
-		return 0, dbErr
+		rs = rs.Delete(nil)
+
+		if rs.Error != nil {
+			return 0, db.wrapErr(rs.Error)
+		}
+		return rs.RowsAffected, nil
+	})
+	if err != nil {
+		return 0, err
+	}
+
+	if rows > 0 {
+		db.afterWrite(tableName)
 	}
 
-	return rs.RowsAffected, nil
+	return rows, nil
 }
 
 func (db *RepoDB[T]) FindManyWithJoin(
@@ -435,20 +700,38 @@ func (db *RepoDB[T]) FindManyWithJoin(
 	order *string,
 	limit *int,
 ) ([]*T, errors.Error) {
+	op := &Operation{Kind: OpFindManyWithJoin, Table: tableName, Fields: fields, Joins: joins, Where: where, Order: order, Limit: limit}
+	return intercept(db, op, func(op *Operation) ([]*T, errors.Error) {
+		return db.findManyWithJoin(op.Table, op.Fields, op.Joins, op.Where, op.Order, op.Limit)
+	})
+}
+
+func (db *RepoDB[T]) findManyWithJoin(
+	tableName string,
+	fields []string,
+	joins []JoinOption,
+	where WhereOption,
+	order *string,
+	limit *int,
+) ([]*T, errors.Error) {
+	if err := db.checkQuery(fields, order, joins); err != nil {
+		return nil, err
+	}
+
 	var list []*T
 
-	rs := db.MySQL.Table(tableName)
+	rs := db.table(db.conn(), tableName)
 
 	if len(fields) > 0 {
-		rs = rs.Select(fields)
+		rs = rs.Select(dialectFields(rs, fields))
 	}
 
-	rs = applyJoins(rs, joins)
+	rs = db.applyJoins(rs, joins)
 
 	rs = applyWhere(rs, where)
 
 	if order != nil && *order != "" {
-		rs = rs.Order(*order)
+		rs = rs.Order(dialectSQL(rs, *order))
 	}
 
 	if limit != nil && *limit > 0 {
@@ -458,8 +741,7 @@ func (db *RepoDB[T]) FindManyWithJoin(
 	rs = rs.Find(&list)
 
 	if rs.Error != nil {
-		logs.Logger.Error(rs.Error)
-		return nil, dbErr
+		return nil, db.wrapErr(rs.Error)
 	}
 
 	return list, nil
@@ -473,6 +755,24 @@ func (db *RepoDB[T]) FindPageWithJoin(
 	where WhereOption,
 	order *string,
 ) ([]*T, errors.Error) {
+	op := &Operation{Kind: OpFindPageWithJoin, Table: tableName, Page: page, Limit: &limit, Fields: fields, Joins: joins, Where: where, Order: order}
+	return intercept(db, op, func(op *Operation) ([]*T, errors.Error) {
+		return db.findPageWithJoin(op.Table, op.Page, pageSize(op.Limit), op.Fields, op.Joins, op.Where, op.Order)
+	})
+}
+
+func (db *RepoDB[T]) findPageWithJoin(
+	tableName string,
+	page, limit int,
+	fields []string,
+	joins []JoinOption,
+	where WhereOption,
+	order *string,
+) ([]*T, errors.Error) {
+	if err := db.checkQuery(fields, order, joins); err != nil {
+		return nil, err
+	}
+
 	var list []*T
 
 	if page < 1 || limit < 1 {
@@ -484,30 +784,47 @@ func (db *RepoDB[T]) FindPageWithJoin(
 		return list, nil // 防止 (page-1)*limit 溢出
 	}
 
-	rs := db.MySQL.Table(tableName)
+	rs := db.table(db.conn(), tableName)
 
 	if len(fields) > 0 {
-		rs = rs.Select(fields)
+		rs = rs.Select(dialectFields(rs, fields))
 	}
 
-	rs = applyJoins(rs, joins)
+	rs = db.applyJoins(rs, joins)
 
 	rs = applyWhere(rs, where)
 
 	if order != nil && *order != "" {
-		rs = rs.Order(*order)
+		rs = rs.Order(dialectSQL(rs, *order))
 	}
 
 	rs = rs.Limit(limit).Offset(offset).Find(&list)
 
 	if rs.Error != nil {
-		logs.Logger.Error(rs.Error)
-		return nil, dbErr
+		return nil, db.wrapErr(rs.Error)
 	}
 
 	return list, nil
 }
 
+// table 在 rs 上开始对 tableName 的查询，并附加仓库级的读过滤（租户、软删除等）；
+// 缺少租户时错误记录在返回的 rs 上，查询执行时返回 ErrTenantRequired
+func (db *RepoDB[T]) table(rs *gorm.DB, tableName string) *gorm.DB {
+	rs = rs.Table(tableName)
+	tc, err := db.tenantCond(tableName)
+	if err != nil {
+		_ = rs.AddError(err)
+		return rs
+	}
+	if tc != nil {
+		rs = rs.Where(tc.expr())
+	}
+	if c := db.softDeleteCond(tableName); c != nil {
+		rs = rs.Where(c.expr())
+	}
+	return rs
+}
+
 func applyWhere(rs *gorm.DB, w WhereOption) *gorm.DB {
 	if len(w.Eq) > 0 {
 		rs = rs.Where(w.Eq)
@@ -515,16 +832,59 @@ func applyWhere(rs *gorm.DB, w WhereOption) *gorm.DB {
 	if w.Raw != nil {
 		rs = rs.Where(w.Raw.SQL, w.Raw.Args...)
 	}
+	if w.Cond != nil {
+		if e := w.Cond.expr(); e != nil {
+			rs = rs.Where(e)
+		}
+	}
+	return rs
+}
+
+// applyHaving 与 applyWhere 相同，但 Eq 的列名不加表名前缀，便于引用聚合别名
+func applyHaving(rs *gorm.DB, h WhereOption) *gorm.DB {
+	if len(h.Eq) > 0 {
+		keys := make([]string, 0, len(h.Eq))
+		for k := range h.Eq {
+			keys = append(keys, k)
+		}
+		sort.Strings(keys)
+		for _, k := range keys {
+			rs = rs.Having(clause.Eq{Column: clause.Column{Name: k}, Value: h.Eq[k]})
+		}
+	}
+	if h.Raw != nil {
+		rs = rs.Having(h.Raw.SQL, h.Raw.Args...)
+	}
+	if h.Cond != nil {
+		if e := h.Cond.expr(); e != nil {
+			rs = rs.Having(e)
+		}
+	}
 	return rs
 }
 
-func applyJoins(rs *gorm.DB, joins []JoinOption) *gorm.DB {
+// applyJoins 追加联表；开启租户隔离时关联表的 ON 同样追加租户条件，SkipTenant 的表除外
+func (db *RepoDB[T]) applyJoins(rs *gorm.DB, joins []JoinOption) *gorm.DB {
 	for _, j := range joins {
 		joinType := "LEFT JOIN"
 		if j.Type != "" {
 			joinType = j.Type + " JOIN"
 		}
-		rs = rs.Joins(joinType + " " + j.Table + " ON " + j.On)
+
+		var tc Cond
+		if !j.SkipTenant {
+			var err errors.Error
+			if tc, err = db.tenantCond(j.Table); err != nil {
+				_ = rs.AddError(err)
+				return rs
+			}
+		}
+		table, on := dialectSQL(rs, j.Table), dialectSQL(rs, j.On)
+		if tc == nil {
+			rs = rs.Joins(joinType + " " + table + " ON " + on)
+			continue
+		}
+		rs = rs.Joins(joinType+" "+table+" ON ("+on+") AND ?", tc.expr())
 	}
 	return rs
 }
@@ -599,4 +959,26 @@ list, err := repo.FindPageWithJoin(
 	},
 	ptr("u.id DESC"),
 )
+
+
+Target:
+
+SELECT * FROM orders
+WHERE status IN (1,2) AND amount BETWEEN 100 AND 500
+  AND (remark LIKE '%vip%' OR channel_id IS NULL)
+
+Use:
+list, err := repo.FindMany(
+	"orders",
+	nil,
+	WhereOption{
+		Cond: And(
+			In("status", []int{1, 2}),
+			Between("amount", 100, 500),
+			Or(Like("remark", "%vip%"), IsNull("channel_id")),
+		),
+	},
+	nil,
+	nil,
+)
 */
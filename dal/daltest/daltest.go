@@ -0,0 +1,724 @@
+// Package daltest 提供 dal.RepoDB 的方言一致性测试套件，由调用方在自己的 _test.go 中针对具体方言运行，例如进程内 SQLite：
+//
+//	func TestRepoSQLite(t *testing.T) {
+//		daltest.Run(t, func(t *testing.T) *gorm.DB {
+//			db, err := gorm.Open(sqlite.Open("file:"+t.Name()+"?mode=memory&cache=shared"), &gorm.Config{})
+//			if err != nil {
+//				t.Fatal(err)
+//			}
+//			return db
+//		})
+//	}
+//
+// 每个子测试调用一次 open，套件自行建表并写入数据，open 返回的库应为空库（或至少不含 daltest_ 前缀的表）；
+// 运行前需先 logs.Init。
+package daltest
+
+import (
+	"bytes"
+	"context"
+	"encoding/base64"
+	stdErrors "errors"
+	"fmt"
+	"net/http"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/xsda-pixel/common-infra/batch"
+	"github.com/xsda-pixel/common-infra/dal"
+	"github.com/xsda-pixel/common-infra/errors"
+	"github.com/xsda-pixel/common-infra/types/amount"
+
+	"gorm.io/gorm"
+)
+
+const (
+	OrderTable = "daltest_orders"
+	UserTable  = "daltest_users"
+	AuditTable = "daltest_audit_log"
+)
+
+// Order 套件使用的订单模型
+type Order struct {
+	ID        int64      `gorm:"primaryKey"`
+	UserID    int64      `gorm:"index"`
+	OrderNo   string     `gorm:"size:32;uniqueIndex"`
+	Status    int        `gorm:"not null;default:0"`
+	Amount    int64      `gorm:"not null;default:0"`
+	Version   int64      `gorm:"not null;default:0"`
+	DeletedAt *time.Time `gorm:"index"`
+	CreatedAt time.Time
+}
+
+// User 联表用的用户模型
+type User struct {
+	ID   int64  `gorm:"primaryKey"`
+	Name string `gorm:"size:64"`
+}
+
+// Opener 为一个子测试打开数据库
+type Opener func(t *testing.T) *gorm.DB
+
+type suite struct {
+	t   *testing.T
+	db  *gorm.DB
+	dbs *dal.DBS
+}
+
+// Run 依次运行全部用例，每个用例使用 open 返回的新库
+func Run(t *testing.T, open Opener) {
+	cases := []struct {
+		name string
+		fn   func(s *suite)
+	}{
+		{"CreateOne", testCreateOne},
+		{"CreateManyUpsert", testCreateManyUpsert},
+		{"BulkUpdateByKey", testBulkUpdateByKey},
+		{"FindOne", testFindOne},
+		{"FindOneForUpdate", testFindOneForUpdate},
+		{"FindMany", testFindMany},
+		{"FindManyWithGroupBy", testFindManyWithGroupBy},
+		{"FindPage", testFindPage},
+		{"FindWithJoin", testFindWithJoin},
+		{"FindPageByCursor", testFindPageByCursor},
+		{"FindEach", testFindEach},
+		{"FindInChunks", testFindInChunks},
+		{"CountExistsSum", testCountExistsSum},
+		{"Aggregate", testAggregate},
+		{"Update", testUpdate},
+		{"UpdateWithVersion", testUpdateWithVersion},
+		{"Delete", testDelete},
+		{"SoftDelete", testSoftDelete},
+		{"Tenant", testTenant},
+		{"Audit", testAudit},
+		{"WithTx", testWithTx},
+	}
+	for _, c := range cases {
+		c := c
+		t.Run(c.name, func(t *testing.T) {
+			db := open(t)
+			s := &suite{t: t, db: db, dbs: dal.NewDB(db, nil)}
+			s.migrate()
+			c.fn(s)
+		})
+	}
+}
+
+func (s *suite) migrate() {
+	s.t.Helper()
+	if err := s.db.Table(OrderTable).AutoMigrate(&Order{}); err != nil {
+		s.t.Fatalf("migrate %s: %v", OrderTable, err)
+	}
+	if err := s.db.Table(UserTable).AutoMigrate(&User{}); err != nil {
+		s.t.Fatalf("migrate %s: %v", UserTable, err)
+	}
+	if err := s.db.Table(AuditTable).AutoMigrate(&dal.AuditRecord{}); err != nil {
+		s.t.Fatalf("migrate %s: %v", AuditTable, err)
+	}
+}
+
+func (s *suite) repo(opts ...func(*dal.RepoConfig)) *dal.RepoDB[Order] {
+	return dal.NewRepoDB[Order](s.dbs, opts...)
+}
+
+// seed 写入 3 个用户与 10 个订单：user_id = i%3+1，amount = i*100，status = i%2
+func (s *suite) seed() []*Order {
+	s.t.Helper()
+	users := dal.NewRepoDB[User](s.dbs)
+	for i := int64(1); i <= 3; i++ {
+		s.ok(users.CreateOne(s.db, UserTable, &User{ID: i, Name: fmt.Sprintf("user%d", i)}))
+	}
+	orders := make([]*Order, 0, 10)
+	for i := 1; i <= 10; i++ {
+		orders = append(orders, &Order{
+			UserID:  int64(i%3 + 1),
+			OrderNo: fmt.Sprintf("NO%03d", i),
+			Status:  i % 2,
+			Amount:  int64(i * 100),
+		})
+	}
+	_, err := s.repo().CreateMany(s.db, OrderTable, orders, 4)
+	s.ok(err)
+	return orders
+}
+
+func (s *suite) ok(err errors.Error) {
+	s.t.Helper()
+	if err != nil {
+		s.t.Fatalf("unexpected error: %v", err)
+	}
+}
+
+func (s *suite) is(err errors.Error, target error) {
+	s.t.Helper()
+	if !stdErrors.Is(err, target) {
+		s.t.Fatalf("error = %v, want %v", err, target)
+	}
+}
+
+func (s *suite) eq(name string, got, want any) {
+	s.t.Helper()
+	if fmt.Sprint(got) != fmt.Sprint(want) {
+		s.t.Fatalf("%s = %v, want %v", name, got, want)
+	}
+}
+
+func eqWhere(col string, v any) dal.WhereOption {
+	return dal.WhereOption{Eq: map[string]any{col: v}}
+}
+
+func ptr[V any](v V) *V {
+	return &v
+}
+
+func orderNos(list []*Order) []string {
+	nos := make([]string, len(list))
+	for i, o := range list {
+		nos[i] = o.OrderNo
+	}
+	return nos
+}
+
+func testCreateOne(s *suite) {
+	repo := s.repo()
+	o := &Order{UserID: 1, OrderNo: "A1", Amount: 10}
+	s.ok(repo.CreateOne(s.db, OrderTable, o))
+	if o.ID == 0 {
+		s.t.Fatal("primary key not filled after CreateOne")
+	}
+	s.is(repo.CreateOne(s.db, OrderTable, &Order{UserID: 1, OrderNo: "A1"}), dal.ErrDuplicateKey)
+}
+
+func testCreateManyUpsert(s *suite) {
+	repo := s.repo()
+	counts, err := repo.CreateMany(s.db, OrderTable, []*Order{
+		{UserID: 1, OrderNo: "U1", Amount: 1},
+		{UserID: 1, OrderNo: "U2", Amount: 2},
+		{UserID: 1, OrderNo: "U3", Amount: 3},
+	}, 2)
+	s.ok(err)
+	s.eq("CreateMany counts", counts, []int64{2, 1})
+
+	upserts := []*Order{
+		{UserID: 1, OrderNo: "U1", Amount: 100},
+		{UserID: 1, OrderNo: "U4", Amount: 4},
+	}
+	counts, err = repo.Upsert(s.db, OrderTable, upserts, dal.UpsertOption{ConflictColumns: []string{"order_no"}, UpdateColumns: []string{"amount"}})
+	s.ok(err)
+	want := []int64{2}
+	if dal.Dialect(s.db) == dal.DialectMySQL {
+		want = []int64{3} // 更新计 2
+	}
+	s.eq("Upsert counts", counts, want)
+
+	o, err := repo.FindOne(OrderTable, nil, eqWhere("order_no", "U1"))
+	s.ok(err)
+	s.eq("upserted amount", o.Amount, 100)
+	if dal.Dialect(s.db) != dal.DialectMySQL {
+		s.eq("upserted id", upserts[0].ID, o.ID) // RETURNING 回填已有行主键
+	}
+	n, err := repo.Count(OrderTable, dal.WhereOption{})
+	s.ok(err)
+	s.eq("count after upsert", n, 4)
+}
+
+func testBulkUpdateByKey(s *suite) {
+	orders := s.seed()
+	repo := s.repo()
+	counts, err := repo.BulkUpdateByKey(s.db, OrderTable, "id", []dal.KeyedUpdate{
+		{Key: orders[0].ID, Values: map[string]any{"amount": 1}},
+		{Key: orders[1].ID, Values: map[string]any{"amount": 2, "status": 9}},
+	}, 10)
+	s.ok(err)
+	s.eq("BulkUpdateByKey counts", counts, []int64{2})
+
+	o, err := repo.FindOne(OrderTable, nil, eqWhere("id", orders[1].ID))
+	s.ok(err)
+	s.eq("amount", o.Amount, 2)
+	s.eq("status", o.Status, 9)
+	o, err = repo.FindOne(OrderTable, nil, eqWhere("id", orders[0].ID))
+	s.ok(err)
+	s.eq("untouched status", o.Status, orders[0].Status)
+}
+
+func testFindOne(s *suite) {
+	s.seed()
+	repo := s.repo()
+	o, err := repo.FindOne(OrderTable, []string{"id", "order_no"}, eqWhere("order_no", "NO003"))
+	s.ok(err)
+	if o == nil || o.OrderNo != "NO003" || o.Amount != 0 {
+		s.t.Fatalf("FindOne with fields = %+v", o)
+	}
+	o, err = repo.FindOne(OrderTable, nil, eqWhere("order_no", "missing"))
+	s.ok(err)
+	if o != nil {
+		s.t.Fatalf("FindOne not found = %+v, want nil", o)
+	}
+
+	// 只持有接口时通过 ReadWithContext 传入 ctx
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	var read dal.ReadRepo[Order] = repo
+	_, err = read.ReadWithContext(ctx).FindOne(OrderTable, nil, eqWhere("order_no", "NO003"))
+	s.is(err, dal.ErrQueryCanceled)
+}
+
+func testFindOneForUpdate(s *suite) {
+	s.seed()
+	repo := s.repo()
+	err := s.db.Transaction(func(tx *gorm.DB) error {
+		o, err := repo.FindOneForUpdate(tx, OrderTable, nil, eqWhere("order_no", "NO001"))
+		if err != nil {
+			return err
+		}
+		if o == nil {
+			return fmt.Errorf("FindOneForUpdate returned nil")
+		}
+		_, err = repo.Update(tx, OrderTable, eqWhere("id", o.ID), map[string]any{"amount": o.Amount + 1})
+		if err != nil {
+			return err
+		}
+		return nil
+	})
+	if err != nil {
+		s.t.Fatal(err)
+	}
+	o, e := repo.FindOne(OrderTable, nil, eqWhere("order_no", "NO001"))
+	s.ok(e)
+	s.eq("amount", o.Amount, 101)
+}
+
+func testFindMany(s *suite) {
+	s.seed()
+	repo := s.repo()
+	list, err := repo.FindMany(OrderTable, nil, dal.WhereOption{
+		Eq:   map[string]any{"status": 0},
+		Cond: dal.Or(dal.Gt("amount", 500), dal.In("user_id", []int64{2})),
+	}, dal.Orders(dal.OrderBy{Column: "amount", Desc: true}), ptr(3))
+	s.ok(err)
+	s.eq("FindMany", orderNos(list), []string{"NO010", "NO008", "NO006"})
+
+	list, err = repo.FindMany(OrderTable, nil, dal.WhereOption{
+		Raw: &dal.RawWhere{SQL: "amount BETWEEN ? AND ?", Args: []any{200, 400}},
+	}, ptr("order_no DESC"), nil)
+	s.ok(err)
+	s.eq("FindMany raw", orderNos(list), []string{"NO004", "NO003", "NO002"})
+}
+
+func testFindManyWithGroupBy(s *suite) {
+	s.seed()
+	list, err := s.repo().FindManyWithGroupByHaving(OrderTable,
+		[]string{"user_id", "MAX(amount) AS amount"}, "user_id",
+		dal.WhereOption{}, dal.WhereOption{Raw: &dal.RawWhere{SQL: "MAX(amount) >= ?", Args: []any{900}}},
+		ptr("user_id"), nil)
+	s.ok(err)
+	got := make([]string, len(list))
+	for i, o := range list {
+		got[i] = fmt.Sprintf("%d:%d", o.UserID, o.Amount)
+	}
+	s.eq("FindManyWithGroupByHaving", got, []string{"1:900", "2:1000"})
+}
+
+func testFindPage(s *suite) {
+	s.seed()
+	repo := s.repo()
+	list, err := repo.FindPage(OrderTable, 2, 3, nil, dal.WhereOption{}, ptr("id"))
+	s.ok(err)
+	s.eq("FindPage", orderNos(list), []string{"NO004", "NO005", "NO006"})
+
+	list, total, err := repo.FindPageWithTotal(OrderTable, 4, 3, nil, dal.WhereOption{}, ptr("id"))
+	s.ok(err)
+	s.eq("FindPageWithTotal list", orderNos(list), []string{"NO010"})
+	s.eq("FindPageWithTotal total", total, 10)
+}
+
+func testFindWithJoin(s *suite) {
+	s.seed()
+	repo := s.repo()
+	joins := []dal.JoinOption{{Type: "INNER", Table: UserTable + " u", On: "u.id = o.user_id"}}
+	where := dal.WhereOption{Cond: dal.Eq("u.name", "user2")}
+
+	list, err := repo.FindManyWithJoin(OrderTable+" o", []string{"o.id", "o.order_no", "o.user_id"}, joins, where,
+		dal.Orders(dal.OrderBy{Column: "o.order_no"}), nil)
+	s.ok(err)
+	s.eq("FindManyWithJoin", orderNos(list), []string{"NO001", "NO004", "NO007", "NO010"})
+
+	list, err = repo.FindPageWithJoin(OrderTable+" o", 2, 3, []string{"o.id", "o.order_no"}, joins, where, ptr("`o`.`order_no`"))
+	s.ok(err)
+	s.eq("FindPageWithJoin", orderNos(list), []string{"NO010"})
+}
+
+func testFindPageByCursor(s *suite) {
+	s.seed()
+	repo := s.repo(dal.WithCursorSecret([]byte("secret-a")))
+	orders := []dal.OrderBy{{Column: "user_id"}, {Column: "amount", Desc: true}}
+
+	var got []string
+	cursor := ""
+	var last *dal.CursorPage[Order]
+	for i := 0; i < 10; i++ {
+		page, err := repo.FindPageByCursor(OrderTable, nil, dal.WhereOption{}, orders, cursor, 4)
+		s.ok(err)
+		got = append(got, orderNos(page.List)...)
+		last = page
+		if page.NextCursor == "" {
+			break
+		}
+		cursor = page.NextCursor
+	}
+	s.eq("FindPageByCursor", got, []string{
+		"NO009", "NO006", "NO003", // user 1
+		"NO010", "NO007", "NO004", "NO001", // user 2
+		"NO008", "NO005", "NO002", // user 3
+	})
+
+	prev, err := repo.FindPageByCursor(OrderTable, nil, dal.WhereOption{}, orders, last.PrevCursor, 4)
+	s.ok(err)
+	s.eq("FindPageByCursor prev", orderNos(prev.List), []string{"NO007", "NO004", "NO001", "NO008"})
+
+	// 同一密钥的另一个仓库实例（如重启或其他实例）可以继续翻页
+	next, err := s.repo(dal.WithCursorSecret([]byte("secret-a"))).FindPageByCursor(OrderTable, nil, dal.WhereOption{}, orders, last.PrevCursor, 4)
+	s.ok(err)
+	s.eq("FindPageByCursor same secret", orderNos(next.List), orderNos(prev.List))
+
+	// 篡改或其他密钥签发的游标被拒绝
+	payload, mac, _ := strings.Cut(last.PrevCursor, ".")
+	raw, _ := base64.RawURLEncoding.DecodeString(payload)
+	forged := base64.RawURLEncoding.EncodeToString(bytes.Replace(raw, []byte(`"p":true`), []byte(`"p":false`), 1)) + "." + mac
+	other := s.repo(dal.WithCursorSecret([]byte("secret-b")))
+	for name, fn := range map[string]func() errors.Error{
+		"tampered": func() errors.Error {
+			_, err := repo.FindPageByCursor(OrderTable, nil, dal.WhereOption{}, orders, forged, 4)
+			return err
+		},
+		"other secret": func() errors.Error {
+			_, err := other.FindPageByCursor(OrderTable, nil, dal.WhereOption{}, orders, last.PrevCursor, 4)
+			return err
+		},
+	} {
+		if err := fn(); !stdErrors.Is(err, dal.ErrInvalidCursor) {
+			s.t.Fatalf("FindPageByCursor with %s cursor error = %v, want ErrInvalidCursor", name, err)
+		}
+	}
+
+	if _, err := s.repo().FindPageByCursor(OrderTable, nil, dal.WhereOption{}, orders, "", 4); !stdErrors.Is(err, dal.ErrNoCursorSecret) {
+		s.t.Fatalf("FindPageByCursor without secret error = %v, want ErrNoCursorSecret", err)
+	}
+}
+
+func testFindEach(s *suite) {
+	s.seed()
+	repo := s.repo()
+	var sum int64
+	s.ok(repo.FindEach(OrderTable, nil, eqWhere("status", 1), nil, func(o *Order) errors.Error {
+		sum += o.Amount
+		return nil
+	}))
+	s.eq("FindEach sum", sum, 2500)
+
+	it := repo.FindIter(OrderTable, []string{"id"}, dal.WhereOption{}, nil, 2)
+	n := 0
+	for range it.C() {
+		n++
+	}
+	s.ok(it.Err())
+	s.eq("FindIter rows", n, 10)
+}
+
+func testFindInChunks(s *suite) {
+	s.seed()
+	var chunks []int
+	var total int64
+	seen := make(chan int64, 10)
+	last, err := s.repo().FindInChunks(OrderTable, "id", 4, dal.WhereOption{}, batch.NewBatchExecutor[*Order](batch.WithConcurrency(2)),
+		func(_ context.Context, o *Order) error {
+			seen <- o.Amount
+			return nil
+		},
+		dal.ChunkOption{OnChunkDone: func(_ any, rows int) { chunks = append(chunks, rows) }})
+	s.ok(err)
+	close(seen)
+	for a := range seen {
+		total += a
+	}
+	s.eq("FindInChunks chunks", chunks, []int{4, 4, 2})
+	s.eq("FindInChunks total", total, 5500)
+	if last == nil {
+		s.t.Fatal("FindInChunks returned nil last pk")
+	}
+}
+
+func testCountExistsSum(s *suite) {
+	s.seed()
+	repo := s.repo()
+	n, err := repo.Count(OrderTable, eqWhere("user_id", 1))
+	s.ok(err)
+	s.eq("Count", n, 3)
+
+	ok, err := repo.Exists(OrderTable, eqWhere("order_no", "NO005"))
+	s.ok(err)
+	s.eq("Exists", ok, true)
+	ok, err = repo.Exists(OrderTable, eqWhere("order_no", "missing"))
+	s.ok(err)
+	s.eq("Exists missing", ok, false)
+
+	sum, err := repo.SumInt64(OrderTable, "COALESCE(SUM(amount), 0)", eqWhere("user_id", 2))
+	s.ok(err)
+	s.eq("SumInt64", sum, 2200)
+}
+
+func testAggregate(s *suite) {
+	s.seed()
+	repo := s.repo()
+	where := eqWhere("user_id", 1) // 300, 600, 900
+	for _, c := range []struct {
+		name string
+		fn   func(string, string, dal.WhereOption) (int64, errors.Error)
+		want int64
+	}{
+		{"Sum", rawAgg(repo.Sum), 1800},
+		{"Min", rawAgg(repo.Min), 300},
+		{"Max", rawAgg(repo.Max), 900},
+		{"Avg", rawAgg(repo.Avg), 600},
+	} {
+		got, err := c.fn(OrderTable, "amount", where)
+		s.ok(err)
+		s.eq(c.name, got, c.want)
+	}
+
+	rows, err := repo.GroupAggregate(OrderTable, dal.GroupOption{
+		GroupBy: []string{"user_id"},
+		Aggs:    []dal.Agg{{Func: dal.AggSum, Column: "amount", Alias: "total"}, {Func: dal.AggCount, Column: "*", Alias: "n"}},
+		Having:  dal.WhereOption{Cond: dal.Gt("total", 1800)},
+		Order:   dal.Orders(dal.OrderBy{Column: "total", Desc: true}),
+	})
+	s.ok(err)
+	got := make([]string, len(rows))
+	for i, r := range rows {
+		got[i] = fmt.Sprintf("%v:%d:%d", r.Keys["user_id"], r.Values["total"].Int().Int64(), r.Counts["n"])
+	}
+	s.eq("GroupAggregate", got, []string{"2:2200:4"})
+}
+
+func rawAgg(fn func(string, string, dal.WhereOption) (amount.Amount, errors.Error)) func(string, string, dal.WhereOption) (int64, errors.Error) {
+	return func(table, column string, where dal.WhereOption) (int64, errors.Error) {
+		a, err := fn(table, column, where)
+		if err != nil {
+			return 0, err
+		}
+		return a.Int().Int64(), nil
+	}
+}
+
+func testUpdate(s *suite) {
+	s.seed()
+	repo := s.repo()
+	n, err := repo.Update(s.db, OrderTable, eqWhere("user_id", 3), map[string]any{"status": 7})
+	s.ok(err)
+	s.eq("Update rows", n, 3)
+	n, err = repo.Count(OrderTable, eqWhere("status", 7))
+	s.ok(err)
+	s.eq("updated count", n, 3)
+}
+
+func testUpdateWithVersion(s *suite) {
+	s.seed()
+	repo := s.repo()
+	where := eqWhere("order_no", "NO001")
+	n, err := repo.UpdateWithVersion(s.db, OrderTable, where, 0, map[string]any{"amount": 1})
+	s.ok(err)
+	s.eq("UpdateWithVersion rows", n, 1)
+	_, err = repo.UpdateWithVersion(s.db, OrderTable, where, 0, map[string]any{"amount": 2})
+	s.is(err, dal.ErrVersionConflict)
+
+	_, err = repo.UpdateWithVersionRetry(s.db, OrderTable, where, 3, func(o *Order) (map[string]any, errors.Error) {
+		return map[string]any{"amount": o.Amount + 10}, nil
+	})
+	s.ok(err)
+	o, err := repo.FindOne(OrderTable, nil, where)
+	s.ok(err)
+	s.eq("amount", o.Amount, 11)
+	s.eq("version", o.Version, 2)
+
+	// 事务中读取的是本事务已写入的版本，不会因读到事务外的旧版本而冲突
+	txErr := s.db.Transaction(func(tx *gorm.DB) error {
+		if _, err := repo.UpdateWithVersion(tx, OrderTable, where, 2, map[string]any{"amount": 20}); err != nil {
+			return err
+		}
+		_, err := repo.UpdateWithVersionRetry(tx, OrderTable, where, 0, func(o *Order) (map[string]any, errors.Error) {
+			return map[string]any{"amount": o.Amount + 1}, nil
+		})
+		if err != nil {
+			return err
+		}
+		return nil
+	})
+	if txErr != nil {
+		s.t.Fatalf("UpdateWithVersionRetry in tx: %v", txErr)
+	}
+	o, err = repo.FindOne(OrderTable, nil, where)
+	s.ok(err)
+	s.eq("amount in tx", o.Amount, 21)
+	s.eq("version in tx", o.Version, 4)
+}
+
+func testDelete(s *suite) {
+	s.seed()
+	repo := s.repo()
+	_, err := repo.Delete(s.db, OrderTable, dal.WhereOption{})
+	if err == nil {
+		s.t.Fatal("Delete without where succeeded")
+	}
+
+	// 编译后没有谓词或恒成立的条件同样视为没有条件，各删除/恢复路径都返回 400 且不影响任何行
+	soft := s.repo(dal.WithSoftDelete("deleted_at"))
+	for _, where := range []dal.WhereOption{
+		{Cond: dal.NotIn("id", []int64{})},
+		{Cond: dal.And()},
+		{Cond: dal.Or(dal.Eq("status", 1), dal.NotIn("id", []int64{}))},
+		{Cond: dal.Not(dal.In("id", []int64{}))},
+	} {
+		for name, del := range map[string]func() (int64, errors.Error){
+			"Delete":      func() (int64, errors.Error) { return repo.Delete(s.db, OrderTable, where) },
+			"HardDelete":  func() (int64, errors.Error) { return repo.HardDelete(s.db, OrderTable, where) },
+			"soft Delete": func() (int64, errors.Error) { return soft.Delete(s.db, OrderTable, where) },
+			"Restore":     func() (int64, errors.Error) { return soft.Restore(s.db, OrderTable, where) },
+		} {
+			if _, err := del(); err == nil || err.ErrCode() != http.StatusBadRequest {
+				s.t.Fatalf("%s with always-true where %+v: error = %v, want 400", name, where.Cond, err)
+			}
+		}
+	}
+	n, err := repo.IncludeDeleted().Count(OrderTable, dal.WhereOption{})
+	s.ok(err)
+	s.eq("rows after rejected deletes", n, 10)
+
+	n, err = repo.Delete(s.db, OrderTable, eqWhere("status", 1))
+	s.ok(err)
+	s.eq("Delete rows", n, 5)
+	n, err = repo.HardDelete(s.db, OrderTable, dal.WhereOption{Cond: dal.Lte("amount", 400)})
+	s.ok(err)
+	s.eq("HardDelete rows", n, 2)
+	n, err = repo.Count(OrderTable, dal.WhereOption{})
+	s.ok(err)
+	s.eq("remaining", n, 3)
+}
+
+func testSoftDelete(s *suite) {
+	s.seed()
+	repo := s.repo(dal.WithSoftDelete("deleted_at"))
+	n, err := repo.Delete(s.db, OrderTable, eqWhere("user_id", 1))
+	s.ok(err)
+	s.eq("soft Delete rows", n, 3)
+
+	n, err = repo.Count(OrderTable, dal.WhereOption{})
+	s.ok(err)
+	s.eq("alive", n, 7)
+	n, err = repo.IncludeDeleted().Count(OrderTable, dal.WhereOption{})
+	s.ok(err)
+	s.eq("with deleted", n, 10)
+
+	list, err := repo.FindManyWithJoin(OrderTable+" o", []string{"o.id"},
+		[]dal.JoinOption{{Table: UserTable + " u", On: "u.id = o.user_id"}}, dal.WhereOption{}, nil, nil)
+	s.ok(err)
+	s.eq("alive with join", len(list), 7)
+
+	n, err = repo.Restore(s.db, OrderTable, eqWhere("order_no", "NO003"))
+	s.ok(err)
+	s.eq("Restore rows", n, 1)
+	n, err = repo.Count(OrderTable, dal.WhereOption{})
+	s.ok(err)
+	s.eq("alive after restore", n, 8)
+}
+
+func testTenant(s *suite) {
+	s.seed()
+	repo := s.repo(dal.WithTenant("user_id"))
+	_, err := repo.Count(OrderTable, dal.WhereOption{})
+	s.is(err, dal.ErrTenantRequired)
+
+	n, err := repo.ForTenant(int64(2)).Count(OrderTable, dal.WhereOption{})
+	s.ok(err)
+	s.eq("tenant count", n, 4)
+
+	list, err := repo.ForTenant(int64(2)).FindManyWithJoin(OrderTable+" o", []string{"o.id", "o.order_no"},
+		[]dal.JoinOption{{Table: UserTable + " u", On: "u.id = o.user_id", SkipTenant: true}}, dal.WhereOption{},
+		ptr("o.order_no"), ptr(1))
+	s.ok(err)
+	s.eq("tenant join", orderNos(list), []string{"NO001"})
+
+	n, err = repo.ForTenant(int64(2)).Update(s.db, OrderTable, dal.WhereOption{Cond: dal.Gt("amount", 0)}, map[string]any{"status": 5})
+	s.ok(err)
+	s.eq("tenant update", n, 4)
+
+	// 冲突行属于其他租户时 Upsert 不覆盖该行；同租户的冲突行正常更新
+	_, err = repo.ForTenant(int64(3)).Upsert(s.db, OrderTable, []*Order{{ID: 1, OrderNo: "NO001", Amount: 1}}, dal.UpsertOption{})
+	s.ok(err)
+	_, err = repo.ForTenant(int64(2)).Upsert(s.db, OrderTable, []*Order{{ID: 4, OrderNo: "NO004", Amount: 2}}, dal.UpsertOption{})
+	s.ok(err)
+	list, err = repo.ForTenant(int64(2)).FindMany(OrderTable, nil, dal.WhereOption{Cond: dal.In("id", []int64{1, 4})}, ptr("id"), nil)
+	s.ok(err)
+	got := make([]string, 0, len(list))
+	for _, o := range list {
+		got = append(got, fmt.Sprintf("%d:%d", o.ID, o.Amount))
+	}
+	s.eq("tenant upsert", got, []string{"1:100", "4:2"})
+}
+
+func testAudit(s *suite) {
+	s.seed()
+	ctx := dal.WithActor(context.Background(), "daltest")
+	repo := s.repo(dal.WithAudit(dal.AuditConfig{Table: AuditTable})).WithContext(ctx)
+	_, err := repo.Update(s.db, OrderTable, eqWhere("user_id", 1), map[string]any{"status": 3})
+	s.ok(err)
+
+	var records []dal.AuditRecord
+	if err := s.db.Table(AuditTable).Order("id").Find(&records).Error; err != nil {
+		s.t.Fatal(err)
+	}
+	s.eq("audit records", len(records), 3)
+	s.eq("audit actor", records[0].Actor, "daltest")
+	s.eq("audit action", records[0].Action, dal.AuditUpdate)
+}
+
+func testWithTx(s *suite) {
+	s.seed()
+	repo := s.repo()
+	boom := stdErrors.New("boom")
+	err := s.dbs.WithTx(context.Background(), func(ctx context.Context, tx *gorm.DB) error {
+		if _, err := repo.WithContext(ctx).Update(tx, OrderTable, eqWhere("user_id", 1), map[string]any{"amount": 0}); err != nil {
+			return err
+		}
+		return boom
+	})
+	if !stdErrors.Is(err, boom) {
+		s.t.Fatalf("WithTx error = %v, want boom", err)
+	}
+	sum, e := repo.SumInt64(OrderTable, "COALESCE(SUM(amount), 0)", eqWhere("user_id", 1))
+	s.ok(e)
+	s.eq("sum after rollback", sum, 1800)
+
+	// fn panic：回滚、执行各层 AfterRollback 后 panic 继续上抛，AfterCommit 不执行
+	var hooks []string
+	recovered := func() (r any) {
+		defer func() { r = recover() }()
+		_ = s.dbs.WithTx(context.Background(), func(ctx context.Context, tx *gorm.DB) error {
+			dal.AfterCommit(ctx, func(context.Context) { hooks = append(hooks, "outer commit") })
+			dal.AfterRollback(ctx, func(context.Context) { hooks = append(hooks, "outer rollback") })
+			if _, err := repo.WithContext(ctx).Update(tx, OrderTable, eqWhere("user_id", 1), map[string]any{"amount": 0}); err != nil {
+				return err
+			}
+			return s.dbs.WithTx(ctx, func(ctx context.Context, tx *gorm.DB) error {
+				dal.AfterRollback(ctx, func(context.Context) { hooks = append(hooks, "inner rollback") })
+				panic("boom")
+			})
+		})
+		return nil
+	}()
+	s.eq("WithTx panic", recovered, "boom")
+	s.eq("WithTx panic hooks", hooks, []string{"inner rollback", "outer rollback"})
+	sum, e = repo.SumInt64(OrderTable, "COALESCE(SUM(amount), 0)", eqWhere("user_id", 1))
+	s.ok(e)
+	s.eq("sum after panic", sum, 1800)
+}
@@ -0,0 +1,165 @@
+package dal
+
+import (
+	"context"
+	"database/sql"
+	"database/sql/driver"
+	"io"
+	"net"
+	"net/http"
+
+	stdErrors "errors"
+
+	"github.com/xsda-pixel/common-infra/errors"
+	"github.com/xsda-pixel/common-infra/logs"
+
+	"github.com/go-sql-driver/mysql"
+	"gorm.io/gorm"
+)
+
+// StatusClientClosedRequest 客户端主动断开（nginx 约定的 499），标准库未定义
+const StatusClientClosedRequest = 499
+
+// 由 ClassifyError 返回的错误均通过 errors.WithCause 携带驱动原始错误，
+// 调用方使用标准库 errors.Is(err, dal.ErrDuplicateKey) 判断类别，errors.As 取原始错误
+var (
+	ErrQueryCanceled   = errors.NewError(StatusClientClosedRequest, errors.NewMsg("db query canceled"))
+	ErrQueryTimeout    = errors.NewError(http.StatusGatewayTimeout, errors.NewMsg("db query timeout"))
+	ErrDeadlock        = errors.NewError(http.StatusServiceUnavailable, errors.NewMsg("db deadlock, please retry"))
+	ErrLockWaitTimeout = errors.NewError(http.StatusServiceUnavailable, errors.NewMsg("db lock wait timeout, please retry"))
+	ErrConnection      = errors.NewError(http.StatusServiceUnavailable, errors.NewMsg("db unavailable, please retry"))
+	ErrDuplicateKey    = errors.NewError(http.StatusConflict, errors.NewMsg("duplicate key"))
+	ErrForeignKey      = errors.NewError(http.StatusConflict, errors.NewMsg("foreign key constraint violated"))
+	ErrDataTooLong     = errors.NewError(http.StatusUnprocessableEntity, errors.NewMsg("data too long"))
+	ErrInvalidData     = errors.NewError(http.StatusUnprocessableEntity, errors.NewMsg("invalid data"))
+)
+
+// MySQL 错误码
+const (
+	mysqlErrDupKey            = 1022
+	mysqlErrConCount          = 1040
+	mysqlErrBadNull           = 1048
+	mysqlErrServerShutdown    = 1053
+	mysqlErrDupEntry          = 1062
+	mysqlErrLockWaitTimeout   = 1205
+	mysqlErrDeadlock          = 1213
+	mysqlErrNoReferencedRow   = 1216
+	mysqlErrRowIsReferenced   = 1217
+	mysqlErrTruncatedValue    = 1292
+	mysqlErrWarnDataOutOfRng  = 1264
+	mysqlErrWarnDataTruncated = 1265
+	mysqlErrTruncatedWrongVal = 1366
+	mysqlErrDataTooLong       = 1406
+	mysqlErrRowIsReferenced2  = 1451
+	mysqlErrNoReferencedRow2  = 1452
+	mysqlErrDupEntryWithKey   = 1586
+	mysqlErrServerGone        = 2006
+	mysqlErrServerLost        = 2013
+)
+
+// wrapErr 记录日志并将底层错误转换为 errors.Error
+func (db *RepoDB[T]) wrapErr(err error) errors.Error {
+	e := ClassifyError(db.ctx, err)
+	if IsRetryable(e) || stdErrors.Is(e, ErrDuplicateKey) || ctxErr(db.ctx, err) != nil {
+		logs.Logger.Warn(err)
+	} else {
+		logs.Logger.Error(err)
+	}
+	return e
+}
+
+// ClassifyError 将驱动错误映射为具体的 errors.Error，无法识别的归为通用 db error；ctx 可为 nil
+func ClassifyError(ctx context.Context, err error) errors.Error {
+	if err == nil {
+		return nil
+	}
+	if e, ok := err.(errors.Error); ok {
+		return e
+	}
+
+	if e := ctxErr(ctx, err); e != nil {
+		return errors.WithCause(e, err)
+	}
+
+	if n, ok := mysqlErrNumber(err); ok {
+		switch n {
+		case mysqlErrDeadlock:
+			return errors.WithCause(ErrDeadlock, err)
+		case mysqlErrLockWaitTimeout:
+			return errors.WithCause(ErrLockWaitTimeout, err)
+		case mysqlErrDupEntry, mysqlErrDupEntryWithKey, mysqlErrDupKey:
+			return errors.WithCause(ErrDuplicateKey, err)
+		case mysqlErrRowIsReferenced, mysqlErrRowIsReferenced2, mysqlErrNoReferencedRow, mysqlErrNoReferencedRow2:
+			return errors.WithCause(ErrForeignKey, err)
+		case mysqlErrDataTooLong:
+			return errors.WithCause(ErrDataTooLong, err)
+		case mysqlErrBadNull, mysqlErrTruncatedValue, mysqlErrWarnDataOutOfRng, mysqlErrWarnDataTruncated, mysqlErrTruncatedWrongVal:
+			return errors.WithCause(ErrInvalidData, err)
+		case mysqlErrConCount, mysqlErrServerShutdown, mysqlErrServerGone, mysqlErrServerLost:
+			return errors.WithCause(ErrConnection, err)
+		}
+	}
+
+	if e := dialectErr(err); e != nil {
+		return errors.WithCause(e, err)
+	}
+
+	switch {
+	// 开启 gorm TranslateError 时驱动错误会被翻译为以下错误
+	case stdErrors.Is(err, gorm.ErrDuplicatedKey):
+		return errors.WithCause(ErrDuplicateKey, err)
+	case stdErrors.Is(err, gorm.ErrForeignKeyViolated):
+		return errors.WithCause(ErrForeignKey, err)
+	case isConnErr(err):
+		return errors.WithCause(ErrConnection, err)
+	}
+
+	return errors.WithCause(dbErr, err)
+}
+
+// IsRetryable 是否为可重试的临时错误：死锁、锁等待超时、连接异常
+func IsRetryable(err error) bool {
+	return stdErrors.Is(err, ErrDeadlock) ||
+		stdErrors.Is(err, ErrLockWaitTimeout) ||
+		stdErrors.Is(err, ErrConnection)
+}
+
+// mysqlErrNumber 取出 MySQL 服务端错误码
+func mysqlErrNumber(err error) (uint16, bool) {
+	var me *mysql.MySQLError
+	if stdErrors.As(err, &me) {
+		return me.Number, true
+	}
+	return 0, false
+}
+
+func isConnErr(err error) bool {
+	if stdErrors.Is(err, driver.ErrBadConn) ||
+		stdErrors.Is(err, mysql.ErrInvalidConn) ||
+		stdErrors.Is(err, sql.ErrConnDone) ||
+		stdErrors.Is(err, io.ErrUnexpectedEOF) {
+		return true
+	}
+	var netErr net.Error
+	return stdErrors.As(err, &netErr)
+}
+
+// ctxErr 识别由 ctx 取消/超时导致的错误；驱动有时只返回 invalid connection，因此同时检查 ctx 本身
+func ctxErr(ctx context.Context, err error) errors.Error {
+	switch {
+	case stdErrors.Is(err, context.DeadlineExceeded):
+		return ErrQueryTimeout
+	case stdErrors.Is(err, context.Canceled):
+		return ErrQueryCanceled
+	}
+	if ctx == nil {
+		return nil
+	}
+	switch ctx.Err() {
+	case context.DeadlineExceeded:
+		return ErrQueryTimeout
+	case context.Canceled:
+		return ErrQueryCanceled
+	}
+	return nil
+}
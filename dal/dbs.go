@@ -1,27 +1,148 @@
 package dal
 
 import (
+	"context"
+	"sync/atomic"
+
 	rds "github.com/redis/go-redis/v9"
+	"golang.org/x/sync/singleflight"
 	"gorm.io/gorm"
 )
 
 type DBS struct {
-	MySQL *gorm.DB
+	MySQL *gorm.DB // 主库
 	RDS   *rds.Client
+
+	replicas []*replica
+	next     atomic.Uint64 // 从库轮询计数
+	txRetry  *TxRetryConfig
+	metrics  *Metrics
+
+	interceptors []Interceptor // 对全部仓库生效的拦截器，见 WithInterceptors
 }
 
 type RepoDB[T any] struct {
 	*DBS
+	config  RepoConfig
+	ctx     context.Context     // WithContext 绑定的上下文，nil 表示未绑定
+	flight  *singleflight.Group // 缓存未命中时合并并发回源
+	noCache bool
+	primary bool // 读操作强制走主库
+
+	includeDeleted bool // 读操作包含已软删除的行
+	tenantID       any  // ForTenant 显式绑定的租户，优先于 ctx
+	bypassTenant   bool // AdminBypass：不做租户隔离
 }
 
-func NewDB(db *gorm.DB, rdsClient *rds.Client) *DBS {
-	return &DBS{
+// RepoConfig 仓库级配置，通过 NewRepoDB 的 opts 设置
+type RepoConfig struct {
+	CursorSecret  []byte            // 游标签名密钥，FindPageByCursor 必填；重启与多实例间需保持一致，游标才能通用
+	Cache         *CacheConfig      // 读穿缓存配置，nil 表示不缓存
+	Total         *TotalConfig      // FindPageWithTotal 总数缓存与估算，nil 表示总是精确 COUNT
+	SoftDelete    *SoftDeleteConfig // 软删除配置，nil 表示物理删除
+	VersionColumn string            // 乐观锁版本列，为空时使用 version
+	TenantColumn  string            // 租户列，为空时不做租户隔离，见 WithTenant
+	Audit         *AuditConfig      // 行级审计配置，nil 表示不审计
+	Interceptors  []Interceptor     // 仓库级拦截器，见 WithRepoInterceptors
+
+	// 以下白名单为 nil 时不做限制，见 WithSortable / WithSelectable / WithJoinTables
+	Sortable   map[string]struct{}
+	Selectable map[string]struct{}
+	JoinTables map[string]struct{}
+}
+
+func NewDB(db *gorm.DB, rdsClient *rds.Client, opts ...func(*DBS)) *DBS {
+	d := &DBS{
 		MySQL: db,
 		RDS:   rdsClient,
 	}
+	for _, opt := range opts {
+		opt(d)
+	}
+	d.useMetrics()
+	return d
 }
 
 // NewRepoDB 创建泛型仓库，便于统一从 DBS 获取 RepoDB
-func NewRepoDB[T any](dbs *DBS) *RepoDB[T] {
-	return &RepoDB[T]{DBS: dbs}
+func NewRepoDB[T any](dbs *DBS, opts ...func(*RepoConfig)) *RepoDB[T] {
+	var cfg RepoConfig
+	for _, opt := range opts {
+		opt(&cfg)
+	}
+	return &RepoDB[T]{DBS: dbs, config: cfg, flight: &singleflight.Group{}}
+}
+
+// WithCursorSecret 配置游标签名密钥，使用游标分页时必填；各实例与重启前后需使用同一密钥，建议从配置中心读取
+func WithCursorSecret(secret []byte) func(*RepoConfig) {
+	return func(c *RepoConfig) {
+		c.CursorSecret = secret
+	}
+}
+
+// WithContext 返回绑定 ctx 的仓库副本，之后的读写都会在 ctx 超时或取消时中断
+func (db *RepoDB[T]) WithContext(ctx context.Context) *RepoDB[T] {
+	if ctx == nil {
+		ctx = context.Background()
+	}
+	repo := *db
+	repo.ctx = ctx
+	return &repo
+}
+
+// ReadWithContext 同 WithContext，供只持有 ReadRepo 接口的调用方使用
+func (db *RepoDB[T]) ReadWithContext(ctx context.Context) ReadRepo[T] {
+	return db.WithContext(ctx)
+}
+
+// WriteWithContext 同 WithContext，供只持有 WriteRepo 接口的调用方使用
+func (db *RepoDB[T]) WriteWithContext(ctx context.Context) WriteRepo[T] {
+	return db.WithContext(ctx)
+}
+
+// Context 返回仓库绑定的上下文，未绑定时返回 context.Background()
+func (db *RepoDB[T]) Context() context.Context {
+	if db.ctx == nil {
+		return context.Background()
+	}
+	return db.ctx
+}
+
+// Primary 返回读操作强制走主库的仓库副本
+func (db *RepoDB[T]) Primary() *RepoDB[T] {
+	repo := *db
+	repo.primary = true
+	return &repo
+}
+
+// conn 读操作使用的连接：ctx 处于 WithTx 中时复用该事务，否则按读写分离规则选择主库或从库
+func (db *RepoDB[T]) conn() *gorm.DB {
+	if tx, ok := TxFromContext(db.ctx); ok {
+		return tx.WithContext(db.ctx)
+	}
+	if db.primary {
+		return db.bind(db.MySQL)
+	}
+	return db.bind(db.Reader(db.ctx))
+}
+
+// afterWrite 写入成功后的收尾：失效缓存并标记 read-your-writes；
+// 处于 WithTx 中时提交或回滚后再失效一次，避免事务结束前被并发读回填的数据残留在缓存中
+func (db *RepoDB[T]) afterWrite(tableName string) {
+	db.invalidateCache(tableName)
+	markWritten(db.ctx)
+	if _, ok := txStateFrom(db.ctx); ok {
+		invalidate := func(context.Context) {
+			db.invalidateCache(tableName)
+		}
+		AfterCommit(db.ctx, invalidate)
+		AfterRollback(db.ctx, invalidate)
+	}
+}
+
+// bind 将仓库的 ctx 绑定到调用方传入的连接/事务上；未绑定 ctx 时保持原样，沿用事务自身的 ctx
+func (db *RepoDB[T]) bind(dbs *gorm.DB) *gorm.DB {
+	if db.ctx == nil {
+		return dbs
+	}
+	return dbs.WithContext(db.ctx)
 }
@@ -0,0 +1,141 @@
+package dal
+
+import (
+	stdErrors "errors"
+	"strings"
+
+	"github.com/xsda-pixel/common-infra/errors"
+
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+// 方言名，与 gorm Dialector.Name() 一致；其他方言按 MySQL 处理
+const (
+	DialectMySQL    = "mysql"
+	DialectPostgres = "postgres"
+	DialectSQLite   = "sqlite"
+)
+
+// PostgreSQL SQLSTATE
+const (
+	pgErrUniqueViolation     = "23505"
+	pgErrForeignKeyViolation = "23503"
+	pgErrNotNullViolation    = "23502"
+	pgErrStringTooLong       = "22001"
+	pgErrDeadlock            = "40P01"
+	pgErrLockNotAvailable    = "55P03"
+	pgErrQueryCanceled       = "57014"
+)
+
+// SQLite 扩展错误码（modernc.org/sqlite 的 Code()）
+const (
+	sqliteErrBusy       = 5
+	sqliteErrLocked     = 6
+	sqliteErrForeignKey = 787
+	sqliteErrNotNull    = 1299
+	sqliteErrPrimaryKey = 1555
+	sqliteErrUnique     = 2067
+)
+
+// Dialect 返回 db 的方言名
+func Dialect(db *gorm.DB) string {
+	if db == nil || db.Config == nil || db.Dialector == nil {
+		return DialectMySQL
+	}
+	switch name := db.Dialector.Name(); name {
+	case DialectPostgres, DialectSQLite:
+		return name
+	}
+	return DialectMySQL
+}
+
+// Dialect 返回主库的方言名
+func (d *DBS) Dialect() string {
+	return Dialect(d.MySQL)
+}
+
+// forUpdate 追加 FOR UPDATE [options]；SQLite 没有行锁（写事务之间本身互斥），不追加
+func forUpdate(rs *gorm.DB, options string) *gorm.DB {
+	if Dialect(rs) == DialectSQLite {
+		return rs
+	}
+	return rs.Clauses(clause.Locking{Strength: "UPDATE", Options: options})
+}
+
+// dialectSQL 将调用方传入的原生 SQL 片段（order、join 的 ON、fields）中的反引号转换为当前方言的标识符引号：
+// MySQL / SQLite 原样返回，PostgreSQL 转为双引号。只做字符替换，片段中的字符串常量不应包含反引号
+func dialectSQL(rs *gorm.DB, sql string) string {
+	if Dialect(rs) != DialectPostgres || !strings.Contains(sql, "`") {
+		return sql
+	}
+	return strings.ReplaceAll(sql, "`", `"`)
+}
+
+func dialectFields(rs *gorm.DB, fields []string) []string {
+	if Dialect(rs) != DialectPostgres {
+		return fields
+	}
+	out := make([]string, len(fields))
+	for i, f := range fields {
+		out[i] = dialectSQL(rs, f)
+	}
+	return out
+}
+
+// upsertConflict SQLite / PostgreSQL 的 ON CONFLICT 必须给出冲突列，未指定时使用模型主键；MySQL 以表上的唯一索引为准
+func (db *RepoDB[T]) upsertConflict(rs *gorm.DB, onConflict clause.OnConflict) (clause.OnConflict, errors.Error) {
+	if len(onConflict.Columns) > 0 || Dialect(rs) == DialectMySQL {
+		return onConflict, nil
+	}
+	sch, err := db.modelSchema()
+	if err != nil {
+		return onConflict, db.wrapErr(err)
+	}
+	for _, field := range sch.PrimaryFields {
+		onConflict.Columns = append(onConflict.Columns, clause.Column{Name: field.DBName})
+	}
+	if len(onConflict.Columns) == 0 {
+		return onConflict, invalidQuery("Upsert: conflict columns are required for %s", Dialect(rs))
+	}
+	return onConflict, nil
+}
+
+// dialectErr 识别 PostgreSQL（SQLSTATE）与 SQLite（扩展错误码）的错误，无法识别时返回 nil
+func dialectErr(err error) errors.Error {
+	var pgErr interface{ SQLState() string }
+	if stdErrors.As(err, &pgErr) {
+		switch pgErr.SQLState() {
+		case pgErrUniqueViolation:
+			return ErrDuplicateKey
+		case pgErrForeignKeyViolation:
+			return ErrForeignKey
+		case pgErrStringTooLong:
+			return ErrDataTooLong
+		case pgErrNotNullViolation:
+			return ErrInvalidData
+		case pgErrDeadlock:
+			return ErrDeadlock
+		case pgErrLockNotAvailable:
+			return ErrLockWaitTimeout
+		case pgErrQueryCanceled:
+			return ErrQueryCanceled
+		}
+		return nil
+	}
+
+	var sqliteErr interface{ Code() int }
+	if stdErrors.As(err, &sqliteErr) {
+		switch sqliteErr.Code() {
+		case sqliteErrUnique, sqliteErrPrimaryKey:
+			return ErrDuplicateKey
+		case sqliteErrForeignKey:
+			return ErrForeignKey
+		case sqliteErrNotNull:
+			return ErrInvalidData
+		case sqliteErrBusy, sqliteErrLocked:
+			return ErrLockWaitTimeout
+		}
+	}
+	return nil
+}
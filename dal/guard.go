@@ -0,0 +1,208 @@
+package dal
+
+import (
+	"net/http"
+	"regexp"
+	"strings"
+
+	"github.com/xsda-pixel/common-infra/errors"
+)
+
+var (
+	identRe   = regexp.MustCompile(`^[A-Za-z_][A-Za-z0-9_]*$`)
+	operandRe = regexp.MustCompile("^(`?[A-Za-z_][A-Za-z0-9_]*`?\\.)?`?[A-Za-z_][A-Za-z0-9_]*`?$|^-?[0-9]+$")
+	onCondRe  = regexp.MustCompile(`^\s*(\S+)\s*(=|<>|!=|<=|>=|<|>)\s*(\S+)\s*$`)
+	andRe     = regexp.MustCompile(`(?i)\s+AND\s+`)
+)
+
+// 合法的联表类型，空字符串为 LEFT
+var joinTypes = map[string]struct{}{
+	"":      {},
+	"LEFT":  {},
+	"INNER": {},
+	"RIGHT": {},
+}
+
+// WithSortable 配置允许排序的列（如 id、o.created_at），配置后 order 中出现其他列或非 ASC/DESC 方向时返回 400
+func WithSortable(columns ...string) func(*RepoConfig) {
+	return func(c *RepoConfig) {
+		c.Sortable = columnSet(c.Sortable, columns)
+	}
+}
+
+// WithSelectable 配置允许查询的列，配置后 fields 中出现其他列时返回 400；列可带 "AS 别名"
+func WithSelectable(columns ...string) func(*RepoConfig) {
+	return func(c *RepoConfig) {
+		c.Selectable = columnSet(c.Selectable, columns)
+	}
+}
+
+// WithJoinTables 配置允许关联的表，配置后同时校验 On 只能是 "列 运算符 列/整数" 以 AND 连接的形式
+func WithJoinTables(tables ...string) func(*RepoConfig) {
+	return func(c *RepoConfig) {
+		c.JoinTables = columnSet(c.JoinTables, tables)
+	}
+}
+
+// Orders 将结构化排序项转为可直接传给 FindMany 等方法的 order，列名会加反引号
+func Orders(orders ...OrderBy) *string {
+	parts := make([]string, 0, len(orders))
+	for _, o := range orders {
+		col := quoteColumn(o.Column)
+		if o.Desc {
+			col += " DESC"
+		}
+		parts = append(parts, col)
+	}
+	s := strings.Join(parts, ", ")
+	return &s
+}
+
+// checkQuery 按仓库配置的白名单校验 fields / order / joins，未配置对应白名单的部分不做限制
+func (db *RepoDB[T]) checkQuery(fields []string, order *string, joins []JoinOption) errors.Error {
+	if err := db.checkFields(fields); err != nil {
+		return err
+	}
+	if order != nil {
+		if err := db.checkOrder(*order); err != nil {
+			return err
+		}
+	}
+	return db.checkJoins(joins)
+}
+
+func (db *RepoDB[T]) checkFields(fields []string) errors.Error {
+	if db.config.Selectable == nil {
+		return nil
+	}
+	for _, f := range fields {
+		parts := strings.Fields(f)
+		var expr, alias string
+		switch {
+		case len(parts) == 1:
+			expr = parts[0]
+		case len(parts) == 2:
+			expr, alias = parts[0], parts[1]
+		case len(parts) == 3 && strings.EqualFold(parts[1], "AS"):
+			expr, alias = parts[0], parts[2]
+		default:
+			return invalidQuery("field %s is not allowed", f)
+		}
+		if !inColumnSet(db.config.Selectable, expr) {
+			return invalidQuery("field %s is not allowed", f)
+		}
+		if alias != "" && !identRe.MatchString(strings.Trim(alias, "`")) {
+			return invalidQuery("field alias %s is invalid", alias)
+		}
+	}
+	return nil
+}
+
+func (db *RepoDB[T]) checkOrder(order string) errors.Error {
+	if db.config.Sortable == nil || strings.TrimSpace(order) == "" {
+		return nil
+	}
+	for _, item := range strings.Split(order, ",") {
+		parts := strings.Fields(item)
+		if len(parts) == 0 || len(parts) > 2 {
+			return invalidQuery("order %s is not allowed", item)
+		}
+		if !inColumnSet(db.config.Sortable, parts[0]) {
+			return invalidQuery("order by column %s is not allowed", parts[0])
+		}
+		if len(parts) == 2 && !strings.EqualFold(parts[1], "ASC") && !strings.EqualFold(parts[1], "DESC") {
+			return invalidQuery("order direction %s is invalid", parts[1])
+		}
+	}
+	return nil
+}
+
+func (db *RepoDB[T]) checkOrderBy(orders []OrderBy) errors.Error {
+	if db.config.Sortable == nil {
+		return nil
+	}
+	for _, o := range orders {
+		if !inColumnSet(db.config.Sortable, o.Column) {
+			return invalidQuery("order by column %s is not allowed", o.Column)
+		}
+	}
+	return nil
+}
+
+func (db *RepoDB[T]) checkJoins(joins []JoinOption) errors.Error {
+	for _, j := range joins {
+		if _, ok := joinTypes[strings.ToUpper(strings.TrimSpace(j.Type))]; !ok {
+			return invalidQuery("join type %s is not allowed", j.Type)
+		}
+
+		if db.config.JoinTables == nil {
+			continue
+		}
+
+		parts := strings.Fields(j.Table)
+		var table, alias string
+		switch {
+		case len(parts) == 1:
+			table = parts[0]
+		case len(parts) == 2:
+			table, alias = parts[0], parts[1]
+		case len(parts) == 3 && strings.EqualFold(parts[1], "AS"):
+			table, alias = parts[0], parts[2]
+		default:
+			return invalidQuery("join table %s is not allowed", j.Table)
+		}
+		if !inColumnSet(db.config.JoinTables, table) {
+			return invalidQuery("join table %s is not allowed", j.Table)
+		}
+		if alias != "" && !identRe.MatchString(alias) {
+			return invalidQuery("join alias %s is invalid", alias)
+		}
+
+		for _, cond := range andRe.Split(j.On, -1) {
+			m := onCondRe.FindStringSubmatch(cond)
+			if m == nil || !operandRe.MatchString(m[1]) || !operandRe.MatchString(m[3]) {
+				return invalidQuery("join condition %s is not allowed", j.On)
+			}
+		}
+	}
+	return nil
+}
+
+func invalidQuery(format string, args ...any) errors.Error {
+	return errors.NewError(http.StatusBadRequest, errors.NewMsg(format, args...))
+}
+
+func columnSet(set map[string]struct{}, columns []string) map[string]struct{} {
+	if set == nil {
+		set = make(map[string]struct{}, len(columns))
+	}
+	for _, col := range columns {
+		set[normalizeColumn(col)] = struct{}{}
+	}
+	return set
+}
+
+// inColumnSet 列是否在白名单中；只接受不带引号或每段都完整加反引号的写法
+func inColumnSet(set map[string]struct{}, column string) bool {
+	column = strings.TrimSpace(column)
+	name := normalizeColumn(column)
+	if column != name && column != quoteColumn(name) {
+		return false
+	}
+	_, ok := set[name]
+	return ok
+}
+
+// normalizeColumn 去掉空白与反引号："`o`.`id`" -> "o.id"
+func normalizeColumn(column string) string {
+	return strings.ReplaceAll(strings.TrimSpace(column), "`", "")
+}
+
+// quoteColumn 为列名加反引号："o.id" -> "`o`.`id`"
+func quoteColumn(column string) string {
+	parts := strings.Split(normalizeColumn(column), ".")
+	for i, p := range parts {
+		parts[i] = "`" + p + "`"
+	}
+	return strings.Join(parts, ".")
+}
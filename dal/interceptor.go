@@ -0,0 +1,128 @@
+package dal
+
+import (
+	"context"
+	"net/http"
+
+	"github.com/xsda-pixel/common-infra/errors"
+
+	"gorm.io/gorm"
+)
+
+// 操作类型，与 RepoDB 的方法名一致
+const (
+	OpFindOne                   = "FindOne"
+	OpFindOneForUpdate          = "FindOneForUpdate"
+	OpFindMany                  = "FindMany"
+	OpFindManyWithGroupByHaving = "FindManyWithGroupByHaving"
+	OpFindManyWithJoin          = "FindManyWithJoin"
+	OpFindPage                  = "FindPage"
+	OpFindPageWithJoin          = "FindPageWithJoin"
+	OpFindPageWithTotal         = "FindPageWithTotal"
+	OpFindPageByCursor          = "FindPageByCursor"
+	OpFindEach                  = "FindEach"
+	OpFindInChunks              = "FindInChunks"
+	OpCount                     = "Count"
+	OpExists                    = "Exists"
+	OpSumInt64                  = "SumInt64"
+	OpSum                       = "Sum"
+	OpMin                       = "Min"
+	OpMax                       = "Max"
+	OpAvg                       = "Avg"
+	OpGroupAggregate            = "GroupAggregate"
+	OpCreateOne                 = "CreateOne"
+	OpCreateMany                = "CreateMany"
+	OpUpsert                    = "Upsert"
+	OpUpdate                    = "Update"
+	OpBulkUpdateByKey           = "BulkUpdateByKey"
+	OpDelete                    = "Delete"
+	OpHardDelete                = "HardDelete"
+	OpRestore                   = "Restore"
+)
+
+// Operation 一次仓库调用。拦截器可以修改 DB / Table / Fields / Joins / Where / Having / Order / Limit / Page / Updates，
+// 修改后的值会传给实际执行；Ctx / Item / Args 为只读的附加参数（CreateOne 的 item、CreateMany 的 items、Upsert 的 UpsertOption 等）。
+type Operation struct {
+	Kind    string // OpFindOne 等
+	Ctx     context.Context
+	DB      *gorm.DB // 写操作、FindOneForUpdate 与 UpdateWithVersionRetry 的读取传入的连接/事务
+	Table   string
+	Fields  []string // SumInt64 的表达式、聚合的列也放在这里
+	Joins   []JoinOption
+	Where   WhereOption
+	Having  WhereOption
+	Order   *string
+	Limit   *int
+	Page    int
+	Updates map[string]any
+	Item    any
+	Args    any
+}
+
+// PageResult FindPageWithTotal 经过拦截器时的结果类型
+type PageResult[T any] struct {
+	List  []*T
+	Total int64
+}
+
+// Invoker 执行拦截链中的下一环，最终执行实际操作；结果类型与对应方法的第一个返回值相同（*T、[]*T、int64 等），无结果时为 nil
+type Invoker func(op *Operation) (any, errors.Error)
+
+// Interceptor 仓库操作拦截器：调用 next 继续执行并可处理其结果与错误；不调用 next 即短路，返回值作为方法的结果
+type Interceptor func(op *Operation, next Invoker) (any, errors.Error)
+
+// WithInterceptors 注册对该 DBS 上全部仓库生效的拦截器，先于仓库级拦截器执行
+func WithInterceptors(interceptors ...Interceptor) func(*DBS) {
+	return func(d *DBS) {
+		d.interceptors = append(d.interceptors, interceptors...)
+	}
+}
+
+// WithRepoInterceptors 注册仓库级拦截器，按注册顺序由外到内执行
+func WithRepoInterceptors(interceptors ...Interceptor) func(*RepoConfig) {
+	return func(c *RepoConfig) {
+		c.Interceptors = append(c.Interceptors, interceptors...)
+	}
+}
+
+// pageSize 拦截器可能把 Limit 置为 nil，此时按 0 处理
+func pageSize(limit *int) int {
+	if limit == nil {
+		return 0
+	}
+	return *limit
+}
+
+// intercept 按 DBS 级、仓库级顺序组装拦截链并执行 call；短路返回的结果类型不符时返回 500
+func intercept[T, R any](db *RepoDB[T], op *Operation, call func(op *Operation) (R, errors.Error)) (R, errors.Error) {
+	if len(db.DBS.interceptors) == 0 && len(db.config.Interceptors) == 0 {
+		return call(op)
+	}
+
+	chain := make([]Interceptor, 0, len(db.DBS.interceptors)+len(db.config.Interceptors))
+	chain = append(chain, db.DBS.interceptors...)
+	chain = append(chain, db.config.Interceptors...)
+
+	op.Ctx = db.Context()
+
+	var next Invoker = func(op *Operation) (any, errors.Error) {
+		return call(op)
+	}
+	for i := len(chain) - 1; i >= 0; i-- {
+		ic, inner := chain[i], next
+		next = func(op *Operation) (any, errors.Error) {
+			return ic(op, inner)
+		}
+	}
+
+	var zero R
+	res, err := next(op)
+	if res == nil {
+		return zero, err
+	}
+	r, ok := res.(R)
+	if !ok {
+		return zero, errors.NewError(http.StatusInternalServerError, errors.NewMsg("interceptor returned %T for %s, want %T", res, op.Kind, zero))
+	}
+	return r, err
+}
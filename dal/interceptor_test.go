@@ -0,0 +1,138 @@
+package dal_test
+
+import (
+	"context"
+	stdErrors "errors"
+	"fmt"
+	"net/http"
+	"testing"
+
+	"github.com/xsda-pixel/common-infra/dal"
+	"github.com/xsda-pixel/common-infra/dal/daltest"
+	"github.com/xsda-pixel/common-infra/errors"
+)
+
+type ctxKey struct{}
+
+func TestInterceptorChain(t *testing.T) {
+	_, db := newSQLiteOrders(t)
+
+	var calls []string
+	record := func(name string) dal.Interceptor {
+		return func(op *dal.Operation, next dal.Invoker) (any, errors.Error) {
+			calls = append(calls, fmt.Sprintf("%s:%s:%v", name, op.Kind, op.Ctx.Value(ctxKey{})))
+			return next(op)
+		}
+	}
+	dbs := dal.NewDB(db, nil, dal.WithInterceptors(record("dbs")))
+	repo := dal.NewRepoDB[daltest.Order](dbs, dal.WithRepoInterceptors(record("repo1"), record("repo2")))
+
+	ctx := context.WithValue(context.Background(), ctxKey{}, "req-1")
+	n, err := repo.WithContext(ctx).Count(daltest.OrderTable, dal.WhereOption{})
+	if err != nil || n != 10 {
+		t.Fatalf("Count = %d, %v", n, err)
+	}
+	if want := "[dbs:Count:req-1 repo1:Count:req-1 repo2:Count:req-1]"; fmt.Sprint(calls) != want {
+		t.Fatalf("calls = %v, want %s", calls, want)
+	}
+}
+
+func TestInterceptorShortCircuit(t *testing.T) {
+	_, db := newSQLiteOrders(t)
+	fail := errors.NewError(http.StatusForbidden, errors.NewMsg("denied"))
+
+	repo := dal.NewRepoDB[daltest.Order](dal.NewDB(db, nil), dal.WithRepoInterceptors(
+		func(op *dal.Operation, next dal.Invoker) (any, errors.Error) {
+			switch op.Kind {
+			case dal.OpFindOne:
+				return &daltest.Order{OrderNo: "from-interceptor"}, nil
+			case dal.OpCount:
+				return int64(42), nil
+			case dal.OpDelete:
+				return nil, fail
+			}
+			return next(op)
+		},
+	))
+
+	o, err := repo.FindOne(daltest.OrderTable, nil, dal.WhereOption{Eq: map[string]any{"id": 1}})
+	if err != nil || o == nil || o.OrderNo != "from-interceptor" {
+		t.Fatalf("FindOne = %+v, %v", o, err)
+	}
+	if n, err := repo.Count(daltest.OrderTable, dal.WhereOption{}); err != nil || n != 42 {
+		t.Fatalf("Count = %d, %v", n, err)
+	}
+	if _, err := repo.Delete(db, daltest.OrderTable, dal.WhereOption{Eq: map[string]any{"id": 1}}); !stdErrors.Is(err, fail) {
+		t.Fatalf("Delete error = %v, want the interceptor's error", err)
+	}
+	var n int64
+	db.Table(daltest.OrderTable).Count(&n)
+	if n != 10 {
+		t.Fatalf("rows after short-circuited Delete = %d, want 10", n)
+	}
+}
+
+func TestInterceptorRewrite(t *testing.T) {
+	_, db := newSQLiteOrders(t)
+
+	// 只允许访问 user 1 的订单，并把写入的 status 固定为 9
+	repo := dal.NewRepoDB[daltest.Order](dal.NewDB(db, nil), dal.WithRepoInterceptors(
+		func(op *dal.Operation, next dal.Invoker) (any, errors.Error) {
+			op.Where.Cond = dal.And(op.Where.Cond, dal.Eq("user_id", 1))
+			if op.Updates != nil {
+				op.Updates["status"] = 9
+			}
+			if op.Kind == dal.OpFindMany {
+				limit := 2
+				op.Limit = &limit
+			}
+			return next(op)
+		},
+	))
+
+	list, err := repo.FindMany(daltest.OrderTable, nil, dal.WhereOption{}, ptr("id"), nil)
+	if err != nil || fmt.Sprint(orderIDs(list)) != "[3 6]" {
+		t.Fatalf("FindMany = %v, %v; want [3 6]", orderIDs(list), err)
+	}
+	if n, err := repo.Count(daltest.OrderTable, dal.WhereOption{Cond: dal.Gt("amount", 300)}); err != nil || n != 2 {
+		t.Fatalf("Count = %d, %v; want 2", n, err)
+	}
+	n, err := repo.Update(db, daltest.OrderTable, dal.WhereOption{Cond: dal.Gte("amount", 0)}, map[string]any{"amount": 1})
+	if err != nil || n != 3 {
+		t.Fatalf("Update = %d, %v; want 3", n, err)
+	}
+
+	var got []*daltest.Order
+	db.Table(daltest.OrderTable).Where("amount = ?", 1).Order("id").Find(&got)
+	if fmt.Sprint(ordersState(got)) != "[3:9:1 6:9:1 9:9:1]" {
+		t.Fatalf("updated rows = %v", ordersState(got))
+	}
+}
+
+func TestInterceptorWrongResultType(t *testing.T) {
+	_, db := newSQLiteOrders(t)
+	repo := dal.NewRepoDB[daltest.Order](dal.NewDB(db, nil), dal.WithRepoInterceptors(
+		func(op *dal.Operation, next dal.Invoker) (any, errors.Error) {
+			switch op.Kind {
+			case dal.OpFindOne:
+				return daltest.Order{}, nil // 应为 *daltest.Order
+			case dal.OpCount:
+				return 42, nil // 应为 int64
+			case dal.OpFindMany:
+				return []daltest.Order{}, nil // 应为 []*daltest.Order
+			}
+			return next(op)
+		},
+	))
+
+	_, err := repo.FindOne(daltest.OrderTable, nil, dal.WhereOption{})
+	if err == nil || err.ErrCode() != http.StatusInternalServerError {
+		t.Fatalf("FindOne error = %v, want 500", err)
+	}
+	if _, err = repo.Count(daltest.OrderTable, dal.WhereOption{}); err == nil || err.ErrCode() != http.StatusInternalServerError {
+		t.Fatalf("Count error = %v, want 500", err)
+	}
+	if _, err = repo.FindMany(daltest.OrderTable, nil, dal.WhereOption{}, nil, nil); err == nil || err.ErrCode() != http.StatusInternalServerError {
+		t.Fatalf("FindMany error = %v, want 500", err)
+	}
+}
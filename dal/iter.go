@@ -0,0 +1,127 @@
+package dal
+
+import (
+	"github.com/xsda-pixel/common-infra/errors"
+)
+
+const defaultIterBuffer = 64
+
+// RowIter FindIter 返回的行迭代器
+type RowIter[T any] struct {
+	ch   chan *T
+	done chan struct{}
+	err  errors.Error
+}
+
+// C 行数据通道，扫描结束或出错后关闭，可直接交给 stream.Worker.Start
+func (it *RowIter[T]) C() <-chan *T {
+	return it.ch
+}
+
+// Err 等待扫描结束并返回错误：扫描/查询错误、ctx 取消（ErrQueryCanceled / ErrQueryTimeout），全部读完时为 nil
+func (it *RowIter[T]) Err() errors.Error {
+	<-it.done
+	return it.err
+}
+
+// FindEach 基于 Rows() 逐行扫描并回调 fn，内存占用与结果集大小无关；fn 返回错误时停止并原样返回。
+// 扫描期间会一直占用一个连接，fn 中不宜做耗时操作；处于 WithTx 中时扫描期间不能在同一事务上执行其他语句。
+func (db *RepoDB[T]) FindEach(
+	tableName string,
+	fields []string,
+	where WhereOption,
+	order *string,
+	fn func(item *T) errors.Error,
+) errors.Error {
+	op := &Operation{Kind: OpFindEach, Table: tableName, Fields: fields, Where: where, Order: order}
+	_, err := intercept(db, op, func(op *Operation) (any, errors.Error) {
+		return nil, db.findEach(op.Table, op.Fields, op.Where, op.Order, fn)
+	})
+	return err
+}
+
+func (db *RepoDB[T]) findEach(
+	tableName string,
+	fields []string,
+	where WhereOption,
+	order *string,
+	fn func(item *T) errors.Error,
+) errors.Error {
+	if err := db.checkQuery(fields, order, nil); err != nil {
+		return err
+	}
+
+	rs := db.table(db.conn(), tableName)
+
+	if len(fields) > 0 {
+		rs = rs.Select(dialectFields(rs, fields))
+	}
+
+	rs = applyWhere(rs, where)
+
+	if order != nil && *order != "" {
+		rs = rs.Order(dialectSQL(rs, *order))
+	}
+
+	rows, err := rs.Rows()
+	if err != nil {
+		return db.wrapErr(err)
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var item T
+		if err := rs.ScanRows(rows, &item); err != nil {
+			return db.wrapErr(err)
+		}
+		if e := fn(&item); e != nil {
+			return e
+		}
+	}
+
+	if err := rows.Err(); err != nil {
+		return db.wrapErr(err)
+	}
+	return nil
+}
+
+// FindIter 在后台 goroutine 中执行 FindEach，把每行写入容量为 buffer 的通道：
+//
+//	it := repo.WithContext(ctx).FindIter("orders", nil, where, nil, 0)
+//	worker.Start(ctx, it.C())
+//	if err := it.Err(); err != nil { ... }
+//
+// 消费方必须读完通道或取消 ctx，否则后台 goroutine 与连接不会释放。
+func (db *RepoDB[T]) FindIter(
+	tableName string,
+	fields []string,
+	where WhereOption,
+	order *string,
+	buffer int,
+) *RowIter[T] {
+	if buffer <= 0 {
+		buffer = defaultIterBuffer
+	}
+
+	it := &RowIter[T]{
+		ch:   make(chan *T, buffer),
+		done: make(chan struct{}),
+	}
+	ctx := db.Context()
+
+	go func() {
+		defer close(it.done)
+		defer close(it.ch)
+
+		it.err = db.FindEach(tableName, fields, where, order, func(item *T) errors.Error {
+			select {
+			case it.ch <- item:
+				return nil
+			case <-ctx.Done():
+				return ClassifyError(ctx, ctx.Err())
+			}
+		})
+	}()
+
+	return it
+}
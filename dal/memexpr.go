@@ -0,0 +1,817 @@
+package dal
+
+import (
+	"database/sql/driver"
+	"fmt"
+	"reflect"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+	"unicode"
+
+	"github.com/xsda-pixel/common-infra/errors"
+)
+
+// memNode MemRepo 中的表达式节点；布尔结果为 bool，SQL NULL 为 nil
+type memNode func(get memGetter) (any, error)
+
+// memGetter 按列名读取当前行的值
+type memGetter func(column string) (any, error)
+
+// compileRaw 将 RawWhere 编译为表达式，支持的子集：
+//
+//	列 / ? / 数字 / '字符串' / TRUE / FALSE / NULL，+ - * /，= != <> < <= > >=，
+//	IS [NOT] NULL，[NOT] IN (...) / IN ?，[NOT] LIKE，[NOT] BETWEEN ... AND ...，AND / OR / NOT 与括号
+//
+// 不支持函数、子查询、命名参数等，遇到时返回 400
+func compileRaw(sql string, args []any) (memNode, errors.Error) {
+	toks, err := memTokenize(sql)
+	if err != nil {
+		return nil, invalidQuery("MemRepo: unsupported raw where %q: %v", sql, err)
+	}
+	p := &memParser{toks: toks, args: args}
+	node, err := p.parseOr()
+	if err == nil && p.peek().kind != memTokEOF {
+		err = fmt.Errorf("unexpected %q", p.peek().text)
+	}
+	if err != nil {
+		return nil, invalidQuery("MemRepo: unsupported raw where %q: %v", sql, err)
+	}
+	return node, nil
+}
+
+// compileCond 将 Cond 编译为表达式
+func compileCond(c Cond) (memNode, errors.Error) {
+	switch c := c.(type) {
+	case nil:
+		return nil, nil
+	case cmpCond:
+		col := memColumn(c.column)
+		if c.value == nil && (c.op == opEq || c.op == opNe) {
+			return memIsNull(col, c.op == opNe), nil
+		}
+		return memCompareNode(c.op, col, memConst(c.value)), nil
+	case inCond:
+		list := make([]memNode, len(c.values))
+		for i, v := range c.values {
+			list[i] = memConst(v)
+		}
+		return memIn(memColumn(c.column), list, c.not), nil
+	case betweenCond:
+		return memBetween(memColumn(c.column), memConst(c.low), memConst(c.high), false), nil
+	case likeCond:
+		return memLike(memColumn(c.column), memConst(c.pattern), false), nil
+	case nullCond:
+		return memIsNull(memColumn(c.column), c.not), nil
+	case groupCond:
+		nodes := make([]memNode, 0, len(c.conds))
+		for _, sub := range c.conds {
+			n, err := compileCond(sub)
+			if err != nil {
+				return nil, err
+			}
+			if n != nil {
+				nodes = append(nodes, n)
+			}
+		}
+		switch {
+		case len(nodes) == 0:
+			return nil, nil
+		case c.or:
+			return memLogic(true, nodes...), nil
+		default:
+			return memLogic(false, nodes...), nil
+		}
+	case notCond:
+		n, err := compileCond(c.cond)
+		if err != nil || n == nil {
+			return nil, err
+		}
+		return memNot(n), nil
+	}
+	return nil, invalidQuery("MemRepo: unsupported condition %T", c)
+}
+
+func memColumn(name string) memNode {
+	return func(get memGetter) (any, error) {
+		return get(name)
+	}
+}
+
+func memConst(v any) memNode {
+	v = memValue(v)
+	return func(memGetter) (any, error) {
+		return v, nil
+	}
+}
+
+func memCompareNode(op string, l, r memNode) memNode {
+	return func(get memGetter) (any, error) {
+		a, b, err := memEval2(get, l, r)
+		if err != nil || a == nil || b == nil {
+			return nil, err
+		}
+		c, ok := memCompare(a, b)
+		if !ok {
+			return nil, nil
+		}
+		switch op {
+		case opEq:
+			return c == 0, nil
+		case opNe:
+			return c != 0, nil
+		case opGt:
+			return c > 0, nil
+		case opGte:
+			return c >= 0, nil
+		case opLt:
+			return c < 0, nil
+		default:
+			return c <= 0, nil
+		}
+	}
+}
+
+func memIsNull(n memNode, not bool) memNode {
+	return func(get memGetter) (any, error) {
+		v, err := n(get)
+		if err != nil {
+			return nil, err
+		}
+		return (v == nil) != not, nil
+	}
+}
+
+// memIn 列表元素为切片时展开（对应 IN ? 传入切片）
+func memIn(n memNode, list []memNode, not bool) memNode {
+	return func(get memGetter) (any, error) {
+		v, err := n(get)
+		if err != nil || v == nil {
+			return nil, err
+		}
+		for _, item := range list {
+			iv, err := item(get)
+			if err != nil {
+				return nil, err
+			}
+			for _, e := range memExpand(iv) {
+				if c, ok := memCompare(v, e); ok && c == 0 {
+					return !not, nil
+				}
+			}
+		}
+		return not, nil
+	}
+}
+
+func memBetween(n, low, high memNode, not bool) memNode {
+	return func(get memGetter) (any, error) {
+		v, err := n(get)
+		if err != nil || v == nil {
+			return nil, err
+		}
+		lo, hi, err := memEval2(get, low, high)
+		if err != nil || lo == nil || hi == nil {
+			return nil, err
+		}
+		c1, ok1 := memCompare(v, lo)
+		c2, ok2 := memCompare(v, hi)
+		if !ok1 || !ok2 {
+			return nil, nil
+		}
+		return (c1 >= 0 && c2 <= 0) != not, nil
+	}
+}
+
+func memLike(n, pattern memNode, not bool) memNode {
+	return func(get memGetter) (any, error) {
+		v, p, err := memEval2(get, n, pattern)
+		if err != nil || v == nil || p == nil {
+			return nil, err
+		}
+		re, err := likeRegexp(fmt.Sprint(p))
+		if err != nil {
+			return nil, err
+		}
+		return re.MatchString(fmt.Sprint(v)) != not, nil
+	}
+}
+
+// memLogic AND / OR 的三值逻辑
+func memLogic(or bool, nodes ...memNode) memNode {
+	return func(get memGetter) (any, error) {
+		unknown := false
+		for _, n := range nodes {
+			v, err := n(get)
+			if err != nil {
+				return nil, err
+			}
+			if v == nil {
+				unknown = true
+				continue
+			}
+			if memTruthy(v) == or {
+				return or, nil
+			}
+		}
+		if unknown {
+			return nil, nil
+		}
+		return !or, nil
+	}
+}
+
+func memNot(n memNode) memNode {
+	return func(get memGetter) (any, error) {
+		v, err := n(get)
+		if err != nil || v == nil {
+			return nil, err
+		}
+		return !memTruthy(v), nil
+	}
+}
+
+func memArith(op string, l, r memNode) memNode {
+	return func(get memGetter) (any, error) {
+		a, b, err := memEval2(get, l, r)
+		if err != nil || a == nil || b == nil {
+			return nil, err
+		}
+		ai, aInt := a.(int64)
+		bi, bInt := b.(int64)
+		if aInt && bInt && op != "/" {
+			switch op {
+			case "+":
+				return ai + bi, nil
+			case "-":
+				return ai - bi, nil
+			default:
+				return ai * bi, nil
+			}
+		}
+		af, ok1 := memFloat(a)
+		bf, ok2 := memFloat(b)
+		if !ok1 || !ok2 {
+			return nil, fmt.Errorf("non-numeric operand for %s", op)
+		}
+		switch op {
+		case "+":
+			return af + bf, nil
+		case "-":
+			return af - bf, nil
+		case "*":
+			return af * bf, nil
+		default:
+			if bf == 0 {
+				return nil, nil // MySQL 除以 0 得到 NULL
+			}
+			return af / bf, nil
+		}
+	}
+}
+
+func memEval2(get memGetter, l, r memNode) (any, any, error) {
+	a, err := l(get)
+	if err != nil {
+		return nil, nil, err
+	}
+	b, err := r(get)
+	if err != nil {
+		return nil, nil, err
+	}
+	return a, b, nil
+}
+
+// memValue 将字段值与参数归一化：解引用指针，driver.Valuer 取其值，
+// 整数 -> int64（超出范围的无符号数 -> uint64），浮点 -> float64，[]byte -> string，bool 保持
+func memValue(v any) any {
+	if v == nil {
+		return nil
+	}
+	if dv, ok := v.(driver.Valuer); ok {
+		rv := reflect.ValueOf(v)
+		if rv.Kind() == reflect.Pointer && rv.IsNil() {
+			return nil
+		}
+		val, err := dv.Value()
+		if err != nil {
+			return nil
+		}
+		return memValue(val)
+	}
+	switch x := v.(type) {
+	case time.Time, string, bool, int64, float64:
+		return x
+	case []byte:
+		return string(x)
+	}
+
+	rv := reflect.ValueOf(v)
+	switch rv.Kind() {
+	case reflect.Pointer, reflect.Interface:
+		if rv.IsNil() {
+			return nil
+		}
+		return memValue(rv.Elem().Interface())
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		return rv.Int()
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64, reflect.Uintptr:
+		if u := rv.Uint(); u <= 1<<63-1 {
+			return int64(u)
+		}
+		return rv.Uint()
+	case reflect.Float32, reflect.Float64:
+		return rv.Float()
+	case reflect.String:
+		return rv.String()
+	case reflect.Bool:
+		return rv.Bool()
+	}
+	return v
+}
+
+// memCompare 比较两个归一化后的值，类型无法比较时 ok 为 false。
+// 与 MySQL 一致：bool 视为 0/1，数字与字符串比较时按数字解析字符串
+func memCompare(a, b any) (c int, ok bool) {
+	a, b = memValue(a), memValue(b)
+	if a == nil || b == nil {
+		return 0, false
+	}
+
+	if x, isStr := a.(string); isStr {
+		if y, isStr := b.(string); isStr {
+			return strings.Compare(x, y), true
+		}
+	}
+	if x, isTime := a.(time.Time); isTime {
+		y, ok := memTime(b)
+		return x.Compare(y), ok
+	}
+	if y, isTime := b.(time.Time); isTime {
+		x, ok := memTime(a)
+		return x.Compare(y), ok
+	}
+
+	if x, isInt := a.(int64); isInt {
+		if y, isInt := b.(int64); isInt {
+			return cmpOrdered(x, y), true
+		}
+	}
+	if x, isUint := a.(uint64); isUint {
+		if y, isUint := b.(uint64); isUint {
+			return cmpOrdered(x, y), true
+		}
+	}
+	x, ok1 := memFloat(a)
+	y, ok2 := memFloat(b)
+	if !ok1 || !ok2 {
+		return 0, false
+	}
+	return cmpOrdered(x, y), true
+}
+
+func memFloat(v any) (float64, bool) {
+	switch x := v.(type) {
+	case int64:
+		return float64(x), true
+	case uint64:
+		return float64(x), true
+	case float64:
+		return x, true
+	case bool:
+		return float64(boolInt(x)), true
+	case string:
+		f, err := strconv.ParseFloat(strings.TrimSpace(x), 64)
+		return f, err == nil
+	}
+	return 0, false
+}
+
+var memTimeLayouts = []string{time.RFC3339Nano, "2006-01-02 15:04:05.999999999", "2006-01-02"}
+
+func memTime(v any) (time.Time, bool) {
+	switch x := v.(type) {
+	case time.Time:
+		return x, true
+	case string:
+		for _, layout := range memTimeLayouts {
+			if t, err := time.ParseInLocation(layout, x, time.Local); err == nil {
+				return t, true
+			}
+		}
+	}
+	return time.Time{}, false
+}
+
+func memTruthy(v any) bool {
+	switch x := memValue(v).(type) {
+	case bool:
+		return x
+	case nil:
+		return false
+	default:
+		f, ok := memFloat(x)
+		return ok && f != 0
+	}
+}
+
+// memExpand 切片参数展开为元素，其余值原样返回
+func memExpand(v any) []any {
+	vals := toAnySlice(v)
+	for i := range vals {
+		vals[i] = memValue(vals[i])
+	}
+	return vals
+}
+
+// likeRegexp 将 LIKE 模式转换为正则：% 任意串，_ 单个字符，\ 转义
+func likeRegexp(pattern string) (*regexp.Regexp, error) {
+	var b strings.Builder
+	b.WriteString("(?s)^")
+	escaped := false
+	for _, r := range pattern {
+		switch {
+		case escaped:
+			b.WriteString(regexp.QuoteMeta(string(r)))
+			escaped = false
+		case r == '\\':
+			escaped = true
+		case r == '%':
+			b.WriteString(".*")
+		case r == '_':
+			b.WriteString(".")
+		default:
+			b.WriteString(regexp.QuoteMeta(string(r)))
+		}
+	}
+	b.WriteString("$")
+	return regexp.Compile(b.String())
+}
+
+type memTokKind int
+
+const (
+	memTokEOF memTokKind = iota
+	memTokIdent
+	memTokNumber
+	memTokString
+	memTokOp
+	memTokParam
+	memTokLParen
+	memTokRParen
+	memTokComma
+)
+
+type memTok struct {
+	kind memTokKind
+	text string
+}
+
+func memTokenize(sql string) ([]memTok, error) {
+	var toks []memTok
+	rs := []rune(sql)
+	for i := 0; i < len(rs); {
+		r := rs[i]
+		switch {
+		case unicode.IsSpace(r):
+			i++
+		case r == '?':
+			toks = append(toks, memTok{kind: memTokParam, text: "?"})
+			i++
+		case r == '(':
+			toks = append(toks, memTok{kind: memTokLParen, text: "("})
+			i++
+		case r == ')':
+			toks = append(toks, memTok{kind: memTokRParen, text: ")"})
+			i++
+		case r == ',':
+			toks = append(toks, memTok{kind: memTokComma, text: ","})
+			i++
+		case r == '\'':
+			var b strings.Builder
+			i++
+			for {
+				if i >= len(rs) {
+					return nil, fmt.Errorf("unterminated string")
+				}
+				if rs[i] == '\'' {
+					if i+1 < len(rs) && rs[i+1] == '\'' {
+						b.WriteRune('\'')
+						i += 2
+						continue
+					}
+					i++
+					break
+				}
+				b.WriteRune(rs[i])
+				i++
+			}
+			toks = append(toks, memTok{kind: memTokString, text: b.String()})
+		case unicode.IsDigit(r):
+			j := i
+			for j < len(rs) && (unicode.IsDigit(rs[j]) || rs[j] == '.') {
+				j++
+			}
+			toks = append(toks, memTok{kind: memTokNumber, text: string(rs[i:j])})
+			i = j
+		case r == '_' || r == '`' || unicode.IsLetter(r):
+			j := i
+			for j < len(rs) && (rs[j] == '_' || rs[j] == '`' || rs[j] == '.' || unicode.IsLetter(rs[j]) || unicode.IsDigit(rs[j])) {
+				j++
+			}
+			toks = append(toks, memTok{kind: memTokIdent, text: string(rs[i:j])})
+			i = j
+		case strings.ContainsRune("=<>!+-*/", r):
+			op := string(r)
+			if i+1 < len(rs) {
+				if two := string(rs[i : i+2]); two == "<=" || two == ">=" || two == "<>" || two == "!=" {
+					op = two
+				}
+			}
+			if op == "!" {
+				return nil, fmt.Errorf("unexpected %q", op)
+			}
+			toks = append(toks, memTok{kind: memTokOp, text: op})
+			i += len(op)
+		default:
+			return nil, fmt.Errorf("unexpected %q", string(r))
+		}
+	}
+	return append(toks, memTok{kind: memTokEOF}), nil
+}
+
+type memParser struct {
+	toks []memTok
+	pos  int
+	args []any
+	used int
+}
+
+func (p *memParser) peek() memTok {
+	return p.toks[p.pos]
+}
+
+func (p *memParser) next() memTok {
+	t := p.toks[p.pos]
+	if t.kind != memTokEOF {
+		p.pos++
+	}
+	return t
+}
+
+// keyword 当前 token 是给定关键字时消费并返回 true
+func (p *memParser) keyword(kw string) bool {
+	t := p.peek()
+	if t.kind == memTokIdent && strings.EqualFold(t.text, kw) {
+		p.pos++
+		return true
+	}
+	return false
+}
+
+func (p *memParser) expect(kind memTokKind, text string) error {
+	if t := p.next(); t.kind != kind {
+		return fmt.Errorf("expected %q, got %q", text, t.text)
+	}
+	return nil
+}
+
+func (p *memParser) arg() (any, error) {
+	if p.used >= len(p.args) {
+		return nil, fmt.Errorf("not enough args")
+	}
+	v := p.args[p.used]
+	p.used++
+	return v, nil
+}
+
+func (p *memParser) parseOr() (memNode, error) {
+	left, err := p.parseAnd()
+	if err != nil {
+		return nil, err
+	}
+	nodes := []memNode{left}
+	for p.keyword("OR") {
+		right, err := p.parseAnd()
+		if err != nil {
+			return nil, err
+		}
+		nodes = append(nodes, right)
+	}
+	if len(nodes) == 1 {
+		return left, nil
+	}
+	return memLogic(true, nodes...), nil
+}
+
+func (p *memParser) parseAnd() (memNode, error) {
+	left, err := p.parseNot()
+	if err != nil {
+		return nil, err
+	}
+	nodes := []memNode{left}
+	for p.keyword("AND") {
+		right, err := p.parseNot()
+		if err != nil {
+			return nil, err
+		}
+		nodes = append(nodes, right)
+	}
+	if len(nodes) == 1 {
+		return left, nil
+	}
+	return memLogic(false, nodes...), nil
+}
+
+func (p *memParser) parseNot() (memNode, error) {
+	if p.keyword("NOT") {
+		n, err := p.parseNot()
+		if err != nil {
+			return nil, err
+		}
+		return memNot(n), nil
+	}
+	return p.parsePredicate()
+}
+
+func (p *memParser) parsePredicate() (memNode, error) {
+	left, err := p.parseAdditive()
+	if err != nil {
+		return nil, err
+	}
+
+	if t := p.peek(); t.kind == memTokOp {
+		op := t.text
+		switch op {
+		case "=", "<", "<=", ">", ">=":
+		case "!=", "<>":
+			op = opNe
+		default:
+			return left, nil
+		}
+		p.pos++
+		right, err := p.parseAdditive()
+		if err != nil {
+			return nil, err
+		}
+		return memCompareNode(op, left, right), nil
+	}
+
+	if p.keyword("IS") {
+		not := p.keyword("NOT")
+		if !p.keyword("NULL") {
+			return nil, fmt.Errorf("expected NULL after IS")
+		}
+		return memIsNull(left, not), nil
+	}
+
+	not := p.keyword("NOT")
+	switch {
+	case p.keyword("IN"):
+		list, err := p.parseInList()
+		if err != nil {
+			return nil, err
+		}
+		return memIn(left, list, not), nil
+	case p.keyword("LIKE"):
+		pattern, err := p.parseAdditive()
+		if err != nil {
+			return nil, err
+		}
+		return memLike(left, pattern, not), nil
+	case p.keyword("BETWEEN"):
+		low, err := p.parseAdditive()
+		if err != nil {
+			return nil, err
+		}
+		if !p.keyword("AND") {
+			return nil, fmt.Errorf("expected AND in BETWEEN")
+		}
+		high, err := p.parseAdditive()
+		if err != nil {
+			return nil, err
+		}
+		return memBetween(left, low, high, not), nil
+	}
+	if not {
+		return nil, fmt.Errorf("expected IN / LIKE / BETWEEN after NOT")
+	}
+	return left, nil
+}
+
+func (p *memParser) parseInList() ([]memNode, error) {
+	if p.peek().kind == memTokParam {
+		p.pos++
+		v, err := p.arg()
+		if err != nil {
+			return nil, err
+		}
+		return []memNode{memConst(v)}, nil
+	}
+	if err := p.expect(memTokLParen, "("); err != nil {
+		return nil, err
+	}
+	var list []memNode
+	for {
+		n, err := p.parseAdditive()
+		if err != nil {
+			return nil, err
+		}
+		list = append(list, n)
+		if p.peek().kind != memTokComma {
+			break
+		}
+		p.pos++
+	}
+	return list, p.expect(memTokRParen, ")")
+}
+
+func (p *memParser) parseAdditive() (memNode, error) {
+	left, err := p.parseMultiplicative()
+	if err != nil {
+		return nil, err
+	}
+	for t := p.peek(); t.kind == memTokOp && (t.text == "+" || t.text == "-"); t = p.peek() {
+		p.pos++
+		right, err := p.parseMultiplicative()
+		if err != nil {
+			return nil, err
+		}
+		left = memArith(t.text, left, right)
+	}
+	return left, nil
+}
+
+func (p *memParser) parseMultiplicative() (memNode, error) {
+	left, err := p.parseUnary()
+	if err != nil {
+		return nil, err
+	}
+	for t := p.peek(); t.kind == memTokOp && (t.text == "*" || t.text == "/"); t = p.peek() {
+		p.pos++
+		right, err := p.parseUnary()
+		if err != nil {
+			return nil, err
+		}
+		left = memArith(t.text, left, right)
+	}
+	return left, nil
+}
+
+func (p *memParser) parseUnary() (memNode, error) {
+	if t := p.peek(); t.kind == memTokOp && t.text == "-" {
+		p.pos++
+		n, err := p.parseUnary()
+		if err != nil {
+			return nil, err
+		}
+		return memArith("-", memConst(int64(0)), n), nil
+	}
+	return p.parsePrimary()
+}
+
+func (p *memParser) parsePrimary() (memNode, error) {
+	t := p.next()
+	switch t.kind {
+	case memTokLParen:
+		n, err := p.parseOr()
+		if err != nil {
+			return nil, err
+		}
+		return n, p.expect(memTokRParen, ")")
+	case memTokParam:
+		v, err := p.arg()
+		if err != nil {
+			return nil, err
+		}
+		return memConst(v), nil
+	case memTokNumber:
+		if i, err := strconv.ParseInt(t.text, 10, 64); err == nil {
+			return memConst(i), nil
+		}
+		f, err := strconv.ParseFloat(t.text, 64)
+		if err != nil {
+			return nil, fmt.Errorf("bad number %q", t.text)
+		}
+		return memConst(f), nil
+	case memTokString:
+		return memConst(t.text), nil
+	case memTokIdent:
+		switch strings.ToUpper(t.text) {
+		case "TRUE":
+			return memConst(true), nil
+		case "FALSE":
+			return memConst(false), nil
+		case "NULL":
+			return memConst(nil), nil
+		case "AND", "OR", "NOT", "IN", "IS", "LIKE", "BETWEEN":
+			return nil, fmt.Errorf("unexpected %q", t.text)
+		}
+		if p.peek().kind == memTokLParen {
+			return nil, fmt.Errorf("function %s is not supported", t.text)
+		}
+		return memColumn(t.text), nil
+	}
+	return nil, fmt.Errorf("unexpected %q", t.text)
+}
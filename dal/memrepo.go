@@ -0,0 +1,927 @@
+package dal
+
+import (
+	"context"
+	"net/http"
+	"reflect"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/xsda-pixel/common-infra/errors"
+
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+	"gorm.io/gorm/schema"
+)
+
+const defaultMemLockWait = time.Second
+
+// MemDB 内存仓库的模拟事务与行锁；多个 MemRepo 共用同一 MemDB 时，可以在同一个模拟事务中读写
+type MemDB struct {
+	LockWait time.Duration // 等待其他事务持有的行锁的超时，超时返回 ErrLockWaitTimeout，默认 1s
+
+	mu       sync.Mutex
+	released chan struct{} // 每次释放行锁时关闭并替换，唤醒等待者
+	locks    map[memLockKey]*gorm.DB
+	txs      map[*gorm.DB]*memTx
+}
+
+type memLockKey struct {
+	repo  any // 所属 MemRepo，不同仓库的同名表互不影响
+	table string
+	id    uint64
+}
+
+type memTx struct {
+	undo []func() // 回滚时逆序执行
+}
+
+func NewMemDB() *MemDB {
+	return &MemDB{
+		released: make(chan struct{}),
+		locks:    make(map[memLockKey]*gorm.DB),
+		txs:      make(map[*gorm.DB]*memTx),
+	}
+}
+
+// Transaction 开启模拟事务，tx 只作为事务标识传给 MemRepo 的方法，不能用于真实查询。
+// fn 返回错误或 panic 时逆序撤销事务内的写入；结束时释放事务持有的全部行锁。
+// 事务内的写入对其他读立即可见（相当于 READ UNCOMMITTED），只有 FindOneForUpdate 与写操作会因行锁等待
+func (d *MemDB) Transaction(fn func(tx *gorm.DB) error) (err error) {
+	tx := &gorm.DB{Config: &gorm.Config{}, Statement: &gorm.Statement{Context: context.Background()}}
+	d.mu.Lock()
+	d.txs[tx] = &memTx{}
+	d.mu.Unlock()
+
+	defer func() {
+		r := recover()
+
+		d.mu.Lock()
+		t := d.txs[tx]
+		delete(d.txs, tx)
+		if err != nil || r != nil {
+			for i := len(t.undo) - 1; i >= 0; i-- {
+				t.undo[i]()
+			}
+		}
+		for k, owner := range d.locks {
+			if owner == tx {
+				delete(d.locks, k)
+			}
+		}
+		close(d.released)
+		d.released = make(chan struct{})
+		d.mu.Unlock()
+
+		if r != nil {
+			panic(r)
+		}
+	}()
+
+	return fn(tx)
+}
+
+// acquire 在持有 mu 时为 tx 锁定 match 选出的行；行被其他事务锁定时释放 mu 等待，唤醒后重新选行。
+// tx 不是模拟事务时（自动提交）只等待、不持有锁
+func (d *MemDB) acquire(repo any, table string, tx *gorm.DB, match func() ([]uint64, errors.Error)) errors.Error {
+	wait := d.LockWait
+	if wait <= 0 {
+		wait = defaultMemLockWait
+	}
+	deadline := time.Now().Add(wait)
+
+	_, inTx := d.txs[tx]
+	for {
+		ids, err := match()
+		if err != nil {
+			return err
+		}
+
+		blocked := false
+		for _, id := range ids {
+			if owner, ok := d.locks[memLockKey{repo, table, id}]; ok && owner != tx {
+				blocked = true
+				break
+			}
+		}
+		if !blocked {
+			if inTx {
+				for _, id := range ids {
+					d.locks[memLockKey{repo, table, id}] = tx
+				}
+			}
+			return nil
+		}
+
+		left := time.Until(deadline)
+		if left <= 0 {
+			return ErrLockWaitTimeout
+		}
+		released := d.released
+		d.mu.Unlock()
+		timer := time.NewTimer(left)
+		select {
+		case <-released:
+		case <-timer.C:
+		}
+		timer.Stop()
+		d.mu.Lock()
+	}
+}
+
+// record 在模拟事务中记录撤销操作，自动提交时忽略
+func (d *MemDB) record(tx *gorm.DB, undo func()) {
+	if t, ok := d.txs[tx]; ok {
+		t.undo = append(t.undo, undo)
+	}
+}
+
+// MemRepo 内存实现的 ReadRepo / WriteRepo，用于不依赖 MySQL 的单元测试。
+// 列名按 T 的 gorm schema 解析（column 标签或默认命名），WhereOption 的 Eq / Cond 全部支持，RawWhere 支持常见子集（见 compileRaw）；
+// 主键与 unique 索引冲突时返回 ErrDuplicateKey，整数主键为零值时自增。
+// WithContext 绑定的 ctx 已取消或超时时，操作直接返回与 RepoDB 相同分类的 ctx 错误。
+// 不支持的部分：联表、分组、租户、软删除、缓存与审计等 RepoConfig 能力
+type MemRepo[T any] struct {
+	ctx    context.Context
+	db     *MemDB
+	sch    *schema.Schema
+	schErr error
+	tables map[string]*memTable[T]
+}
+
+type memTable[T any] struct {
+	rows    []*memRow[T] // 按插入顺序
+	nextID  uint64
+	autoInc int64
+}
+
+type memRow[T any] struct {
+	id  uint64 // 内部行号，用于行锁与回滚
+	val *T
+}
+
+var (
+	_ ReadRepo[any]  = (*MemRepo[any])(nil)
+	_ WriteRepo[any] = (*MemRepo[any])(nil)
+)
+
+// NewMemRepo 创建内存仓库，db 为 nil 时使用独立的 MemDB
+func NewMemRepo[T any](db *MemDB) *MemRepo[T] {
+	if db == nil {
+		db = NewMemDB()
+	}
+	sch, err := schema.Parse(new(T), &schemaCache, schema.NamingStrategy{})
+	return &MemRepo[T]{db: db, sch: sch, schErr: err, tables: make(map[string]*memTable[T])}
+}
+
+// DB 返回仓库使用的 MemDB，用于开启模拟事务
+func (m *MemRepo[T]) DB() *MemDB {
+	return m.db
+}
+
+// WithContext 返回绑定 ctx 的仓库副本，与原仓库共享数据
+func (m *MemRepo[T]) WithContext(ctx context.Context) *MemRepo[T] {
+	repo := *m
+	repo.ctx = ctx
+	return &repo
+}
+
+func (m *MemRepo[T]) ReadWithContext(ctx context.Context) ReadRepo[T] {
+	return m.WithContext(ctx)
+}
+
+func (m *MemRepo[T]) WriteWithContext(ctx context.Context) WriteRepo[T] {
+	return m.WithContext(ctx)
+}
+
+// All 返回表中全部行的副本，按插入顺序，便于断言
+func (m *MemRepo[T]) All(tableName string) []*T {
+	m.db.mu.Lock()
+	defer m.db.mu.Unlock()
+
+	t := m.table(tableName)
+	list := make([]*T, 0, len(t.rows))
+	for _, r := range t.rows {
+		list = append(list, memCopy(r.val))
+	}
+	return list
+}
+
+func (m *MemRepo[T]) FindOne(tableName string, fields []string, where WhereOption) (*T, errors.Error) {
+	list, err := m.FindMany(tableName, fields, where, nil, nil)
+	if err != nil || len(list) == 0 {
+		return nil, err
+	}
+	return list[0], nil
+}
+
+// FindOneForUpdate tx 为 MemDB.Transaction 的模拟事务时锁定命中的行直到事务结束，其他事务的加锁读与写入会等待
+func (m *MemRepo[T]) FindOneForUpdate(tx *gorm.DB, tableName string, fields []string, where WhereOption) (*T, errors.Error) {
+	if err := m.ready(); err != nil {
+		return nil, err
+	}
+	m.db.mu.Lock()
+	defer m.db.mu.Unlock()
+
+	var row *memRow[T]
+	err := m.db.acquire(m, memTableName(tableName), tx, func() ([]uint64, errors.Error) {
+		rows, err := m.filter(tableName, where)
+		if err != nil || len(rows) == 0 {
+			row = nil
+			return nil, err
+		}
+		row = rows[0]
+		return []uint64{row.id}, nil
+	})
+	if err != nil || row == nil {
+		return nil, err
+	}
+	return m.project(row.val, fields), nil
+}
+
+func (m *MemRepo[T]) FindMany(tableName string, fields []string, where WhereOption, order *string, limit *int) ([]*T, errors.Error) {
+	n := 0
+	if limit != nil {
+		n = *limit
+	}
+	return m.find(tableName, fields, where, order, 0, n)
+}
+
+func (m *MemRepo[T]) FindPage(tableName string, page, limit int, fields []string, where WhereOption, order *string) ([]*T, errors.Error) {
+	if page < 1 || limit < 1 {
+		return nil, nil
+	}
+	offset := (page - 1) * limit
+	if offset < 0 {
+		return nil, nil
+	}
+	return m.find(tableName, fields, where, order, offset, limit)
+}
+
+func (m *MemRepo[T]) Exists(tableName string, where WhereOption) (bool, errors.Error) {
+	n, err := m.Count(tableName, where)
+	return n > 0, err
+}
+
+func (m *MemRepo[T]) Count(tableName string, where WhereOption) (int64, errors.Error) {
+	if err := m.ready(); err != nil {
+		return 0, err
+	}
+	m.db.mu.Lock()
+	defer m.db.mu.Unlock()
+
+	rows, err := m.filter(tableName, where)
+	if err != nil {
+		return 0, err
+	}
+	return int64(len(rows)), nil
+}
+
+func (m *MemRepo[T]) CreateOne(db *gorm.DB, tableName string, item *T) errors.Error {
+	_, err := m.CreateMany(db, tableName, []*T{item}, 1)
+	return err
+}
+
+func (m *MemRepo[T]) Update(db *gorm.DB, tableName string, where WhereOption, updates map[string]any) (int64, errors.Error) {
+	if len(updates) == 0 {
+		return 0, nil
+	}
+	if err := m.ready(); err != nil {
+		return 0, err
+	}
+	m.db.mu.Lock()
+	defer m.db.mu.Unlock()
+
+	var rows []*memRow[T]
+	err := m.db.acquire(m, memTableName(tableName), db, func() ([]uint64, errors.Error) {
+		var err errors.Error
+		rows, err = m.filter(tableName, where)
+		return memIDs(rows), err
+	})
+	if err != nil {
+		return 0, err
+	}
+	return m.apply(db, rows, updates)
+}
+
+// Delete 物理删除，与 RepoDB 一样拒绝没有条件的删除
+func (m *MemRepo[T]) Delete(db *gorm.DB, tableName string, where WhereOption) (int64, errors.Error) {
+	if where.IsEmpty() {
+		return 0, errors.NewError(http.StatusBadRequest, errors.NewMsg("delete without where is forbidden"))
+	}
+	if err := m.ready(); err != nil {
+		return 0, err
+	}
+	m.db.mu.Lock()
+	defer m.db.mu.Unlock()
+
+	var rows []*memRow[T]
+	err := m.db.acquire(m, memTableName(tableName), db, func() ([]uint64, errors.Error) {
+		var err errors.Error
+		rows, err = m.filter(tableName, where)
+		return memIDs(rows), err
+	})
+	if err != nil || len(rows) == 0 {
+		return 0, err
+	}
+
+	t := m.table(tableName)
+	deleted := make(map[uint64]bool, len(rows))
+	for _, r := range rows {
+		deleted[r.id] = true
+	}
+	kept := t.rows[:0:0]
+	for _, r := range t.rows {
+		if !deleted[r.id] {
+			kept = append(kept, r)
+		}
+	}
+	t.rows = kept
+
+	m.db.record(db, func() {
+		t.rows = append(t.rows, rows...)
+		sort.Slice(t.rows, func(i, j int) bool { return t.rows[i].id < t.rows[j].id })
+	})
+	return int64(len(rows)), nil
+}
+
+// CreateMany 分批插入，每批要么全部成功要么全部失败；出错时返回已完成批次的影响行数
+func (m *MemRepo[T]) CreateMany(db *gorm.DB, tableName string, items []*T, chunkSize int) ([]int64, errors.Error) {
+	if len(items) == 0 {
+		return nil, nil
+	}
+	for _, item := range items {
+		if item == nil {
+			return nil, errors.NewError(http.StatusBadRequest, errors.NewMsg("insert: item is nil"))
+		}
+	}
+	if err := m.ready(); err != nil {
+		return nil, err
+	}
+	if chunkSize <= 0 {
+		chunkSize = defaultChunkSize
+	}
+
+	m.db.mu.Lock()
+	defer m.db.mu.Unlock()
+
+	counts := make([]int64, 0, (len(items)+chunkSize-1)/chunkSize)
+	for start := 0; start < len(items); start += chunkSize {
+		end := min(start+chunkSize, len(items))
+		if err := m.insert(db, tableName, items[start:end]); err != nil {
+			return counts, err
+		}
+		counts = append(counts, int64(end-start))
+	}
+	return counts, nil
+}
+
+// Upsert 按 ConflictColumns（为空时按主键与 unique 索引）查找已有行：不存在时插入计 1，
+// 存在时更新 UpdateColumns（为空时更新除冲突列与主键外的全部列），有变化计 2，无变化计 0
+func (m *MemRepo[T]) Upsert(db *gorm.DB, tableName string, items []*T, opt UpsertOption) ([]int64, errors.Error) {
+	if len(items) == 0 {
+		return nil, nil
+	}
+	for _, item := range items {
+		if item == nil {
+			return nil, errors.NewError(http.StatusBadRequest, errors.NewMsg("insert: item is nil"))
+		}
+	}
+	if err := m.ready(); err != nil {
+		return nil, err
+	}
+
+	var keys [][]*schema.Field
+	if len(opt.ConflictColumns) > 0 {
+		key, err := m.fields(opt.ConflictColumns)
+		if err != nil {
+			return nil, err
+		}
+		keys = [][]*schema.Field{key}
+	} else {
+		keys = m.uniqueKeys()
+	}
+
+	updateCols := opt.UpdateColumns
+	if len(updateCols) == 0 {
+		skip := make(map[string]bool)
+		for _, key := range keys {
+			for _, f := range key {
+				skip[f.DBName] = true
+			}
+		}
+		for _, f := range m.sch.PrimaryFields {
+			skip[f.DBName] = true
+		}
+		for _, name := range m.sch.DBNames {
+			if !skip[name] {
+				updateCols = append(updateCols, name)
+			}
+		}
+	}
+	if _, err := m.fields(updateCols); err != nil {
+		return nil, err
+	}
+
+	chunkSize := opt.ChunkSize
+	if chunkSize <= 0 {
+		chunkSize = defaultChunkSize
+	}
+
+	m.db.mu.Lock()
+	defer m.db.mu.Unlock()
+
+	table := memTableName(tableName)
+	counts := make([]int64, 0, (len(items)+chunkSize-1)/chunkSize)
+	for start := 0; start < len(items); start += chunkSize {
+		end := min(start+chunkSize, len(items))
+		var n int64
+		for _, item := range items[start:end] {
+			var existing *memRow[T]
+			err := m.db.acquire(m, table, db, func() ([]uint64, errors.Error) {
+				existing = m.conflict(m.table(tableName), item, keys)
+				if existing == nil {
+					return nil, nil
+				}
+				return []uint64{existing.id}, nil
+			})
+			if err != nil {
+				return counts, err
+			}
+
+			if existing == nil {
+				if err := m.insert(db, tableName, []*T{item}); err != nil {
+					return counts, err
+				}
+				n++
+				continue
+			}
+
+			updates := make(map[string]any, len(updateCols))
+			for _, col := range updateCols {
+				updates[col] = m.value(lookupField(m.sch, col), item)
+			}
+			changed, err := m.apply(db, []*memRow[T]{existing}, updates)
+			if err != nil {
+				return counts, err
+			}
+			n += changed * 2
+		}
+		counts = append(counts, n)
+	}
+	return counts, nil
+}
+
+// BulkUpdateByKey 按 keyColumn 逐行更新，返回每批有变化的行数
+func (m *MemRepo[T]) BulkUpdateByKey(db *gorm.DB, tableName string, keyColumn string, rows []KeyedUpdate, chunkSize int) ([]int64, errors.Error) {
+	if len(rows) == 0 {
+		return nil, nil
+	}
+	if strings.TrimSpace(keyColumn) == "" {
+		return nil, errors.NewError(http.StatusBadRequest, errors.NewMsg("BulkUpdateByKey: key column is empty"))
+	}
+	if err := m.ready(); err != nil {
+		return nil, err
+	}
+	if chunkSize <= 0 {
+		chunkSize = defaultChunkSize
+	}
+
+	m.db.mu.Lock()
+	defer m.db.mu.Unlock()
+
+	table := memTableName(tableName)
+	counts := make([]int64, 0, (len(rows)+chunkSize-1)/chunkSize)
+	for start := 0; start < len(rows); start += chunkSize {
+		end := min(start+chunkSize, len(rows))
+		var n int64
+		for _, row := range rows[start:end] {
+			if len(row.Values) == 0 {
+				continue
+			}
+			var matched []*memRow[T]
+			err := m.db.acquire(m, table, db, func() ([]uint64, errors.Error) {
+				var err errors.Error
+				matched, err = m.filter(tableName, WhereOption{Cond: Eq(keyColumn, row.Key)})
+				return memIDs(matched), err
+			})
+			if err != nil {
+				return counts, err
+			}
+			changed, err := m.apply(db, matched, row.Values)
+			if err != nil {
+				return counts, err
+			}
+			n += changed
+		}
+		counts = append(counts, n)
+	}
+	return counts, nil
+}
+
+func (m *MemRepo[T]) find(tableName string, fields []string, where WhereOption, order *string, offset, limit int) ([]*T, errors.Error) {
+	if err := m.ready(); err != nil {
+		return nil, err
+	}
+	m.db.mu.Lock()
+	defer m.db.mu.Unlock()
+
+	rows, err := m.filter(tableName, where)
+	if err != nil {
+		return nil, err
+	}
+	if order != nil && *order != "" {
+		if err := m.sort(rows, *order); err != nil {
+			return nil, err
+		}
+	}
+
+	if offset >= len(rows) {
+		return nil, nil
+	}
+	rows = rows[offset:]
+	if limit > 0 && limit < len(rows) {
+		rows = rows[:limit]
+	}
+
+	list := make([]*T, 0, len(rows))
+	for _, r := range rows {
+		list = append(list, m.project(r.val, fields))
+	}
+	return list, nil
+}
+
+// filter 返回满足 where 的行，调用方需持有 mu
+func (m *MemRepo[T]) filter(tableName string, where WhereOption) ([]*memRow[T], errors.Error) {
+	pred, err := m.compile(where)
+	if err != nil {
+		return nil, err
+	}
+
+	var rows []*memRow[T]
+	for _, r := range m.table(tableName).rows {
+		v, e := pred(m.getter(r.val))
+		if e != nil {
+			return nil, invalidQuery("MemRepo: %v", e)
+		}
+		if memTruthy(v) {
+			rows = append(rows, r)
+		}
+	}
+	return rows, nil
+}
+
+// compile 将 Eq / Raw / Cond 以 AND 组合为一个表达式；Eq 的值为切片时按 IN 处理，为 nil 时按 IS NULL 处理
+func (m *MemRepo[T]) compile(where WhereOption) (memNode, errors.Error) {
+	var nodes []memNode
+
+	keys := make([]string, 0, len(where.Eq))
+	for k := range where.Eq {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	for _, k := range keys {
+		v := where.Eq[k]
+		var n memNode
+		if rv := reflect.ValueOf(v); v != nil && (rv.Kind() == reflect.Slice || rv.Kind() == reflect.Array) && rv.Type().Elem().Kind() != reflect.Uint8 {
+			n, _ = compileCond(In(k, v))
+		} else {
+			n, _ = compileCond(Eq(k, v))
+		}
+		nodes = append(nodes, n)
+	}
+
+	if where.Raw != nil && strings.TrimSpace(where.Raw.SQL) != "" {
+		n, err := compileRaw(where.Raw.SQL, where.Raw.Args)
+		if err != nil {
+			return nil, err
+		}
+		nodes = append(nodes, n)
+	}
+
+	if where.Cond != nil {
+		n, err := compileCond(where.Cond)
+		if err != nil {
+			return nil, err
+		}
+		if n != nil {
+			nodes = append(nodes, n)
+		}
+	}
+
+	if len(nodes) == 0 {
+		return memConst(true), nil
+	}
+	return memLogic(false, nodes...), nil
+}
+
+func (m *MemRepo[T]) getter(item *T) memGetter {
+	return func(column string) (any, error) {
+		field := lookupField(m.sch, column)
+		if field == nil {
+			return nil, invalidQuery("unknown column %s", column)
+		}
+		return memValue(m.value(field, item)), nil
+	}
+}
+
+// sort 按 "col [ASC|DESC], ..." 稳定排序，NULL 排在最前（与 MySQL 升序一致）
+func (m *MemRepo[T]) sort(rows []*memRow[T], order string) errors.Error {
+	type key struct {
+		field *schema.Field
+		desc  bool
+	}
+	var keys []key
+	for _, part := range strings.Split(order, ",") {
+		words := strings.Fields(part)
+		if len(words) == 0 || len(words) > 2 {
+			return invalidQuery("MemRepo: unsupported order %q", order)
+		}
+		field := lookupField(m.sch, words[0])
+		if field == nil {
+			return invalidQuery("unknown column %s", words[0])
+		}
+		desc := false
+		if len(words) == 2 {
+			switch strings.ToUpper(words[1]) {
+			case "ASC":
+			case "DESC":
+				desc = true
+			default:
+				return invalidQuery("MemRepo: unsupported order %q", order)
+			}
+		}
+		keys = append(keys, key{field: field, desc: desc})
+	}
+
+	sort.SliceStable(rows, func(i, j int) bool {
+		for _, k := range keys {
+			a := memValue(m.value(k.field, rows[i].val))
+			b := memValue(m.value(k.field, rows[j].val))
+			c := 0
+			switch {
+			case a == nil && b == nil:
+			case a == nil:
+				c = -1
+			case b == nil:
+				c = 1
+			default:
+				c, _ = memCompare(a, b)
+			}
+			if c != 0 {
+				return (c < 0) != k.desc
+			}
+		}
+		return false
+	})
+	return nil
+}
+
+// insert 插入一批行：整数主键为零值时自增并回写到 item，校验主键与 unique 索引；任一行冲突时整批不插入
+func (m *MemRepo[T]) insert(db *gorm.DB, tableName string, items []*T) errors.Error {
+	t := m.table(tableName)
+	pk := m.sch.PrioritizedPrimaryField
+	now := time.Now()
+
+	keys := m.uniqueKeys()
+	pending := make([]*memRow[T], 0, len(items))
+	autoInc := t.autoInc
+	for _, item := range items {
+		cp := memCopy(item)
+		rv := reflect.ValueOf(cp).Elem()
+		if pk != nil && pk.AutoIncrement {
+			if v, zero := pk.ValueOf(context.Background(), rv); zero {
+				autoInc++
+				if err := pk.Set(context.Background(), rv, autoInc); err != nil {
+					return m.wrapErr(err)
+				}
+			} else if n, ok := memValue(v).(int64); ok && n > autoInc {
+				autoInc = n
+			}
+		}
+		for _, f := range m.sch.Fields {
+			if f.AutoCreateTime > 0 || f.AutoUpdateTime > 0 {
+				if _, zero := f.ValueOf(context.Background(), rv); zero {
+					_ = f.Set(context.Background(), rv, now)
+				}
+			}
+		}
+
+		existing := &memTable[T]{rows: append(append([]*memRow[T]{}, t.rows...), pending...)}
+		if m.conflict(existing, cp, keys) != nil {
+			return ErrDuplicateKey
+		}
+		pending = append(pending, &memRow[T]{val: cp})
+	}
+
+	_, inTx := m.db.txs[db]
+	prevAutoInc := t.autoInc
+	for i, r := range pending {
+		t.nextID++
+		r.id = t.nextID
+		*items[i] = *memCopy(r.val) // 回写自增主键与自动时间
+		if inTx {
+			m.db.locks[memLockKey{m, memTableName(tableName), r.id}] = db
+		}
+	}
+	t.rows = append(t.rows, pending...)
+	t.autoInc = autoInc
+
+	m.db.record(db, func() {
+		added := make(map[uint64]bool, len(pending))
+		for _, r := range pending {
+			added[r.id] = true
+		}
+		kept := t.rows[:0:0]
+		for _, r := range t.rows {
+			if !added[r.id] {
+				kept = append(kept, r)
+			}
+		}
+		t.rows = kept
+		t.autoInc = prevAutoInc
+	})
+	return nil
+}
+
+// apply 在行上执行更新，返回有变化的行数；值为 gorm.Expr 时按 compileRaw 的子集基于当前行求值
+func (m *MemRepo[T]) apply(db *gorm.DB, rows []*memRow[T], updates map[string]any) (int64, errors.Error) {
+	cols := make([]string, 0, len(updates))
+	for col := range updates {
+		cols = append(cols, col)
+	}
+	sort.Strings(cols)
+	fields, err := m.fields(cols)
+	if err != nil {
+		return 0, err
+	}
+
+	var changed int64
+	for _, r := range rows {
+		cp := memCopy(r.val)
+		rv := reflect.ValueOf(cp).Elem()
+		for i, col := range cols {
+			v := updates[col]
+			if expr, ok := v.(clause.Expr); ok {
+				n, err := compileRaw(expr.SQL, expr.Vars)
+				if err != nil {
+					return changed, err
+				}
+				res, e := n(m.getter(r.val))
+				if e != nil {
+					return changed, invalidQuery("MemRepo: %v", e)
+				}
+				v = res
+			}
+			if err := fields[i].Set(context.Background(), rv, v); err != nil {
+				return changed, invalidQuery("MemRepo: set %s: %v", col, err)
+			}
+		}
+		if reflect.DeepEqual(cp, r.val) {
+			continue
+		}
+		for _, f := range m.sch.Fields {
+			if f.AutoUpdateTime > 0 && updates[f.DBName] == nil {
+				_ = f.Set(context.Background(), rv, time.Now())
+			}
+		}
+
+		row, old := r, r.val
+		row.val = cp
+		changed++
+		m.db.record(db, func() { row.val = old })
+	}
+	return changed, nil
+}
+
+// conflict 返回与 item 在任一唯一键上相同的行；键中含 NULL 时不冲突
+func (m *MemRepo[T]) conflict(t *memTable[T], item *T, keys [][]*schema.Field) *memRow[T] {
+	for _, r := range t.rows {
+	next:
+		for _, key := range keys {
+			for _, f := range key {
+				a, b := memValue(m.value(f, item)), memValue(m.value(f, r.val))
+				if a == nil || b == nil {
+					continue next
+				}
+				if c, ok := memCompare(a, b); !ok || c != 0 {
+					continue next
+				}
+			}
+			return r
+		}
+	}
+	return nil
+}
+
+// uniqueKeys 主键与 unique / uniqueIndex 标签定义的唯一键
+func (m *MemRepo[T]) uniqueKeys() [][]*schema.Field {
+	var keys [][]*schema.Field
+	if len(m.sch.PrimaryFields) > 0 {
+		keys = append(keys, m.sch.PrimaryFields)
+	}
+	for _, f := range m.sch.Fields {
+		if f.Unique {
+			keys = append(keys, []*schema.Field{f})
+		}
+	}
+	for _, idx := range m.sch.ParseIndexes() {
+		if idx.Class != "UNIQUE" {
+			continue
+		}
+		key := make([]*schema.Field, 0, len(idx.Fields))
+		for _, opt := range idx.Fields {
+			key = append(key, opt.Field)
+		}
+		keys = append(keys, key)
+	}
+	return keys
+}
+
+func (m *MemRepo[T]) fields(columns []string) ([]*schema.Field, errors.Error) {
+	fields := make([]*schema.Field, len(columns))
+	for i, col := range columns {
+		if fields[i] = lookupField(m.sch, col); fields[i] == nil {
+			return nil, invalidQuery("unknown column %s", col)
+		}
+	}
+	return fields, nil
+}
+
+// project 返回只填充 fields 列的副本，fields 为空、含 * 或表达式时返回整行
+func (m *MemRepo[T]) project(item *T, fields []string) *T {
+	var selected []*schema.Field
+	for _, name := range fields {
+		f := lookupField(m.sch, name)
+		if f == nil {
+			return memCopy(item)
+		}
+		selected = append(selected, f)
+	}
+	if len(selected) == 0 {
+		return memCopy(item)
+	}
+
+	out := new(T)
+	rv := reflect.ValueOf(out).Elem()
+	for _, f := range selected {
+		_ = f.Set(context.Background(), rv, m.value(f, item))
+	}
+	return out
+}
+
+func (m *MemRepo[T]) value(field *schema.Field, item *T) any {
+	v, _ := field.ValueOf(context.Background(), reflect.ValueOf(item).Elem())
+	return v
+}
+
+// table 返回表的存储，不存在时创建；调用方需持有 mu
+func (m *MemRepo[T]) table(tableName string) *memTable[T] {
+	name := memTableName(tableName)
+	t, ok := m.tables[name]
+	if !ok {
+		t = &memTable[T]{}
+		m.tables[name] = t
+	}
+	return t
+}
+
+// ready 每个操作开始前检查 schema 解析结果与 ctx
+func (m *MemRepo[T]) ready() errors.Error {
+	if m.schErr != nil {
+		return m.wrapErr(m.schErr)
+	}
+	if m.ctx != nil && m.ctx.Err() != nil {
+		return ClassifyError(m.ctx, m.ctx.Err())
+	}
+	return nil
+}
+
+func (m *MemRepo[T]) wrapErr(err error) errors.Error {
+	return errors.WithCause(errors.NewError(http.StatusInternalServerError, errors.NewMsg("MemRepo: %v", err)), err)
+}
+
+// memTableName 去掉别名与引号："orders o" -> "orders"
+func memTableName(tableName string) string {
+	parts := strings.Fields(tableName)
+	if len(parts) == 0 {
+		return ""
+	}
+	return strings.Trim(parts[0], "`\"")
+}
+
+func memIDs[T any](rows []*memRow[T]) []uint64 {
+	ids := make([]uint64, len(rows))
+	for i, r := range rows {
+		ids[i] = r.id
+	}
+	return ids
+}
+
+// memCopy 浅拷贝，避免调用方修改返回值影响存储
+func memCopy[T any](item *T) *T {
+	cp := *item
+	return &cp
+}
@@ -0,0 +1,438 @@
+package dal_test
+
+import (
+	"context"
+	stdErrors "errors"
+	"fmt"
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/xsda-pixel/common-infra/dal"
+	"github.com/xsda-pixel/common-infra/dal/daltest"
+	"github.com/xsda-pixel/common-infra/errors"
+
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+// memOrders 与 daltest 相同的 10 个订单：user_id = i%3+1，amount = i*100，status = i%2
+func memOrders() []*daltest.Order {
+	orders := make([]*daltest.Order, 0, 10)
+	for i := 1; i <= 10; i++ {
+		orders = append(orders, &daltest.Order{
+			UserID:  int64(i%3 + 1),
+			OrderNo: fmt.Sprintf("NO%03d", i),
+			Status:  i % 2,
+			Amount:  int64(i * 100),
+		})
+	}
+	return orders
+}
+
+func newMemOrders(t *testing.T) *dal.MemRepo[daltest.Order] {
+	t.Helper()
+	repo := dal.NewMemRepo[daltest.Order](nil)
+	if _, err := repo.CreateMany(nil, daltest.OrderTable, memOrders(), 4); err != nil {
+		t.Fatal(err)
+	}
+	return repo
+}
+
+// newSQLiteOrders 写入与 newMemOrders 相同数据的 SQLite 仓库，作为 MemRepo 语义的参照
+func newSQLiteOrders(t *testing.T) (*dal.RepoDB[daltest.Order], *gorm.DB) {
+	t.Helper()
+	db := openSQLite(t)
+	migrateOrders(t, db)
+	repo := dal.NewRepoDB[daltest.Order](dal.NewDB(db, nil))
+	if _, err := repo.CreateMany(db, daltest.OrderTable, memOrders(), 4); err != nil {
+		t.Fatal(err)
+	}
+	return repo, db
+}
+
+func orderIDs(list []*daltest.Order) []int64 {
+	ids := make([]int64, len(list))
+	for i, o := range list {
+		ids[i] = o.ID
+	}
+	return ids
+}
+
+// TestMemRepoWhere MemRepo 对 Eq / Cond / RawWhere 的求值与 SQLite 一致
+func TestMemRepoWhere(t *testing.T) {
+	mem := newMemOrders(t)
+	sql, _ := newSQLiteOrders(t)
+
+	cases := []struct {
+		name  string
+		where dal.WhereOption
+	}{
+		{"empty", dal.WhereOption{}},
+		{"eq", dal.WhereOption{Eq: map[string]any{"status": 1, "user_id": 2}}},
+		{"eq slice", dal.WhereOption{Eq: map[string]any{"user_id": []int64{1, 3}}}},
+		{"eq nil", dal.WhereOption{Eq: map[string]any{"deleted_at": nil}}},
+		{"cmp", dal.WhereOption{Cond: dal.And(dal.Gt("amount", 200), dal.Lte("amount", 700), dal.Ne("user_id", 3))}},
+		{"gte lt", dal.WhereOption{Cond: dal.And(dal.Gte("amount", 500), dal.Lt("id", 9))}},
+		{"in", dal.WhereOption{Cond: dal.In("order_no", []string{"NO002", "NO005", "NO404"})}},
+		{"in empty", dal.WhereOption{Cond: dal.In("id", []int64{})}},
+		{"not in", dal.WhereOption{Cond: dal.NotIn("user_id", []int{1})}},
+		{"not in empty", dal.WhereOption{Cond: dal.NotIn("id", []int64{})}},
+		{"between", dal.WhereOption{Cond: dal.Between("amount", 300, 600)}},
+		{"like", dal.WhereOption{Cond: dal.Like("order_no", "NO00_")}},
+		{"null", dal.WhereOption{Cond: dal.Or(dal.IsNull("deleted_at"), dal.NotNull("deleted_at"))}},
+		{"or", dal.WhereOption{Cond: dal.Or(dal.Eq("user_id", 1), dal.And(dal.Eq("status", 0), dal.Gt("amount", 800)))}},
+		{"not", dal.WhereOption{Cond: dal.Not(dal.Or(dal.Eq("status", 1), dal.Gte("amount", 800)))}},
+		{"and empty", dal.WhereOption{Cond: dal.And()}},
+		{"raw cmp", dal.WhereOption{Raw: &dal.RawWhere{SQL: "amount > ? AND status = ?", Args: []any{300, 0}}}},
+		{"raw in arg", dal.WhereOption{Raw: &dal.RawWhere{SQL: "user_id IN ?", Args: []any{[]int64{2, 3}}}}},
+		{"raw not in list", dal.WhereOption{Raw: &dal.RawWhere{SQL: "user_id NOT IN (1, 2)"}}},
+		{"raw between", dal.WhereOption{Raw: &dal.RawWhere{SQL: "amount NOT BETWEEN 200 AND 800"}}},
+		{"raw like", dal.WhereOption{Raw: &dal.RawWhere{SQL: "order_no LIKE 'NO01%' OR order_no = 'NO003'"}}},
+		{"raw arith", dal.WhereOption{Raw: &dal.RawWhere{SQL: "amount * 2 - 100 >= ? + 0", Args: []any{1100}}}},
+		{"raw precedence", dal.WhereOption{Raw: &dal.RawWhere{SQL: "NOT (status = 1 OR user_id <> 2) AND deleted_at IS NULL"}}},
+		{"raw const", dal.WhereOption{Raw: &dal.RawWhere{SQL: "TRUE AND id != 4"}}},
+		{"combined", dal.WhereOption{
+			Eq:   map[string]any{"status": 0},
+			Raw:  &dal.RawWhere{SQL: "amount < ?", Args: []any{900}},
+			Cond: dal.NotIn("user_id", []int64{2}),
+		}},
+	}
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			want, err := sql.FindMany(daltest.OrderTable, nil, tc.where, ptr("id"), nil)
+			if err != nil {
+				t.Fatal(err)
+			}
+			got, err := mem.FindMany(daltest.OrderTable, nil, tc.where, ptr("id"), nil)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if fmt.Sprint(orderIDs(got)) != fmt.Sprint(orderIDs(want)) {
+				t.Fatalf("MemRepo = %v, SQLite = %v", orderIDs(got), orderIDs(want))
+			}
+			n, err := mem.Count(daltest.OrderTable, tc.where)
+			if err != nil || n != int64(len(want)) {
+				t.Fatalf("Count = %d, %v; want %d", n, err, len(want))
+			}
+		})
+	}
+}
+
+func TestMemRepoUnsupportedWhere(t *testing.T) {
+	mem := newMemOrders(t)
+	for _, where := range []dal.WhereOption{
+		{Raw: &dal.RawWhere{SQL: "LENGTH(order_no) > 3"}},
+		{Raw: &dal.RawWhere{SQL: "id IN (SELECT id FROM orders)"}},
+		{Raw: &dal.RawWhere{SQL: "amount > @min", Args: []any{1}}},
+		{Raw: &dal.RawWhere{SQL: "amount > ?"}},
+		{Raw: &dal.RawWhere{SQL: "amount > 1 status = 0"}},
+		{Raw: &dal.RawWhere{SQL: "'unterminated = 1"}},
+		{Eq: map[string]any{"no_such_column": 1}},
+		{Cond: dal.Gt("no_such_column", 1)},
+	} {
+		_, err := mem.FindMany(daltest.OrderTable, nil, where, nil, nil)
+		if err == nil || err.ErrCode() != http.StatusBadRequest {
+			t.Fatalf("FindMany(%+v) error = %v, want 400", where, err)
+		}
+	}
+}
+
+// TestMemRepoOrderLimitPage 排序、limit、分页与 SQLite 一致
+func TestMemRepoOrderLimitPage(t *testing.T) {
+	mem := newMemOrders(t)
+	sql, _ := newSQLiteOrders(t)
+
+	for _, order := range []string{"id", "amount DESC", "user_id, amount DESC", "status desc, user_id ASC, id"} {
+		for _, limit := range []int{0, 3, 20} {
+			want, err := sql.FindMany(daltest.OrderTable, nil, dal.WhereOption{}, ptr(order), ptr(limit))
+			if err != nil {
+				t.Fatal(err)
+			}
+			got, err := mem.FindMany(daltest.OrderTable, nil, dal.WhereOption{}, ptr(order), ptr(limit))
+			if err != nil {
+				t.Fatal(err)
+			}
+			if fmt.Sprint(orderIDs(got)) != fmt.Sprint(orderIDs(want)) {
+				t.Fatalf("FindMany(%q, %d) = %v, SQLite = %v", order, limit, orderIDs(got), orderIDs(want))
+			}
+		}
+		for page := 0; page <= 5; page++ {
+			want, err := sql.FindPage(daltest.OrderTable, page, 3, nil, dal.WhereOption{}, ptr(order))
+			if err != nil {
+				t.Fatal(err)
+			}
+			got, err := mem.FindPage(daltest.OrderTable, page, 3, nil, dal.WhereOption{}, ptr(order))
+			if err != nil {
+				t.Fatal(err)
+			}
+			if fmt.Sprint(orderIDs(got)) != fmt.Sprint(orderIDs(want)) {
+				t.Fatalf("FindPage(%q, %d) = %v, SQLite = %v", order, page, orderIDs(got), orderIDs(want))
+			}
+		}
+	}
+
+	// fields 只回填选中的列
+	o, err := mem.FindOne(daltest.OrderTable, []string{"id", "order_no"}, dal.WhereOption{Eq: map[string]any{"id": 3}})
+	if err != nil || o == nil || o.OrderNo != "NO003" || o.Amount != 0 {
+		t.Fatalf("FindOne with fields = %+v, %v", o, err)
+	}
+	for _, order := range []string{"no_such_column", "id DESC NULLS", "id SIDEWAYS"} {
+		if _, err := mem.FindMany(daltest.OrderTable, nil, dal.WhereOption{}, ptr(order), nil); err == nil || err.ErrCode() != http.StatusBadRequest {
+			t.Fatalf("FindMany order %q error = %v, want 400", order, err)
+		}
+	}
+}
+
+// TestMemRepoWrites Update / Delete 的命中行数与结果与 SQLite 一致
+func TestMemRepoWrites(t *testing.T) {
+	mem := newMemOrders(t)
+	sql, db := newSQLiteOrders(t)
+
+	steps := []func(w dal.WriteRepo[daltest.Order], db *gorm.DB) (int64, errors.Error){
+		func(w dal.WriteRepo[daltest.Order], db *gorm.DB) (int64, errors.Error) {
+			return w.Update(db, daltest.OrderTable, dal.WhereOption{Cond: dal.Eq("user_id", 1)}, map[string]any{"status": 7})
+		},
+		func(w dal.WriteRepo[daltest.Order], db *gorm.DB) (int64, errors.Error) {
+			return w.Update(db, daltest.OrderTable, dal.WhereOption{Eq: map[string]any{"status": 7}}, map[string]any{"amount": clause.Expr{SQL: "amount + ?", Vars: []any{5}}})
+		},
+		func(w dal.WriteRepo[daltest.Order], db *gorm.DB) (int64, errors.Error) {
+			return w.Delete(db, daltest.OrderTable, dal.WhereOption{Raw: &dal.RawWhere{SQL: "amount > ?", Args: []any{800}}})
+		},
+	}
+	for i, step := range steps {
+		want, err := step(sql, db)
+		if err != nil {
+			t.Fatal(err)
+		}
+		got, err := step(mem, nil)
+		if err != nil || got != want {
+			t.Fatalf("step %d = %d, %v; SQLite = %d", i, got, err, want)
+		}
+	}
+
+	// 与 MySQL 一样只计值有变化的行（SQLite 计命中行，不参与比较）
+	if n, err := mem.Update(nil, daltest.OrderTable, dal.WhereOption{Cond: dal.Gte("amount", 0)}, map[string]any{"status": 7}); err != nil || n != 6 {
+		t.Fatalf("Update unchanged rows = %d, %v; want 6", n, err)
+	}
+	if _, err := sql.Update(db, daltest.OrderTable, dal.WhereOption{Cond: dal.Gte("amount", 0)}, map[string]any{"status": 7}); err != nil {
+		t.Fatal(err)
+	}
+
+	want, _ := sql.FindMany(daltest.OrderTable, []string{"id", "status", "amount"}, dal.WhereOption{}, ptr("id"), nil)
+	got, _ := mem.FindMany(daltest.OrderTable, []string{"id", "status", "amount"}, dal.WhereOption{}, ptr("id"), nil)
+	if fmt.Sprint(ordersState(got)) != fmt.Sprint(ordersState(want)) {
+		t.Fatalf("MemRepo rows = %v, SQLite = %v", ordersState(got), ordersState(want))
+	}
+}
+
+func ordersState(list []*daltest.Order) []string {
+	out := make([]string, len(list))
+	for i, o := range list {
+		out[i] = fmt.Sprintf("%d:%d:%d", o.ID, o.Status, o.Amount)
+	}
+	return out
+}
+
+func TestMemRepoDuplicateKey(t *testing.T) {
+	mem := newMemOrders(t)
+
+	for name, item := range map[string]*daltest.Order{
+		"primary key":  {ID: 3, OrderNo: "NEW001"},
+		"unique index": {OrderNo: "NO004"},
+	} {
+		if err := mem.CreateOne(nil, daltest.OrderTable, item); !stdErrors.Is(err, dal.ErrDuplicateKey) {
+			t.Fatalf("CreateOne with duplicate %s error = %v, want ErrDuplicateKey", name, err)
+		}
+	}
+
+	// 批内冲突（含与同批的其他行冲突）时整批不插入，之前的批次保留
+	counts, err := mem.CreateMany(nil, daltest.OrderTable, []*daltest.Order{
+		{OrderNo: "NEW001"}, {OrderNo: "NEW002"},
+		{OrderNo: "NEW003"}, {OrderNo: "NEW003"},
+	}, 2)
+	if !stdErrors.Is(err, dal.ErrDuplicateKey) || fmt.Sprint(counts) != "[2]" {
+		t.Fatalf("CreateMany = %v, %v; want [2], ErrDuplicateKey", counts, err)
+	}
+	if n, _ := mem.Count(daltest.OrderTable, dal.WhereOption{Cond: dal.Like("order_no", "NEW%")}); n != 2 {
+		t.Fatalf("rows after partial CreateMany = %d, want 2", n)
+	}
+
+	// 自增主键从当前最大值继续
+	o := &daltest.Order{OrderNo: "NEW004"}
+	if err := mem.CreateOne(nil, daltest.OrderTable, o); err != nil || o.ID != 13 {
+		t.Fatalf("CreateOne id = %d, %v; want 13", o.ID, err)
+	}
+}
+
+func TestMemRepoForUpdateBlocks(t *testing.T) {
+	mem := newMemOrders(t)
+	db := mem.DB()
+	byID := func(id int64) dal.WhereOption { return dal.WhereOption{Eq: map[string]any{"id": id}} }
+
+	locked, release := make(chan struct{}), make(chan struct{})
+	holder := make(chan error, 1)
+	go func() {
+		holder <- db.Transaction(func(tx *gorm.DB) error {
+			if _, err := mem.FindOneForUpdate(tx, daltest.OrderTable, nil, byID(1)); err != nil {
+				return err
+			}
+			close(locked)
+			<-release
+			_, err := mem.Update(tx, daltest.OrderTable, byID(1), map[string]any{"amount": 1})
+			return err
+		})
+	}()
+	<-locked
+
+	// 其他行不受影响
+	if err := db.Transaction(func(tx *gorm.DB) error {
+		_, err := mem.FindOneForUpdate(tx, daltest.OrderTable, nil, byID(2))
+		return err
+	}); err != nil {
+		t.Fatalf("lock another row: %v", err)
+	}
+
+	waiter := make(chan *daltest.Order, 1)
+	go func() {
+		_ = db.Transaction(func(tx *gorm.DB) error {
+			o, err := mem.FindOneForUpdate(tx, daltest.OrderTable, nil, byID(1))
+			if err != nil {
+				t.Error(err)
+			}
+			waiter <- o
+			return nil
+		})
+	}()
+	select {
+	case <-waiter:
+		t.Fatal("FindOneForUpdate did not wait for the row lock")
+	case <-time.After(100 * time.Millisecond):
+	}
+
+	close(release)
+	if err := <-holder; err != nil {
+		t.Fatal(err)
+	}
+	select {
+	case o := <-waiter:
+		if o == nil || o.Amount != 1 {
+			t.Fatalf("FindOneForUpdate after release = %+v, want amount 1", o)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("FindOneForUpdate still blocked after the holder committed")
+	}
+}
+
+func TestMemRepoLockWaitTimeout(t *testing.T) {
+	mem := newMemOrders(t)
+	db := mem.DB()
+	db.LockWait = 50 * time.Millisecond
+	byID := dal.WhereOption{Eq: map[string]any{"id": 1}}
+
+	err := db.Transaction(func(tx *gorm.DB) error {
+		if _, err := mem.FindOneForUpdate(tx, daltest.OrderTable, nil, byID); err != nil {
+			return err
+		}
+		errc := make(chan error, 2)
+		go func() {
+			errc <- db.Transaction(func(other *gorm.DB) error {
+				_, err := mem.FindOneForUpdate(other, daltest.OrderTable, nil, byID)
+				return err
+			})
+		}()
+		go func() {
+			// 自动提交的写入同样等待行锁
+			if _, err := mem.Update(nil, daltest.OrderTable, byID, map[string]any{"status": 9}); err != nil {
+				errc <- err
+				return
+			}
+			errc <- nil
+		}()
+		for i := 0; i < 2; i++ {
+			if err := <-errc; !stdErrors.Is(err, dal.ErrLockWaitTimeout) {
+				t.Errorf("blocked operation error = %v, want ErrLockWaitTimeout", err)
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestMemDBTransactionRollback(t *testing.T) {
+	mem := newMemOrders(t)
+	users := dal.NewMemRepo[daltest.User](mem.DB())
+	before := fmt.Sprint(ordersState(mem.All(daltest.OrderTable)))
+
+	write := func(tx *gorm.DB) {
+		if err := mem.CreateOne(tx, daltest.OrderTable, &daltest.Order{OrderNo: "NEW001", Amount: 1}); err != nil {
+			t.Fatal(err)
+		}
+		if _, err := mem.Update(tx, daltest.OrderTable, dal.WhereOption{Cond: dal.Lte("id", 5)}, map[string]any{"amount": 0}); err != nil {
+			t.Fatal(err)
+		}
+		if _, err := mem.Delete(tx, daltest.OrderTable, dal.WhereOption{Cond: dal.In("id", []int64{2, 7})}); err != nil {
+			t.Fatal(err)
+		}
+		if err := users.CreateOne(tx, daltest.UserTable, &daltest.User{ID: 1, Name: "u1"}); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	boom := stdErrors.New("boom")
+	if err := mem.DB().Transaction(func(tx *gorm.DB) error {
+		write(tx)
+		return boom
+	}); !stdErrors.Is(err, boom) {
+		t.Fatalf("Transaction error = %v, want boom", err)
+	}
+	if after := fmt.Sprint(ordersState(mem.All(daltest.OrderTable))); after != before {
+		t.Fatalf("rows after rollback = %s, want %s", after, before)
+	}
+	if n := len(users.All(daltest.UserTable)); n != 0 {
+		t.Fatalf("users after rollback = %d, want 0", n)
+	}
+
+	func() {
+		defer func() {
+			if r := recover(); r != "boom" {
+				t.Fatalf("recovered %v, want boom", r)
+			}
+		}()
+		_ = mem.DB().Transaction(func(tx *gorm.DB) error {
+			write(tx)
+			panic("boom")
+		})
+	}()
+	if after := fmt.Sprint(ordersState(mem.All(daltest.OrderTable))); after != before {
+		t.Fatalf("rows after panic = %s, want %s", after, before)
+	}
+
+	// 提交后保留写入，回滚过的自增值不影响后续插入
+	if err := mem.DB().Transaction(func(tx *gorm.DB) error {
+		write(tx)
+		return nil
+	}); err != nil {
+		t.Fatal(err)
+	}
+	if n, _ := mem.Count(daltest.OrderTable, dal.WhereOption{}); n != 9 {
+		t.Fatalf("rows after commit = %d, want 9", n)
+	}
+	if n := len(users.All(daltest.UserTable)); n != 1 {
+		t.Fatalf("users after commit = %d, want 1", n)
+	}
+}
+
+func TestMemRepoContext(t *testing.T) {
+	mem := newMemOrders(t)
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	if _, err := mem.WithContext(ctx).FindMany(daltest.OrderTable, nil, dal.WhereOption{}, nil, nil); !stdErrors.Is(err, dal.ErrQueryCanceled) {
+		t.Fatalf("FindMany with canceled ctx error = %v, want ErrQueryCanceled", err)
+	}
+	if _, err := mem.FindMany(daltest.OrderTable, nil, dal.WhereOption{}, nil, nil); err != nil {
+		t.Fatalf("original repo affected by WithContext: %v", err)
+	}
+}
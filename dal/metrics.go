@@ -0,0 +1,345 @@
+package dal
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"math"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	stdErrors "errors"
+
+	"github.com/xsda-pixel/common-infra/logs"
+
+	"gorm.io/gorm"
+)
+
+const (
+	metricsPluginName    = "dal:metrics"
+	metricsStartKey      = "dal:metrics_start"
+	defaultSlowThreshold = 200 * time.Millisecond
+)
+
+// 默认延迟分桶（秒）
+var defaultMetricBuckets = []float64{0.005, 0.01, 0.025, 0.05, 0.1, 0.25, 0.5, 1, 2.5, 5}
+
+// MetricsConfig 查询指标与慢查询日志配置
+type MetricsConfig struct {
+	SlowThreshold time.Duration // 慢查询阈值，默认 200ms，< 0 表示不记录慢查询
+	Buckets       []float64     // 延迟直方图分桶上限（秒，升序），默认 5ms .. 5s
+	SlowWriter    io.Writer     // 慢查询日志输出，默认 logs.DbWriter（需 logs.Init 时 isDb 为 true）
+}
+
+// Metrics 按表 + 操作统计延迟、行数与错误的 gorm 插件，可通过 WithMetrics 注册到 DBS，
+// 或直接 db.Use(metrics) 注册到任意 *gorm.DB。ServeHTTP 以 Prometheus 文本格式输出。
+type Metrics struct {
+	cfg    MetricsConfig
+	mu     sync.Mutex
+	series map[metricKey]*metricSeries
+	slowMu sync.Mutex
+}
+
+type metricKey struct {
+	table string
+	op    string
+}
+
+type metricSeries struct {
+	count   uint64
+	errors  uint64
+	rows    uint64
+	sum     float64
+	buckets []uint64 // 非累计，与 cfg.Buckets 一一对应，最后一个为 +Inf
+}
+
+// MetricSample 某表某操作的指标快照
+type MetricSample struct {
+	Table   string
+	Op      string // create / query / update / delete / row / raw
+	Count   uint64
+	Errors  uint64 // 不含 gorm.ErrRecordNotFound
+	Rows    uint64 // RowsAffected 累计
+	Sum     time.Duration
+	Buckets []MetricBucket // 累计计数，最后一个上限为 +Inf
+}
+
+// MetricBucket 直方图分桶：耗时 <= UpperBound 秒的次数
+type MetricBucket struct {
+	UpperBound float64
+	Count      uint64
+}
+
+// slowQuery 慢查询日志，SQL 中保留占位符，只记录参数个数，避免敏感数据落盘
+type slowQuery struct {
+	Time       time.Time `json:"time"`
+	Table      string    `json:"table"`
+	Op         string    `json:"op"`
+	DurationMS float64   `json:"duration_ms"`
+	Rows       int64     `json:"rows"`
+	SQL        string    `json:"sql"`
+	Vars       int       `json:"vars"`
+	Error      string    `json:"error,omitempty"`
+}
+
+func NewMetrics(cfg MetricsConfig) *Metrics {
+	if cfg.SlowThreshold == 0 {
+		cfg.SlowThreshold = defaultSlowThreshold
+	}
+	if len(cfg.Buckets) == 0 {
+		cfg.Buckets = defaultMetricBuckets
+	}
+	return &Metrics{cfg: cfg, series: make(map[metricKey]*metricSeries)}
+}
+
+// WithMetrics 将 m 注册到主库与全部从库
+func WithMetrics(m *Metrics) func(*DBS) {
+	return func(d *DBS) {
+		d.metrics = m
+	}
+}
+
+// Metrics 返回通过 WithMetrics 注册的指标，未注册时为 nil
+func (d *DBS) Metrics() *Metrics {
+	return d.metrics
+}
+
+// useMetrics 在 NewDB 应用完全部选项后执行，保证从库无论选项顺序都能注册
+func (d *DBS) useMetrics() {
+	if d.metrics == nil {
+		return
+	}
+	conns := []*gorm.DB{d.MySQL}
+	for _, r := range d.replicas {
+		conns = append(conns, r.db)
+	}
+	for _, conn := range conns {
+		if conn == nil {
+			continue
+		}
+		if err := conn.Use(d.metrics); err != nil && !stdErrors.Is(err, gorm.ErrRegistered) {
+			logs.Logger.Error(err)
+		}
+	}
+}
+
+func (m *Metrics) Name() string {
+	return metricsPluginName
+}
+
+// Initialize 实现 gorm.Plugin，为各类操作注册前后回调
+func (m *Metrics) Initialize(db *gorm.DB) error {
+	cb := db.Callback()
+	return stdErrors.Join(
+		cb.Create().Before("gorm:create").Register("dal:metrics_before_create", m.before),
+		cb.Create().After("gorm:after_create").Register("dal:metrics_after_create", m.after("create")),
+		cb.Query().Before("gorm:query").Register("dal:metrics_before_query", m.before),
+		cb.Query().After("gorm:after_query").Register("dal:metrics_after_query", m.after("query")),
+		cb.Update().Before("gorm:update").Register("dal:metrics_before_update", m.before),
+		cb.Update().After("gorm:after_update").Register("dal:metrics_after_update", m.after("update")),
+		cb.Delete().Before("gorm:delete").Register("dal:metrics_before_delete", m.before),
+		cb.Delete().After("gorm:after_delete").Register("dal:metrics_after_delete", m.after("delete")),
+		cb.Row().Before("gorm:row").Register("dal:metrics_before_row", m.before),
+		cb.Row().After("gorm:row").Register("dal:metrics_after_row", m.after("row")),
+		cb.Raw().Before("gorm:raw").Register("dal:metrics_before_raw", m.before),
+		cb.Raw().After("gorm:raw").Register("dal:metrics_after_raw", m.after("raw")),
+	)
+}
+
+func (m *Metrics) before(db *gorm.DB) {
+	db.InstanceSet(metricsStartKey, time.Now())
+}
+
+func (m *Metrics) after(op string) func(*gorm.DB) {
+	return func(db *gorm.DB) {
+		// dry-run 语句（缓存 key、总数估算）只生成 SQL 未执行，不计入
+		if db.DryRun {
+			return
+		}
+		v, ok := db.InstanceGet(metricsStartKey)
+		if !ok {
+			return
+		}
+		start, ok := v.(time.Time)
+		if !ok {
+			return
+		}
+		elapsed := time.Since(start)
+
+		table := db.Statement.Table
+		if table == "" && db.Statement.Schema != nil {
+			table = db.Statement.Schema.Table
+		}
+		table = cacheTable(table)
+		if table == "" {
+			table = "unknown"
+		}
+
+		failed := db.Error != nil && !stdErrors.Is(db.Error, gorm.ErrRecordNotFound)
+		m.observe(metricKey{table: table, op: op}, elapsed, db.RowsAffected, failed)
+
+		if m.cfg.SlowThreshold > 0 && elapsed >= m.cfg.SlowThreshold {
+			m.logSlow(db, table, op, elapsed)
+		}
+	}
+}
+
+func (m *Metrics) observe(key metricKey, elapsed time.Duration, rows int64, failed bool) {
+	sec := elapsed.Seconds()
+	idx := sort.SearchFloat64s(m.cfg.Buckets, sec) // 第一个 >= sec 的上限，超出全部上限时落在 +Inf
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	s, ok := m.series[key]
+	if !ok {
+		s = &metricSeries{buckets: make([]uint64, len(m.cfg.Buckets)+1)}
+		m.series[key] = s
+	}
+	s.count++
+	s.sum += sec
+	s.buckets[idx]++
+	if rows > 0 {
+		s.rows += uint64(rows)
+	}
+	if failed {
+		s.errors++
+	}
+}
+
+func (m *Metrics) logSlow(db *gorm.DB, table, op string, elapsed time.Duration) {
+	w := m.cfg.SlowWriter
+	if w == nil {
+		if logs.DbWriter == nil {
+			return
+		}
+		w = logs.DbWriter
+	}
+
+	entry := slowQuery{
+		Time:       time.Now(),
+		Table:      table,
+		Op:         op,
+		DurationMS: float64(elapsed.Microseconds()) / 1000,
+		Rows:       db.RowsAffected,
+		SQL:        db.Statement.SQL.String(),
+		Vars:       len(db.Statement.Vars),
+	}
+	if db.Error != nil {
+		entry.Error = db.Error.Error()
+	}
+
+	line, err := json.Marshal(entry)
+	if err != nil {
+		return
+	}
+	line = append(line, '\n')
+
+	m.slowMu.Lock()
+	defer m.slowMu.Unlock()
+	if _, err := w.Write(line); err != nil {
+		logs.Logger.Warn(err)
+	}
+}
+
+// Snapshot 返回当前全部指标，按表、操作排序
+func (m *Metrics) Snapshot() []MetricSample {
+	m.mu.Lock()
+	samples := make([]MetricSample, 0, len(m.series))
+	for key, s := range m.series {
+		sample := MetricSample{
+			Table:   key.table,
+			Op:      key.op,
+			Count:   s.count,
+			Errors:  s.errors,
+			Rows:    s.rows,
+			Sum:     time.Duration(s.sum * float64(time.Second)),
+			Buckets: make([]MetricBucket, len(s.buckets)),
+		}
+		var cum uint64
+		for i, n := range s.buckets {
+			cum += n
+			bound := math.Inf(1)
+			if i < len(m.cfg.Buckets) {
+				bound = m.cfg.Buckets[i]
+			}
+			sample.Buckets[i] = MetricBucket{UpperBound: bound, Count: cum}
+		}
+		samples = append(samples, sample)
+	}
+	m.mu.Unlock()
+
+	sort.Slice(samples, func(i, j int) bool {
+		if samples[i].Table != samples[j].Table {
+			return samples[i].Table < samples[j].Table
+		}
+		return samples[i].Op < samples[j].Op
+	})
+	return samples
+}
+
+// Reset 清空全部指标
+func (m *Metrics) Reset() {
+	m.mu.Lock()
+	m.series = make(map[metricKey]*metricSeries)
+	m.mu.Unlock()
+}
+
+// WritePrometheus 以 Prometheus 文本格式输出：
+// dal_query_duration_seconds（histogram）、dal_query_errors_total、dal_query_rows_total
+func (m *Metrics) WritePrometheus(w io.Writer) error {
+	samples := m.Snapshot()
+	var b strings.Builder
+
+	b.WriteString("# HELP dal_query_duration_seconds DB query latency by table and operation.\n")
+	b.WriteString("# TYPE dal_query_duration_seconds histogram\n")
+	for _, s := range samples {
+		labels := promLabels(s.Table, s.Op)
+		for _, bucket := range s.Buckets {
+			le := "+Inf"
+			if !math.IsInf(bucket.UpperBound, 1) {
+				le = strconv.FormatFloat(bucket.UpperBound, 'g', -1, 64)
+			}
+			fmt.Fprintf(&b, "dal_query_duration_seconds_bucket{%s,le=%q} %d\n", labels, le, bucket.Count)
+		}
+		fmt.Fprintf(&b, "dal_query_duration_seconds_sum{%s} %s\n", labels, strconv.FormatFloat(s.Sum.Seconds(), 'g', -1, 64))
+		fmt.Fprintf(&b, "dal_query_duration_seconds_count{%s} %d\n", labels, s.Count)
+	}
+
+	b.WriteString("# HELP dal_query_errors_total DB query errors by table and operation.\n")
+	b.WriteString("# TYPE dal_query_errors_total counter\n")
+	for _, s := range samples {
+		fmt.Fprintf(&b, "dal_query_errors_total{%s} %d\n", promLabels(s.Table, s.Op), s.Errors)
+	}
+
+	b.WriteString("# HELP dal_query_rows_total Rows affected or returned by table and operation.\n")
+	b.WriteString("# TYPE dal_query_rows_total counter\n")
+	for _, s := range samples {
+		fmt.Fprintf(&b, "dal_query_rows_total{%s} %d\n", promLabels(s.Table, s.Op), s.Rows)
+	}
+
+	_, err := io.WriteString(w, b.String())
+	return err
+}
+
+// ServeHTTP 实现 http.Handler，可直接挂到 /metrics
+func (m *Metrics) ServeHTTP(w http.ResponseWriter, _ *http.Request) {
+	w.Header().Set("Content-Type", "text/plain; version=0.0.4; charset=utf-8")
+	if err := m.WritePrometheus(w); err != nil {
+		logs.Logger.Warn(err)
+	}
+}
+
+func promLabels(table, op string) string {
+	return `table="` + promEscape(table) + `",op="` + promEscape(op) + `"`
+}
+
+var promEscaper = strings.NewReplacer(`\`, `\\`, `"`, `\"`, "\n", `\n`)
+
+func promEscape(s string) string {
+	return promEscaper.Replace(s)
+}
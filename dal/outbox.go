@@ -0,0 +1,425 @@
+package dal
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/xsda-pixel/common-infra/errors"
+	"github.com/xsda-pixel/common-infra/logs"
+	"github.com/xsda-pixel/common-infra/stream"
+
+	rds "github.com/redis/go-redis/v9"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+const (
+	defaultOutboxTable       = "outbox"
+	defaultOutboxBatchSize   = 100
+	defaultOutboxPoll        = time.Second
+	defaultOutboxConcurrency = 4
+	defaultOutboxLease       = 30 * time.Second
+	defaultOutboxMaxAttempts = 16
+	defaultOutboxBaseDelay   = time.Second
+	defaultOutboxMaxDelay    = 5 * time.Minute
+	outboxMaxErrorLen        = 512
+)
+
+// outbox 行状态
+const (
+	OutboxPending = "pending" // 待发布（含失败待重试）
+	OutboxSent    = "sent"    // 已写入 Redis Stream
+	OutboxDead    = "dead"    // 超过最大重试次数，不再发布
+)
+
+var ErrOutboxNoRedis = errors.NewError(http.StatusInternalServerError, errors.NewMsg("outbox relay: redis client is nil"))
+
+// OutboxConfig 发件箱与投递器配置
+type OutboxConfig struct {
+	Table        string        // 发件箱表，默认 outbox
+	BatchSize    int           // 每次认领的行数，默认 100
+	PollInterval time.Duration // 没有待发布行时的轮询间隔，默认 1s
+	Concurrency  int           // 同一批内并发发布的协程数，默认 4；需要同一 Key 严格有序时设为 1
+	Lease        time.Duration // 认领后的租约，投递器崩溃时租约到期由其他实例重新认领，默认 30s
+	MaxAttempts  int           // 最大发布次数，超过后标记为 dead，默认 16，< 0 表示不限
+	BaseDelay    time.Duration // 失败重试的初始退避，按次数翻倍，默认 1s
+	MaxDelay     time.Duration // 失败重试的最大退避，默认 5m
+	MaxLen       int64         // XADD 的近似 MAXLEN，<= 0 表示不裁剪
+}
+
+// OutboxEvent 待发布的事件
+type OutboxEvent struct {
+	Stream  string // 目标 Redis Stream
+	Key     string // 业务键（如订单号），供消费方去重、路由
+	Type    string // 事件类型
+	Payload any    // string / []byte 原样写入，其余类型序列化为 JSON
+}
+
+// OutboxMessage 发件箱中的一行，默认表结构：
+//
+//	CREATE TABLE outbox (
+//	  id BIGINT PRIMARY KEY AUTO_INCREMENT,
+//	  stream VARCHAR(128) NOT NULL, event_key VARCHAR(128) NOT NULL, event_type VARCHAR(64) NOT NULL,
+//	  payload MEDIUMTEXT NOT NULL, status VARCHAR(16) NOT NULL, attempts INT NOT NULL DEFAULT 0,
+//	  next_retry_at DATETIME(3) NOT NULL, last_error VARCHAR(512) NOT NULL DEFAULT '',
+//	  created_at DATETIME(3) NOT NULL, sent_at DATETIME(3) NULL,
+//	  KEY idx_status_retry (status, next_retry_at, id)
+//	);
+type OutboxMessage struct {
+	ID          int64      `json:"id"`
+	Stream      string     `json:"stream"`
+	EventKey    string     `json:"event_key"`
+	EventType   string     `json:"event_type"`
+	Payload     string     `json:"payload"`
+	Status      string     `json:"status"`
+	Attempts    int        `json:"attempts"` // 已认领（尝试发布）的次数
+	NextRetryAt time.Time  `json:"next_retry_at"`
+	LastError   string     `json:"last_error"`
+	CreatedAt   time.Time  `json:"created_at"`
+	SentAt      *time.Time `json:"sent_at"`
+}
+
+// Outbox 事务性发件箱：Publish 在业务写入所在的事务中插入事件行，Run 启动的投递器认领行并发布到 DBS.RDS 的 Redis Stream。
+// 投递为至少一次：发布成功但标记失败、或租约到期后会重复发布，消费方需按 outbox_id 或业务键去重。
+type Outbox struct {
+	dbs *DBS
+	cfg OutboxConfig
+}
+
+func NewOutbox(dbs *DBS, opts ...func(*OutboxConfig)) *Outbox {
+	cfg := OutboxConfig{
+		Table:        defaultOutboxTable,
+		BatchSize:    defaultOutboxBatchSize,
+		PollInterval: defaultOutboxPoll,
+		Concurrency:  defaultOutboxConcurrency,
+		Lease:        defaultOutboxLease,
+		MaxAttempts:  defaultOutboxMaxAttempts,
+		BaseDelay:    defaultOutboxBaseDelay,
+		MaxDelay:     defaultOutboxMaxDelay,
+	}
+	for _, opt := range opts {
+		opt(&cfg)
+	}
+	return &Outbox{dbs: dbs, cfg: cfg}
+}
+
+func WithOutboxTable(table string) func(*OutboxConfig) {
+	return func(c *OutboxConfig) {
+		if table != "" {
+			c.Table = table
+		}
+	}
+}
+
+// WithOutboxBatch 配置每次认领的行数与空闲时的轮询间隔
+func WithOutboxBatch(size int, pollInterval time.Duration) func(*OutboxConfig) {
+	return func(c *OutboxConfig) {
+		if size > 0 {
+			c.BatchSize = size
+		}
+		if pollInterval > 0 {
+			c.PollInterval = pollInterval
+		}
+	}
+}
+
+func WithOutboxConcurrency(n int) func(*OutboxConfig) {
+	return func(c *OutboxConfig) {
+		if n > 0 {
+			c.Concurrency = n
+		}
+	}
+}
+
+// WithOutboxLease 配置认领租约，应明显大于一次发布的耗时
+func WithOutboxLease(lease time.Duration) func(*OutboxConfig) {
+	return func(c *OutboxConfig) {
+		if lease > 0 {
+			c.Lease = lease
+		}
+	}
+}
+
+// WithOutboxRetry 配置最大发布次数与退避，maxAttempts < 0 表示不限
+func WithOutboxRetry(maxAttempts int, baseDelay, maxDelay time.Duration) func(*OutboxConfig) {
+	return func(c *OutboxConfig) {
+		if maxAttempts != 0 {
+			c.MaxAttempts = maxAttempts
+		}
+		if baseDelay > 0 {
+			c.BaseDelay = baseDelay
+		}
+		if maxDelay > 0 {
+			c.MaxDelay = maxDelay
+		}
+	}
+}
+
+// WithOutboxMaxLen 配置 XADD MAXLEN ~ n
+func WithOutboxMaxLen(n int64) func(*OutboxConfig) {
+	return func(c *OutboxConfig) {
+		c.MaxLen = n
+	}
+}
+
+// Publish 在 tx 中插入事件行。tx 应为业务写入所在的事务（DBS.WithTx 或 gorm Transaction 的 tx），
+// 事务回滚时事件一并丢弃；传入非事务连接时事件与业务写入之间没有原子性。
+func (o *Outbox) Publish(tx *gorm.DB, events ...OutboxEvent) errors.Error {
+	if len(events) == 0 {
+		return nil
+	}
+
+	now := time.Now()
+	rows := make([]*OutboxMessage, 0, len(events))
+	for _, evt := range events {
+		if evt.Stream == "" {
+			return errors.NewError(http.StatusBadRequest, errors.NewMsg("outbox: stream is empty"))
+		}
+		payload, err := outboxPayload(evt.Payload)
+		if err != nil {
+			return errors.NewError(http.StatusBadRequest, errors.NewMsg("outbox: encode payload: %v", err))
+		}
+		rows = append(rows, &OutboxMessage{
+			Stream:      evt.Stream,
+			EventKey:    evt.Key,
+			EventType:   evt.Type,
+			Payload:     payload,
+			Status:      OutboxPending,
+			NextRetryAt: now,
+			CreatedAt:   now,
+		})
+	}
+
+	if err := tx.Table(o.cfg.Table).Create(rows).Error; err != nil {
+		return o.wrapErr(tx.Statement.Context, err)
+	}
+	return nil
+}
+
+// Run 启动投递器，阻塞直到 ctx 结束。可在多个实例上同时运行，认领时使用 FOR UPDATE SKIP LOCKED（MySQL 8.0+ / PostgreSQL）互不阻塞
+func (o *Outbox) Run(ctx context.Context) {
+	timer := time.NewTimer(0)
+	defer timer.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-timer.C:
+		}
+
+		n, err := o.RunOnce(ctx)
+		if err != nil && ctx.Err() == nil {
+			logs.Logger.Error(err)
+		}
+
+		// 认领满一批说明可能还有积压，立即继续
+		wait := o.cfg.PollInterval
+		if err == nil && n >= o.cfg.BatchSize {
+			wait = 0
+		}
+		timer.Reset(wait)
+	}
+}
+
+// RunOnce 认领一批到期的行并发布，返回认领的行数；单行发布失败不作为错误返回，而是按退避安排重试
+func (o *Outbox) RunOnce(ctx context.Context) (int, errors.Error) {
+	if o.dbs.RDS == nil {
+		return 0, ErrOutboxNoRedis
+	}
+
+	msgs, err := o.claim(ctx)
+	if err != nil || len(msgs) == 0 {
+		return 0, err
+	}
+
+	ch := make(chan *OutboxMessage, len(msgs))
+	for _, m := range msgs {
+		ch <- m
+	}
+	close(ch)
+	stream.NewStreamWorker(o.cfg.Concurrency, o.deliver).Start(ctx, ch)
+
+	return len(msgs), nil
+}
+
+// Purge 删除 before 之前发送成功的行，limit <= 0 时不限行数
+func (o *Outbox) Purge(ctx context.Context, before time.Time, limit int) (int64, errors.Error) {
+	conn := o.dbs.MySQL.WithContext(ctx)
+	rs := conn.Table(o.cfg.Table).
+		Where(clause.Eq{Column: clause.Column{Name: "status"}, Value: OutboxSent}).
+		Where(clause.Lt{Column: clause.Column{Name: "sent_at"}, Value: before})
+
+	// gorm 的 DELETE 不生成 LIMIT，先按 id 取出一批再删除
+	if limit > 0 {
+		var ids []int64
+		if err := rs.Order("id").Limit(limit).Pluck("id", &ids).Error; err != nil {
+			return 0, o.wrapErr(ctx, err)
+		}
+		if len(ids) == 0 {
+			return 0, nil
+		}
+		rs = conn.Table(o.cfg.Table).Where(clause.IN{Column: clause.Column{Name: "id"}, Values: outboxIDs(ids)})
+	}
+
+	rs = rs.Delete(&OutboxMessage{})
+	if rs.Error != nil {
+		return 0, o.wrapErr(ctx, rs.Error)
+	}
+	return rs.RowsAffected, nil
+}
+
+// claim 锁定一批到期的待发布行，将 next_retry_at 推迟一个租约并累加 attempts 后提交；
+// 租约内其他投递器不会再认领，投递器崩溃时租约到期自动重新投递
+func (o *Outbox) claim(ctx context.Context) ([]*OutboxMessage, errors.Error) {
+	now := time.Now()
+	var msgs []*OutboxMessage
+	err := o.dbs.MySQL.WithContext(ctx).Transaction(func(tx *gorm.DB) error {
+		rs := tx.Table(o.cfg.Table).
+			Where(clause.Eq{Column: clause.Column{Name: "status"}, Value: OutboxPending}).
+			Where(clause.Lte{Column: clause.Column{Name: "next_retry_at"}, Value: now}).
+			Order("id").
+			Limit(o.cfg.BatchSize)
+		err := forUpdate(rs, "SKIP LOCKED").Find(&msgs).Error
+		if err != nil || len(msgs) == 0 {
+			return err
+		}
+
+		ids := make([]int64, 0, len(msgs))
+		for _, m := range msgs {
+			ids = append(ids, m.ID)
+		}
+		return tx.Table(o.cfg.Table).
+			Where(clause.IN{Column: clause.Column{Name: "id"}, Values: outboxIDs(ids)}).
+			Updates(map[string]any{
+				"attempts":      gorm.Expr("attempts + 1"),
+				"next_retry_at": now.Add(o.cfg.Lease),
+			}).Error
+	})
+	if err != nil {
+		return nil, o.wrapErr(ctx, err)
+	}
+
+	for _, m := range msgs {
+		m.Attempts++
+	}
+	return msgs, nil
+}
+
+// deliver 发布一行并记录结果；标记使用不可取消的 ctx，避免已发布的行因退出而被重复投递
+func (o *Outbox) deliver(ctx context.Context, m *OutboxMessage) error {
+	args := &rds.XAddArgs{
+		Stream: m.Stream,
+		Values: map[string]any{
+			"outbox_id": strconv.FormatInt(m.ID, 10),
+			"key":       m.EventKey,
+			"type":      m.EventType,
+			"payload":   m.Payload,
+		},
+	}
+	if o.cfg.MaxLen > 0 {
+		args.MaxLen = o.cfg.MaxLen
+		args.Approx = true
+	}
+
+	pubErr := o.dbs.RDS.XAdd(ctx, args).Err()
+
+	markCtx := context.WithoutCancel(ctx)
+	var err errors.Error
+	if pubErr == nil {
+		err = o.markSent(markCtx, m)
+	} else {
+		logs.Logger.Warn(pubErr)
+		err = o.markFailed(markCtx, m, pubErr)
+	}
+	if err != nil {
+		return err
+	}
+	return pubErr
+}
+
+func (o *Outbox) markSent(ctx context.Context, m *OutboxMessage) errors.Error {
+	now := time.Now()
+	err := o.dbs.MySQL.WithContext(ctx).Table(o.cfg.Table).
+		Where(clause.Eq{Column: clause.Column{Name: "id"}, Value: m.ID}).
+		Where(clause.Eq{Column: clause.Column{Name: "status"}, Value: OutboxPending}).
+		Updates(map[string]any{"status": OutboxSent, "sent_at": now, "last_error": ""}).Error
+	if err != nil {
+		return o.wrapErr(ctx, err)
+	}
+	return nil
+}
+
+// markFailed 按退避安排下一次重试，达到最大次数时标记为 dead
+func (o *Outbox) markFailed(ctx context.Context, m *OutboxMessage, cause error) errors.Error {
+	msg := cause.Error()
+	if len(msg) > outboxMaxErrorLen {
+		msg = msg[:outboxMaxErrorLen]
+	}
+	updates := map[string]any{
+		"last_error":    msg,
+		"next_retry_at": time.Now().Add(o.backoff(m.Attempts)),
+	}
+	if o.cfg.MaxAttempts > 0 && m.Attempts >= o.cfg.MaxAttempts {
+		updates["status"] = OutboxDead
+	}
+
+	err := o.dbs.MySQL.WithContext(ctx).Table(o.cfg.Table).
+		Where(clause.Eq{Column: clause.Column{Name: "id"}, Value: m.ID}).
+		Where(clause.Eq{Column: clause.Column{Name: "status"}, Value: OutboxPending}).
+		Updates(updates).Error
+	if err != nil {
+		return o.wrapErr(ctx, err)
+	}
+	return nil
+}
+
+// backoff 第 attempts 次失败后的等待时间：BaseDelay * 2^(attempts-1)，不超过 MaxDelay
+func (o *Outbox) backoff(attempts int) time.Duration {
+	delay := o.cfg.BaseDelay
+	for i := 1; i < attempts && delay < o.cfg.MaxDelay; i++ {
+		delay <<= 1
+	}
+	if delay > o.cfg.MaxDelay {
+		delay = o.cfg.MaxDelay
+	}
+	return delay
+}
+
+func (o *Outbox) wrapErr(ctx context.Context, err error) errors.Error {
+	e := ClassifyError(ctx, err)
+	if IsRetryable(e) || ctxErr(ctx, err) != nil {
+		logs.Logger.Warn(err)
+	} else {
+		logs.Logger.Error(err)
+	}
+	return e
+}
+
+func outboxIDs(ids []int64) []any {
+	vals := make([]any, len(ids))
+	for i, id := range ids {
+		vals[i] = id
+	}
+	return vals
+}
+
+func outboxPayload(v any) (string, error) {
+	switch p := v.(type) {
+	case nil:
+		return "", nil
+	case string:
+		return p, nil
+	case []byte:
+		return string(p), nil
+	case json.RawMessage:
+		return string(p), nil
+	}
+	b, err := json.Marshal(v)
+	if err != nil {
+		return "", err
+	}
+	return string(b), nil
+}
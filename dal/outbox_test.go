@@ -0,0 +1,184 @@
+package dal_test
+
+import (
+	"context"
+	stdErrors "errors"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/xsda-pixel/common-infra/dal"
+
+	"github.com/alicebob/miniredis/v2"
+	rds "github.com/redis/go-redis/v9"
+	"gorm.io/gorm"
+)
+
+func newOutbox(t *testing.T, opts ...func(*dal.OutboxConfig)) (*dal.Outbox, *dal.DBS, *miniredis.Miniredis) {
+	t.Helper()
+	db := openSQLite(t)
+	if err := db.Table("outbox").AutoMigrate(&dal.OutboxMessage{}); err != nil {
+		t.Fatal(err)
+	}
+	mr := miniredis.RunT(t)
+	client := rds.NewClient(&rds.Options{Addr: mr.Addr()})
+	t.Cleanup(func() { _ = client.Close() })
+
+	dbs := dal.NewDB(db, client)
+	// 内存 SQLite 不支持并发写，逐行投递
+	opts = append([]func(*dal.OutboxConfig){dal.WithOutboxConcurrency(1)}, opts...)
+	return dal.NewOutbox(dbs, opts...), dbs, mr
+}
+
+func outboxRows(t *testing.T, dbs *dal.DBS) map[string]*dal.OutboxMessage {
+	t.Helper()
+	var list []*dal.OutboxMessage
+	if err := dbs.MySQL.Table("outbox").Order("id").Find(&list).Error; err != nil {
+		t.Fatal(err)
+	}
+	rows := make(map[string]*dal.OutboxMessage, len(list))
+	for _, m := range list {
+		rows[m.EventKey] = m
+	}
+	return rows
+}
+
+func runOnce(t *testing.T, o *dal.Outbox, want int) {
+	t.Helper()
+	n, err := o.RunOnce(context.Background())
+	if err != nil || n != want {
+		t.Fatalf("RunOnce = %d, %v; want %d", n, err, want)
+	}
+}
+
+func TestOutboxRelay(t *testing.T) {
+	o, dbs, mr := newOutbox(t, dal.WithOutboxRetry(3, 50*time.Millisecond, 80*time.Millisecond))
+	ctx := context.Background()
+
+	// 事务回滚时事件一并丢弃
+	boom := stdErrors.New("boom")
+	err := dbs.WithTx(ctx, func(ctx context.Context, tx *gorm.DB) error {
+		if err := o.Publish(tx, dal.OutboxEvent{Stream: "orders", Key: "rolled-back"}); err != nil {
+			return err
+		}
+		return boom
+	})
+	if !stdErrors.Is(err, boom) {
+		t.Fatalf("WithTx error = %v", err)
+	}
+
+	// 目标 key 不是 stream，XADD 返回 WRONGTYPE
+	mr.Set("broken", "not a stream")
+	err = dbs.WithTx(ctx, func(ctx context.Context, tx *gorm.DB) error {
+		return o.Publish(tx,
+			dal.OutboxEvent{Stream: "orders", Key: "O1", Type: "created", Payload: map[string]any{"amount": 100}},
+			dal.OutboxEvent{Stream: "orders", Key: "O2", Type: "paid", Payload: "raw"},
+			dal.OutboxEvent{Stream: "broken", Key: "O3", Type: "created"},
+		)
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if rows := outboxRows(t, dbs); len(rows) != 3 || rows["rolled-back"] != nil {
+		t.Fatalf("outbox rows after publish = %d", len(rows))
+	}
+
+	runOnce(t, o, 3)
+	entries, e := mr.Stream("orders")
+	if e != nil || len(entries) != 2 {
+		t.Fatalf("stream entries = %v, %v", entries, e)
+	}
+	rows := outboxRows(t, dbs)
+	fields := make(map[string]string)
+	for i := 0; i+1 < len(entries[0].Values); i += 2 {
+		fields[entries[0].Values[i]] = entries[0].Values[i+1]
+	}
+	want := map[string]string{"outbox_id": strconv.FormatInt(rows["O1"].ID, 10), "key": "O1", "type": "created", "payload": `{"amount":100}`}
+	if fmt.Sprint(fields) != fmt.Sprint(want) {
+		t.Fatalf("stream entry = %v, want %v", fields, want)
+	}
+	for _, key := range []string{"O1", "O2"} {
+		if m := rows[key]; m.Status != dal.OutboxSent || m.SentAt == nil || m.Attempts != 1 {
+			t.Fatalf("row %s = %+v, want sent", key, m)
+		}
+	}
+	failed := rows["O3"]
+	if failed.Status != dal.OutboxPending || failed.Attempts != 1 || !strings.Contains(failed.LastError, "WRONGTYPE") {
+		t.Fatalf("failed row = %+v, want pending with the publish error", failed)
+	}
+
+	// 退避期内不重新认领，发送成功的行不再发布
+	runOnce(t, o, 0)
+	for attempt := 2; attempt <= 3; attempt++ {
+		time.Sleep(100 * time.Millisecond)
+		runOnce(t, o, 1)
+		if m := outboxRows(t, dbs)["O3"]; m.Attempts != attempt {
+			t.Fatalf("attempts = %d, want %d", m.Attempts, attempt)
+		}
+	}
+
+	// 第 MaxAttempts 次失败后标记为 dead，不再认领
+	if m := outboxRows(t, dbs)["O3"]; m.Status != dal.OutboxDead {
+		t.Fatalf("row after 3 attempts = %+v, want dead", m)
+	}
+	time.Sleep(100 * time.Millisecond)
+	runOnce(t, o, 0)
+	if entries, _ := mr.Stream("orders"); len(entries) != 2 {
+		t.Fatalf("stream entries = %d, want 2", len(entries))
+	}
+
+	// 只清理早于 before 的已发送行
+	n, perr := o.Purge(ctx, time.Now().Add(time.Minute), 1)
+	if perr != nil || n != 1 {
+		t.Fatalf("Purge = %d, %v; want 1", n, perr)
+	}
+	if n, _ = o.Purge(ctx, time.Now().Add(-time.Minute), 0); n != 0 {
+		t.Fatalf("Purge of recent rows = %d, want 0", n)
+	}
+	if rows := outboxRows(t, dbs); len(rows) != 2 || rows["O3"] == nil {
+		t.Fatalf("rows after Purge = %d", len(rows))
+	}
+}
+
+func TestOutboxLease(t *testing.T) {
+	o, dbs, mr := newOutbox(t, dal.WithOutboxLease(time.Minute))
+	if err := o.Publish(dbs.MySQL, dal.OutboxEvent{Stream: "orders", Key: "O1"}); err != nil {
+		t.Fatal(err)
+	}
+
+	// 模拟已被其他投递器认领、尚未完成：租约内不重复认领
+	dbs.MySQL.Table("outbox").Where("event_key = ?", "O1").Updates(map[string]any{"attempts": 1, "next_retry_at": time.Now().Add(time.Minute)})
+	runOnce(t, o, 0)
+
+	// 投递器崩溃，租约到期后由本实例重新认领并发布
+	dbs.MySQL.Table("outbox").Where("event_key = ?", "O1").Update("next_retry_at", time.Now().Add(-time.Second))
+	runOnce(t, o, 1)
+	m := outboxRows(t, dbs)["O1"]
+	if m.Status != dal.OutboxSent || m.Attempts != 2 {
+		t.Fatalf("row after lease expiry = %+v, want sent on attempt 2", m)
+	}
+	if entries, _ := mr.Stream("orders"); len(entries) != 1 {
+		t.Fatalf("stream entries = %d, want 1", len(entries))
+	}
+}
+
+func TestOutboxErrors(t *testing.T) {
+	o, dbs, _ := newOutbox(t)
+	if err := o.Publish(dbs.MySQL, dal.OutboxEvent{Key: "O1"}); err == nil || err.ErrCode() != http.StatusBadRequest {
+		t.Fatalf("Publish without stream error = %v, want 400", err)
+	}
+	if err := o.Publish(dbs.MySQL, dal.OutboxEvent{Stream: "orders", Payload: func() {}}); err == nil || err.ErrCode() != http.StatusBadRequest {
+		t.Fatalf("Publish with unencodable payload error = %v, want 400", err)
+	}
+	if rows := outboxRows(t, dbs); len(rows) != 0 {
+		t.Fatalf("rows after rejected Publish = %d", len(rows))
+	}
+
+	noRedis := dal.NewOutbox(dal.NewDB(dbs.MySQL, nil))
+	if _, err := noRedis.RunOnce(context.Background()); !stdErrors.Is(err, dal.ErrOutboxNoRedis) {
+		t.Fatalf("RunOnce without redis error = %v, want ErrOutboxNoRedis", err)
+	}
+}
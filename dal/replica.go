@@ -0,0 +1,121 @@
+package dal
+
+import (
+	"context"
+	"sync/atomic"
+	"time"
+
+	"github.com/xsda-pixel/common-infra/logs"
+
+	"gorm.io/gorm"
+)
+
+type replica struct {
+	db      *gorm.DB
+	healthy atomic.Bool
+}
+
+type primaryCtxKey struct{}
+
+// primaryFlag 主库路由标记；forced 为 true 时该 ctx 上的读操作走主库
+type primaryFlag struct {
+	forced atomic.Bool
+}
+
+// WithReplicas 配置只读从库，读操作在健康的从库间轮询，写操作与 FindOneForUpdate 始终走主库
+func WithReplicas(replicas ...*gorm.DB) func(*DBS) {
+	return func(d *DBS) {
+		for _, r := range replicas {
+			if r == nil {
+				continue
+			}
+			rep := &replica{db: r}
+			rep.healthy.Store(true)
+			d.replicas = append(d.replicas, rep)
+		}
+	}
+}
+
+// WithPrimary 返回强制读主库的 ctx，适用于刚写完立即读的场景
+func WithPrimary(ctx context.Context) context.Context {
+	flag := &primaryFlag{}
+	flag.forced.Store(true)
+	return context.WithValue(ctx, primaryCtxKey{}, flag)
+}
+
+// WithReadYourWrites 返回带粘滞标记的 ctx：该 ctx 上一旦通过 RepoDB 写入成功，后续读操作自动切到主库。
+// 通常在请求入口处设置一次。
+func WithReadYourWrites(ctx context.Context) context.Context {
+	return context.WithValue(ctx, primaryCtxKey{}, &primaryFlag{})
+}
+
+func primaryForced(ctx context.Context) bool {
+	if ctx == nil {
+		return false
+	}
+	flag, ok := ctx.Value(primaryCtxKey{}).(*primaryFlag)
+	return ok && flag.forced.Load()
+}
+
+// markWritten 写入成功后打上读主库标记（仅对 WithReadYourWrites 的 ctx 生效）
+func markWritten(ctx context.Context) {
+	if ctx == nil {
+		return
+	}
+	if flag, ok := ctx.Value(primaryCtxKey{}).(*primaryFlag); ok {
+		flag.forced.Store(true)
+	}
+}
+
+// Reader 返回读操作使用的连接：ctx 要求读主库或没有健康从库时返回主库，否则在健康从库间轮询
+func (d *DBS) Reader(ctx context.Context) *gorm.DB {
+	if len(d.replicas) == 0 || primaryForced(ctx) {
+		return d.MySQL
+	}
+
+	n := uint64(len(d.replicas))
+	start := d.next.Add(1)
+	for i := uint64(0); i < n; i++ {
+		r := d.replicas[(start+i)%n]
+		if r.healthy.Load() {
+			return r.db
+		}
+	}
+	return d.MySQL
+}
+
+// StartHealthCheck 按 interval 周期 ping 从库并更新健康状态，ctx 结束后退出；非阻塞
+func (d *DBS) StartHealthCheck(ctx context.Context, interval time.Duration) {
+	if len(d.replicas) == 0 || interval <= 0 {
+		return
+	}
+	go func() {
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case <-ticker.C:
+				for _, r := range d.replicas {
+					r.healthy.Store(pingReplica(ctx, r.db, interval))
+				}
+			}
+		}
+	}()
+}
+
+func pingReplica(ctx context.Context, db *gorm.DB, timeout time.Duration) bool {
+	sqlDB, err := db.DB()
+	if err != nil {
+		logs.Logger.Error(err)
+		return false
+	}
+	pingCtx, cancel := context.WithTimeout(ctx, timeout)
+	defer cancel()
+	if err := sqlDB.PingContext(pingCtx); err != nil {
+		logs.Logger.Warn(err)
+		return false
+	}
+	return true
+}
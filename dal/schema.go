@@ -0,0 +1,49 @@
+package dal
+
+import (
+	"reflect"
+	"strings"
+	"sync"
+
+	"gorm.io/gorm/schema"
+)
+
+var schemaCache sync.Map
+
+// modelSchema 解析 T 的 gorm schema，用于按列名读写结构体字段
+func (db *RepoDB[T]) modelSchema() (*schema.Schema, error) {
+	var namer schema.Namer = schema.NamingStrategy{}
+	if db.MySQL != nil && db.MySQL.Config != nil && db.MySQL.NamingStrategy != nil {
+		namer = db.MySQL.NamingStrategy
+	}
+	return schema.Parse(new(T), &schemaCache, namer)
+}
+
+// lookupField 按列名查找字段，列名可带表别名前缀与反引号（如 u.`id`）
+func lookupField(sch *schema.Schema, column string) *schema.Field {
+	return sch.LookUpField(bareColumn(column))
+}
+
+// fieldValue 读取 item 上某字段的值
+func (db *RepoDB[T]) fieldValue(field *schema.Field, item *T) any {
+	v, _ := field.ValueOf(db.Context(), reflect.ValueOf(item).Elem())
+	return v
+}
+
+// bareColumn 去掉表别名前缀与引号："u.`id`" -> "id"
+func bareColumn(column string) string {
+	column = strings.TrimSpace(column)
+	if i := strings.LastIndexByte(column, '.'); i >= 0 {
+		column = column[i+1:]
+	}
+	return strings.Trim(column, "`\"")
+}
+
+// tableAlias 返回表的引用名："user u" / "user AS u" -> "u"，"user" -> "user"
+func tableAlias(tableName string) string {
+	parts := strings.Fields(tableName)
+	if len(parts) == 0 {
+		return ""
+	}
+	return parts[len(parts)-1]
+}
@@ -0,0 +1,512 @@
+package dal
+
+import (
+	"context"
+	"fmt"
+	"hash/crc32"
+	"net/http"
+	"reflect"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/xsda-pixel/common-infra/errors"
+	"github.com/xsda-pixel/common-infra/types/amount"
+
+	"golang.org/x/sync/errgroup"
+	"gorm.io/gorm"
+)
+
+const defaultShardFormat = "%s_%02d"
+
+var ErrShardKeyRequired = errors.NewError(http.StatusBadRequest, errors.NewMsg("shard key is required"))
+
+// ShardRule 分表规则：逻辑表 Table 按 Key 分为 Shards 张物理表
+type ShardRule struct {
+	Table          string                     // 逻辑表名，如 orders
+	Key            string                     // 分片键列，如 user_id
+	Shards         int                        // 分片数
+	Format         string                     // 物理表名格式，参数为 (Table, 分片号)，默认 "%s_%02d"：orders_00 .. orders_63
+	Resolve        func(key any) (int, error) // 分片函数，默认整数（含十进制整数字符串）取模、其余字符串 crc32 取模
+	DBS            []*DBS                     // 可选：分库时各库连接，分片 i 位于 DBS[i % len(DBS)]；为空时全部使用仓库自身的 DBS
+	MaxConcurrency int                        // 跨分片查询的最大并发，<= 0 时不限制
+}
+
+// ShardedRepo 分表仓库：按分片键路由到物理表（及库），没有分片键时并发扇出到全部分片并合并结果
+type ShardedRepo[T any] struct {
+	repo *RepoDB[T]
+	rule ShardRule
+}
+
+// NewShardedRepo 基于 repo 创建分表仓库，repo 的缓存、软删除、租户等配置对每个分片同样生效
+func NewShardedRepo[T any](repo *RepoDB[T], rule ShardRule) *ShardedRepo[T] {
+	if rule.Format == "" {
+		rule.Format = defaultShardFormat
+	}
+	if rule.Resolve == nil {
+		shards := rule.Shards
+		rule.Resolve = func(key any) (int, error) {
+			return modShard(key, shards)
+		}
+	}
+	return &ShardedRepo[T]{repo: repo, rule: rule}
+}
+
+// WithContext 返回绑定 ctx 的副本
+func (s *ShardedRepo[T]) WithContext(ctx context.Context) *ShardedRepo[T] {
+	return &ShardedRepo[T]{repo: s.repo.WithContext(ctx), rule: s.rule}
+}
+
+// Shard 返回分片键对应的分片号
+func (s *ShardedRepo[T]) Shard(key any) (int, errors.Error) {
+	n, err := s.rule.Resolve(key)
+	if err != nil {
+		return 0, errors.NewError(http.StatusBadRequest, errors.NewMsg("resolve shard of %v: %v", key, err))
+	}
+	if n < 0 || n >= s.rule.Shards {
+		return 0, errors.NewError(http.StatusBadRequest, errors.NewMsg("shard %d out of range [0, %d)", n, s.rule.Shards))
+	}
+	return n, nil
+}
+
+// TableName 分片号对应的物理表名
+func (s *ShardedRepo[T]) TableName(shard int) string {
+	return fmt.Sprintf(s.rule.Format, s.rule.Table, shard)
+}
+
+// Route 返回分片键所在的物理表与仓库，可用于调用 RepoDB 上未在 ShardedRepo 中提供的方法
+func (s *ShardedRepo[T]) Route(key any) (string, *RepoDB[T], errors.Error) {
+	n, err := s.Shard(key)
+	if err != nil {
+		return "", nil, err
+	}
+	return s.TableName(n), s.shardRepo(n), nil
+}
+
+// shardRepo 分片所在库的仓库；未分库时即 repo 本身
+func (s *ShardedRepo[T]) shardRepo(shard int) *RepoDB[T] {
+	if len(s.rule.DBS) == 0 {
+		return s.repo
+	}
+	repo := *s.repo
+	repo.DBS = s.rule.DBS[shard%len(s.rule.DBS)]
+	return &repo
+}
+
+// routeWhere where.Eq 或 where.Cond 中带有单值分片键等值条件时返回对应分片
+func (s *ShardedRepo[T]) routeWhere(where WhereOption) (int, bool, errors.Error) {
+	for col, v := range where.Eq {
+		if bareColumn(col) != s.rule.Key || isMultiValue(v) {
+			continue
+		}
+		n, err := s.Shard(v)
+		return n, true, err
+	}
+	if v, ok := s.keyCond(where.Cond); ok {
+		n, err := s.Shard(v)
+		return n, true, err
+	}
+	return 0, false, nil
+}
+
+// keyCond Cond 顶层或 AND 组内的分片键等值条件；OR / NOT 中的条件不能确定分片
+func (s *ShardedRepo[T]) keyCond(c Cond) (any, bool) {
+	switch x := c.(type) {
+	case cmpCond:
+		if x.op == opEq && x.value != nil && bareColumn(x.column) == s.rule.Key && !isMultiValue(x.value) {
+			return x.value, true
+		}
+	case groupCond:
+		if x.or {
+			return nil, false
+		}
+		for _, sub := range x.conds {
+			if v, ok := s.keyCond(sub); ok {
+				return v, true
+			}
+		}
+	}
+	return nil, false
+}
+
+// routeItem 从 item 的分片键字段取值并路由
+func (s *ShardedRepo[T]) routeItem(item *T) (int, errors.Error) {
+	sch, err := s.repo.modelSchema()
+	if err != nil {
+		return 0, s.repo.wrapErr(err)
+	}
+	field := lookupField(sch, s.rule.Key)
+	if field == nil {
+		return 0, errors.NewError(http.StatusBadRequest, errors.NewMsg("shard key %s not found in model", s.rule.Key))
+	}
+	v, zero := field.ValueOf(s.repo.Context(), reflect.ValueOf(item).Elem())
+	if zero {
+		return 0, ErrShardKeyRequired
+	}
+	return s.Shard(v)
+}
+
+// fanOut 在全部分片上并发执行 fn，任一分片出错时取消其余分片（通过 ctx）并返回该错误
+func (s *ShardedRepo[T]) fanOut(fn func(shard int, table string, repo *RepoDB[T]) errors.Error) errors.Error {
+	g, ctx := errgroup.WithContext(s.repo.Context())
+	if _, inTx := txStateFrom(ctx); inTx {
+		g.SetLimit(1) // 同一事务连接不能并发使用
+	} else if s.rule.MaxConcurrency > 0 {
+		g.SetLimit(s.rule.MaxConcurrency)
+	}
+
+	for i := 0; i < s.rule.Shards; i++ {
+		shard := i
+		g.Go(func() error {
+			if e := fn(shard, s.TableName(shard), s.shardRepo(shard).WithContext(ctx)); e != nil {
+				return e
+			}
+			return nil
+		})
+	}
+
+	if err := g.Wait(); err != nil {
+		return err.(errors.Error)
+	}
+	return nil
+}
+
+// FindOne 跨分片时返回任一分片命中的行，适用于唯一键查询
+func (s *ShardedRepo[T]) FindOne(fields []string, where WhereOption) (*T, errors.Error) {
+	if n, ok, err := s.routeWhere(where); err != nil {
+		return nil, err
+	} else if ok {
+		return s.shardRepo(n).FindOne(s.TableName(n), fields, where)
+	}
+
+	var (
+		mu    sync.Mutex
+		found *T
+	)
+	err := s.fanOut(func(_ int, table string, repo *RepoDB[T]) errors.Error {
+		item, e := repo.FindOne(table, fields, where)
+		if e != nil || item == nil {
+			return e
+		}
+		mu.Lock()
+		if found == nil {
+			found = item
+		}
+		mu.Unlock()
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return found, nil
+}
+
+// FindMany 跨分片时每个分片各取 limit 行，按 orders 归并排序后截取前 limit 行；orders 列需能对应到 T 的字段
+func (s *ShardedRepo[T]) FindMany(fields []string, where WhereOption, orders []OrderBy, limit *int) ([]*T, errors.Error) {
+	var order *string
+	if len(orders) > 0 {
+		order = Orders(orders...)
+	}
+
+	if n, ok, err := s.routeWhere(where); err != nil {
+		return nil, err
+	} else if ok {
+		return s.shardRepo(n).FindMany(s.TableName(n), fields, where, order, limit)
+	}
+
+	parts, err := s.collect(func(table string, repo *RepoDB[T]) ([]*T, errors.Error) {
+		return repo.FindMany(table, fields, where, order, limit)
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	list, err := s.merge(parts, orders)
+	if err != nil {
+		return nil, err
+	}
+	if limit != nil && *limit > 0 && len(list) > *limit {
+		list = list[:*limit]
+	}
+	return list, nil
+}
+
+// FindPage 跨分片时每个分片取前 page*limit 行再归并截取，深翻页代价随页码线性增长，深翻页请使用 Route 后的游标分页
+func (s *ShardedRepo[T]) FindPage(page, limit int, fields []string, where WhereOption, orders []OrderBy) ([]*T, errors.Error) {
+	var order *string
+	if len(orders) > 0 {
+		order = Orders(orders...)
+	}
+
+	if n, ok, err := s.routeWhere(where); err != nil {
+		return nil, err
+	} else if ok {
+		return s.shardRepo(n).FindPage(s.TableName(n), page, limit, fields, where, order)
+	}
+
+	var list []*T
+	if page < 1 || limit < 1 {
+		return list, nil
+	}
+	offset := (page - 1) * limit
+	if offset < 0 || offset+limit < 0 {
+		return list, nil // 防止溢出
+	}
+
+	size := offset + limit
+	parts, err := s.collect(func(table string, repo *RepoDB[T]) ([]*T, errors.Error) {
+		return repo.FindPage(table, 1, size, fields, where, order)
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	list, err = s.merge(parts, orders)
+	if err != nil {
+		return nil, err
+	}
+	if offset >= len(list) {
+		return []*T{}, nil
+	}
+	end := offset + limit
+	if end > len(list) {
+		end = len(list)
+	}
+	return list[offset:end], nil
+}
+
+func (s *ShardedRepo[T]) Count(where WhereOption) (int64, errors.Error) {
+	if n, ok, err := s.routeWhere(where); err != nil {
+		return 0, err
+	} else if ok {
+		return s.shardRepo(n).Count(s.TableName(n), where)
+	}
+
+	counts := make([]int64, s.rule.Shards)
+	err := s.fanOut(func(shard int, table string, repo *RepoDB[T]) errors.Error {
+		n, e := repo.Count(table, where)
+		counts[shard] = n
+		return e
+	})
+	if err != nil {
+		return 0, err
+	}
+
+	var total int64
+	for _, n := range counts {
+		total += n
+	}
+	return total, nil
+}
+
+// CreateOne 按 item 的分片键写入；dbs 为 nil 时使用分片所在库的主库，分库时传入的事务需属于该库
+func (s *ShardedRepo[T]) CreateOne(dbs *gorm.DB, item *T) errors.Error {
+	if item == nil {
+		return errors.NewError(http.StatusBadRequest, errors.NewMsg("CreateOne: item is nil"))
+	}
+	n, err := s.routeItem(item)
+	if err != nil {
+		return err
+	}
+	repo := s.shardRepo(n)
+	return repo.CreateOne(writeConn(dbs, repo), s.TableName(n), item)
+}
+
+// Update 只更新单个分片，where 必须带分片键等值条件（Eq，或 Cond 顶层 / AND 组内的 Eq），避免误操作全部分片
+func (s *ShardedRepo[T]) Update(dbs *gorm.DB, where WhereOption, updates map[string]any) (int64, errors.Error) {
+	n, ok, err := s.routeWhere(where)
+	if err != nil {
+		return 0, err
+	}
+	if !ok {
+		return 0, ErrShardKeyRequired
+	}
+	if _, changed := updates[s.rule.Key]; changed {
+		return 0, invalidQuery("shard key %s can not be updated", s.rule.Key)
+	}
+	repo := s.shardRepo(n)
+	return repo.Update(writeConn(dbs, repo), s.TableName(n), where, updates)
+}
+
+// Delete 只删除单个分片，where 必须带分片键等值条件，同 Update
+func (s *ShardedRepo[T]) Delete(dbs *gorm.DB, where WhereOption) (int64, errors.Error) {
+	n, ok, err := s.routeWhere(where)
+	if err != nil {
+		return 0, err
+	}
+	if !ok {
+		return 0, ErrShardKeyRequired
+	}
+	repo := s.shardRepo(n)
+	return repo.Delete(writeConn(dbs, repo), s.TableName(n), where)
+}
+
+// collect 并发在每个分片上执行 find，按分片号返回各自结果
+func (s *ShardedRepo[T]) collect(find func(table string, repo *RepoDB[T]) ([]*T, errors.Error)) ([][]*T, errors.Error) {
+	parts := make([][]*T, s.rule.Shards)
+	err := s.fanOut(func(shard int, table string, repo *RepoDB[T]) errors.Error {
+		list, e := find(table, repo)
+		parts[shard] = list
+		return e
+	})
+	return parts, err
+}
+
+// merge 合并各分片结果并按 orders 稳定排序；未指定 orders 时按分片号顺序拼接
+func (s *ShardedRepo[T]) merge(parts [][]*T, orders []OrderBy) ([]*T, errors.Error) {
+	var list []*T
+	for _, p := range parts {
+		list = append(list, p...)
+	}
+	if len(orders) == 0 || len(list) < 2 {
+		return list, nil
+	}
+
+	sch, err := s.repo.modelSchema()
+	if err != nil {
+		return nil, s.repo.wrapErr(err)
+	}
+	keys, e := seekKeys(sch, s.rule.Table, orders)
+	if e != nil {
+		return nil, e
+	}
+
+	sort.SliceStable(list, func(i, j int) bool {
+		for _, k := range keys {
+			c := compareValues(s.repo.fieldValue(k.field, list[i]), s.repo.fieldValue(k.field, list[j]))
+			if c == 0 {
+				continue
+			}
+			if k.Desc {
+				return c > 0
+			}
+			return c < 0
+		}
+		return false
+	})
+	return list, nil
+}
+
+func writeConn[T any](dbs *gorm.DB, repo *RepoDB[T]) *gorm.DB {
+	if dbs != nil {
+		return dbs
+	}
+	return repo.MySQL
+}
+
+// modShard 默认分片函数：整数取绝对值后取模，其余类型按字符串形式 crc32 取模。
+// 十进制整数形式的字符串（如 "123"）按整数路由，与 123 落在同一分片，避免 URL 参数等字符串形式的 id 路由到别的分片
+func modShard(key any, shards int) (int, error) {
+	if shards <= 0 {
+		return 0, fmt.Errorf("invalid shard count %d", shards)
+	}
+	n, ok := toInt64(key)
+	if !ok {
+		n, ok = numericString(key)
+	}
+	if ok {
+		if n < 0 {
+			n = -n
+		}
+		return int(uint64(n) % uint64(shards)), nil
+	}
+	rv := reflect.Indirect(reflect.ValueOf(key))
+	if !rv.IsValid() {
+		return 0, fmt.Errorf("nil shard key")
+	}
+	s := fmt.Sprint(rv.Interface())
+	return int(crc32.ChecksumIEEE([]byte(s)) % uint32(shards)), nil
+}
+
+// numericString 解析十进制整数形式的字符串（含 *string 与自定义字符串类型）
+func numericString(key any) (int64, bool) {
+	rv := reflect.Indirect(reflect.ValueOf(key))
+	if rv.Kind() != reflect.String {
+		return 0, false
+	}
+	n, err := strconv.ParseInt(rv.String(), 10, 64)
+	return n, err == nil
+}
+
+// isMultiValue 切片/数组（[]byte 除外）在 Eq 中会生成 IN，无法路由到单个分片
+func isMultiValue(v any) bool {
+	rv := reflect.ValueOf(v)
+	switch rv.Kind() {
+	case reflect.Slice:
+		return rv.Type().Elem().Kind() != reflect.Uint8
+	case reflect.Array:
+		return true
+	}
+	return false
+}
+
+// compareValues 比较两个字段值，支持整数、浮点、字符串、布尔、time.Time 与 amount.Amount 及其指针；nil 排在最前
+func compareValues(a, b any) int {
+	av, bv := reflect.ValueOf(a), reflect.ValueOf(b)
+	for av.Kind() == reflect.Pointer {
+		if av.IsNil() {
+			break
+		}
+		av = av.Elem()
+	}
+	for bv.Kind() == reflect.Pointer {
+		if bv.IsNil() {
+			break
+		}
+		bv = bv.Elem()
+	}
+	aNil := !av.IsValid() || av.Kind() == reflect.Pointer
+	bNil := !bv.IsValid() || bv.Kind() == reflect.Pointer
+	switch {
+	case aNil && bNil:
+		return 0
+	case aNil:
+		return -1
+	case bNil:
+		return 1
+	}
+
+	switch x := av.Interface().(type) {
+	case time.Time:
+		if y, ok := bv.Interface().(time.Time); ok {
+			return x.Compare(y)
+		}
+	case amount.Amount:
+		if y, ok := bv.Interface().(amount.Amount); ok {
+			return x.Cmp(y)
+		}
+	}
+
+	switch av.Kind() {
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		return cmpOrdered(av.Int(), bv.Int())
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		return cmpOrdered(av.Uint(), bv.Uint())
+	case reflect.Float32, reflect.Float64:
+		return cmpOrdered(av.Float(), bv.Float())
+	case reflect.String:
+		return strings.Compare(av.String(), bv.String())
+	case reflect.Bool:
+		return cmpOrdered(boolInt(av.Bool()), boolInt(bv.Bool()))
+	}
+	return strings.Compare(fmt.Sprint(av.Interface()), fmt.Sprint(bv.Interface()))
+}
+
+func cmpOrdered[V int64 | uint64 | float64](a, b V) int {
+	switch {
+	case a < b:
+		return -1
+	case a > b:
+		return 1
+	}
+	return 0
+}
+
+func boolInt(b bool) int64 {
+	if b {
+		return 1
+	}
+	return 0
+}
@@ -0,0 +1,165 @@
+package dal_test
+
+import (
+	stdErrors "errors"
+	"fmt"
+	"testing"
+
+	"github.com/xsda-pixel/common-infra/dal"
+	"github.com/xsda-pixel/common-infra/dal/daltest"
+
+	"gorm.io/gorm"
+)
+
+const testShards = 4
+
+func newShardedRepo(t *testing.T) (*dal.ShardedRepo[daltest.Order], *gorm.DB) {
+	t.Helper()
+	db := openSQLite(t)
+	tables := make([]string, testShards)
+	for i := range tables {
+		tables[i] = fmt.Sprintf("orders_%02d", i)
+	}
+	migrateOrders(t, db, tables...)
+	repo := dal.NewRepoDB[daltest.Order](dal.NewDB(db, nil))
+	return dal.NewShardedRepo(repo, dal.ShardRule{Table: "orders", Key: "user_id", Shards: testShards}), db
+}
+
+func TestShardResolve(t *testing.T) {
+	s, _ := newShardedRepo(t)
+	type code string
+	str := "123"
+	for _, key := range []any{123, int64(123), uint8(123), "123", &str, code("123"), int64(-123)} {
+		n, err := s.Shard(key)
+		if err != nil || n != 123%testShards {
+			t.Fatalf("Shard(%#v) = %d, %v; want %d", key, n, err, 123%testShards)
+		}
+	}
+	if _, err := s.Shard((*string)(nil)); err == nil {
+		t.Fatal("Shard(nil *string) succeeded")
+	}
+	if n, err := s.Shard("abc"); err != nil || n < 0 || n >= testShards {
+		t.Fatalf("Shard(abc) = %d, %v", n, err)
+	}
+}
+
+func TestShardRouting(t *testing.T) {
+	s, db := newShardedRepo(t)
+	for i := int64(1); i <= 8; i++ {
+		if err := s.CreateOne(nil, &daltest.Order{UserID: i, OrderNo: fmt.Sprintf("NO%03d", i), Amount: i * 100}); err != nil {
+			t.Fatal(err)
+		}
+	}
+	for i := 0; i < testShards; i++ {
+		var n int64
+		db.Table(s.TableName(i)).Count(&n)
+		if n != 2 {
+			t.Fatalf("%s has %d rows, want 2", s.TableName(i), n)
+		}
+	}
+
+	// 在不属于 user 5 的分片中放一行 user 5 的数据：路由查询看不到它，扇出查询能看到
+	stray := s.TableName((5 + 1) % testShards)
+	if err := db.Table(stray).Create(&daltest.Order{UserID: 5, OrderNo: "STRAY"}).Error; err != nil {
+		t.Fatal(err)
+	}
+	routed := []dal.WhereOption{
+		{Eq: map[string]any{"user_id": 5}},
+		{Cond: dal.Eq("user_id", 5)},
+		{Cond: dal.And(dal.Gt("amount", 0), dal.Eq("user_id", "5"))},
+	}
+	for _, where := range routed {
+		n, err := s.Count(where)
+		if err != nil || n != 1 {
+			t.Fatalf("Count(%+v) = %d, %v; want 1 from the routed shard", where, n, err)
+		}
+	}
+	n, err := s.Count(dal.WhereOption{Cond: dal.Or(dal.Eq("user_id", 5), dal.Eq("user_id", -1))})
+	if err != nil || n != 2 {
+		t.Fatalf("Count with OR = %d, %v; want 2 from all shards", n, err)
+	}
+
+	// 写操作只能路由到单个分片
+	for _, where := range routed {
+		if _, err := s.Update(nil, where, map[string]any{"status": 1}); err != nil {
+			t.Fatalf("Update(%+v): %v", where, err)
+		}
+	}
+	for _, where := range []dal.WhereOption{
+		{Cond: dal.Eq("status", 1)},
+		{Cond: dal.Or(dal.Eq("user_id", 5), dal.Eq("user_id", 6))},
+		{Cond: dal.Not(dal.Eq("user_id", 5))},
+		{Eq: map[string]any{"user_id": []int64{5, 6}}},
+	} {
+		if _, err := s.Update(nil, where, map[string]any{"status": 2}); !stdErrors.Is(err, dal.ErrShardKeyRequired) {
+			t.Fatalf("Update(%+v) error = %v, want ErrShardKeyRequired", where, err)
+		}
+		if _, err := s.Delete(nil, where); !stdErrors.Is(err, dal.ErrShardKeyRequired) {
+			t.Fatalf("Delete(%+v) error = %v, want ErrShardKeyRequired", where, err)
+		}
+	}
+	if _, err := s.Update(nil, dal.WhereOption{Cond: dal.Eq("user_id", 5)}, map[string]any{"user_id": 6}); err == nil {
+		t.Fatal("Update of shard key succeeded")
+	}
+
+	n, err = s.Delete(nil, dal.WhereOption{Cond: dal.Eq("user_id", 5)})
+	if err != nil || n != 1 {
+		t.Fatalf("Delete = %d, %v; want 1", n, err)
+	}
+	var strays int64
+	db.Table(stray).Where("order_no = ?", "STRAY").Count(&strays)
+	if strays != 1 {
+		t.Fatal("Delete touched a shard it was not routed to")
+	}
+}
+
+func TestShardMerge(t *testing.T) {
+	s, _ := newShardedRepo(t)
+	for i := int64(1); i <= 10; i++ {
+		if err := s.CreateOne(nil, &daltest.Order{UserID: i, OrderNo: fmt.Sprintf("NO%03d", i), Amount: (i * 7) % 11}); err != nil {
+			t.Fatal(err)
+		}
+	}
+	desc := []dal.OrderBy{{Column: "amount", Desc: true}}
+
+	list, err := s.FindMany(nil, dal.WhereOption{}, desc, ptr(4))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := amounts(list); fmt.Sprint(got) != "[10 9 8 7]" {
+		t.Fatalf("FindMany amounts = %v", got)
+	}
+
+	var pages []int64
+	for page := 1; page <= 4; page++ {
+		list, err := s.FindPage(page, 3, nil, dal.WhereOption{}, desc)
+		if err != nil {
+			t.Fatal(err)
+		}
+		pages = append(pages, amounts(list)...)
+	}
+	if fmt.Sprint(pages) != "[10 9 8 7 6 5 4 3 2 1]" {
+		t.Fatalf("FindPage amounts = %v", pages)
+	}
+
+	n, err := s.Count(dal.WhereOption{Cond: dal.Gte("amount", 5)})
+	if err != nil || n != 6 {
+		t.Fatalf("Count = %d, %v; want 6", n, err)
+	}
+	o, err := s.FindOne(nil, dal.WhereOption{Eq: map[string]any{"order_no": "NO007"}})
+	if err != nil || o == nil || o.UserID != 7 {
+		t.Fatalf("FindOne across shards = %+v, %v", o, err)
+	}
+}
+
+func amounts(list []*daltest.Order) []int64 {
+	out := make([]int64, len(list))
+	for i, o := range list {
+		out[i] = o.Amount
+	}
+	return out
+}
+
+func ptr[V any](v V) *V {
+	return &v
+}
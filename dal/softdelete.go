@@ -0,0 +1,145 @@
+package dal
+
+import (
+	"net/http"
+	"time"
+
+	"github.com/xsda-pixel/common-infra/errors"
+
+	"gorm.io/gorm"
+)
+
+var errSoftDeleteDisabled = errors.NewError(http.StatusBadRequest, errors.NewMsg("soft delete is not configured"))
+
+// SoftDeleteConfig 软删除配置
+type SoftDeleteConfig struct {
+	Column string // 软删除列，如 deleted_at / is_deleted
+	Flag   bool   // true: 标记列（0 未删除，1 已删除）；false: 时间列（NULL 未删除，非 NULL 为删除时间）
+}
+
+// WithSoftDelete 以时间列（如 deleted_at）做软删除：读操作自动排除已删除行，Delete 改为写入删除时间
+func WithSoftDelete(column string) func(*RepoConfig) {
+	return func(c *RepoConfig) {
+		c.SoftDelete = &SoftDeleteConfig{Column: column}
+	}
+}
+
+// WithSoftDeleteFlag 以 0/1 标记列（如 is_deleted）做软删除
+func WithSoftDeleteFlag(column string) func(*RepoConfig) {
+	return func(c *RepoConfig) {
+		c.SoftDelete = &SoftDeleteConfig{Column: column, Flag: true}
+	}
+}
+
+// IncludeDeleted 返回读操作包含已软删除行的仓库副本
+func (db *RepoDB[T]) IncludeDeleted() *RepoDB[T] {
+	repo := *db
+	repo.includeDeleted = true
+	return &repo
+}
+
+// softDeleteCond 未删除行的过滤条件，列名带上主表别名以兼容联表查询
+func (db *RepoDB[T]) softDeleteCond(tableName string) Cond {
+	sd := db.config.SoftDelete
+	if sd == nil || db.includeDeleted {
+		return nil
+	}
+	return aliveCond(sd, tableAlias(tableName)+"."+sd.Column)
+}
+
+func aliveCond(sd *SoftDeleteConfig, column string) Cond {
+	if sd.Flag {
+		return Eq(column, 0)
+	}
+	return IsNull(column)
+}
+
+func (db *RepoDB[T]) softDelete(
+	dbs *gorm.DB,
+	tableName string,
+	where WhereOption,
+) (int64, errors.Error) {
+	if where.IsEmpty() {
+		return 0, errors.NewError(http.StatusBadRequest, errors.NewMsg("delete without where is forbidden")) // 防止误删
+	}
+
+	sd := db.config.SoftDelete
+	var deleted any = time.Now()
+	if sd.Flag {
+		deleted = 1
+	}
+
+	// 已删除的行不重复标记，保留首次删除时间
+	where.Cond = And(where.Cond, aliveCond(sd, sd.Column))
+
+	return db.updateColumn(dbs, tableName, AuditDelete, where, sd.Column, deleted)
+}
+
+// Restore 恢复符合条件的已软删除行
+func (db *RepoDB[T]) Restore(
+	dbs *gorm.DB,
+	tableName string,
+	where WhereOption,
+) (int64, errors.Error) {
+	op := &Operation{Kind: OpRestore, DB: dbs, Table: tableName, Where: where}
+	return intercept(db, op, func(op *Operation) (int64, errors.Error) {
+		return db.restore(op.DB, op.Table, op.Where)
+	})
+}
+
+func (db *RepoDB[T]) restore(
+	dbs *gorm.DB,
+	tableName string,
+	where WhereOption,
+) (int64, errors.Error) {
+	sd := db.config.SoftDelete
+	if sd == nil {
+		return 0, errSoftDeleteDisabled
+	}
+	if where.IsEmpty() {
+		return 0, errors.NewError(http.StatusBadRequest, errors.NewMsg("restore without where is forbidden"))
+	}
+
+	var alive any
+	if sd.Flag {
+		alive = 0
+	}
+
+	where.Cond = And(where.Cond, Not(aliveCond(sd, sd.Column)))
+
+	return db.updateColumn(dbs, tableName, AuditRestore, where, sd.Column, alive)
+}
+
+func (db *RepoDB[T]) updateColumn(
+	dbs *gorm.DB,
+	tableName string,
+	action string,
+	where WhereOption,
+	column string,
+	value any,
+) (int64, errors.Error) {
+	rows, err := db.audited(dbs, tableName, action, where, func(tx *gorm.DB, where WhereOption) (int64, errors.Error) {
+		rs, err := db.writeTable(tx, tableName)
+		if err != nil {
+			return 0, err
+		}
+
+		rs = applyWhere(rs, where)
+
+		rs = rs.Update(column, value)
+
+		if rs.Error != nil {
+			return 0, db.wrapErr(rs.Error)
+		}
+		return rs.RowsAffected, nil
+	})
+	if err != nil {
+		return 0, err
+	}
+
+	if rows > 0 {
+		db.afterWrite(tableName)
+	}
+
+	return rows, nil
+}
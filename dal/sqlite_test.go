@@ -0,0 +1,63 @@
+package dal_test
+
+import (
+	"os"
+	"strings"
+	"testing"
+
+	"github.com/xsda-pixel/common-infra/dal/daltest"
+	"github.com/xsda-pixel/common-infra/logs"
+
+	"github.com/glebarez/sqlite"
+	"gorm.io/gorm"
+	"gorm.io/gorm/logger"
+)
+
+func TestMain(m *testing.M) {
+	dir, err := os.MkdirTemp("", "dal-logs-")
+	if err != nil {
+		panic(err)
+	}
+	logs.LogFilePath = dir + "/"
+	if err := logs.Init(false, false, false); err != nil {
+		panic(err)
+	}
+
+	code := m.Run()
+	_ = os.RemoveAll(dir)
+	os.Exit(code)
+}
+
+// TestRepoSQLite 在进程内 SQLite 上运行 RepoDB 一致性测试，每个子测试使用独立的内存库
+func TestRepoSQLite(t *testing.T) {
+	daltest.Run(t, openSQLite)
+}
+
+// openSQLite 以测试名打开独立的进程内 SQLite 内存库，测试结束时关闭
+func openSQLite(t *testing.T) *gorm.DB {
+	t.Helper()
+	name := strings.NewReplacer("/", "_", " ", "_").Replace(t.Name())
+	db, err := gorm.Open(sqlite.Open("file:"+name+"?mode=memory&cache=shared"), &gorm.Config{Logger: logger.Discard})
+	if err != nil {
+		t.Fatal(err)
+	}
+	sqlDB, err := db.DB()
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { _ = sqlDB.Close() })
+	return db
+}
+
+// migrateOrders 建 daltest 的订单表，tables 为空时建 daltest.OrderTable
+func migrateOrders(t *testing.T, db *gorm.DB, tables ...string) {
+	t.Helper()
+	if len(tables) == 0 {
+		tables = []string{daltest.OrderTable}
+	}
+	for _, table := range tables {
+		if err := db.Table(table).AutoMigrate(&daltest.Order{}); err != nil {
+			t.Fatal(err)
+		}
+	}
+}
@@ -0,0 +1,215 @@
+package dal
+
+import (
+	"context"
+	"net/http"
+	"reflect"
+
+	"github.com/xsda-pixel/common-infra/errors"
+
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+var (
+	ErrTenantRequired = errors.NewError(http.StatusForbidden, errors.NewMsg("tenant is required"))
+	ErrTenantMismatch = errors.NewError(http.StatusForbidden, errors.NewMsg("tenant mismatch"))
+)
+
+type tenantCtxKey struct{}
+
+// WithTenant 开启多租户隔离：读、联表、更新、删除自动追加 column = 当前租户，CreateOne / CreateMany / Upsert 自动写入租户；
+// 缺少租户时拒绝执行（403），确需跨租户操作时使用 AdminBypass。
+// 唯一索引需包含租户列，否则 Upsert 的冲突可能命中其他租户的行。
+func WithTenant(column string) func(*RepoConfig) {
+	return func(c *RepoConfig) {
+		c.TenantColumn = column
+	}
+}
+
+// WithTenantID 将租户写入 ctx，通常在鉴权中间件中调用，仓库通过 WithContext(ctx) 读取
+func WithTenantID(ctx context.Context, tenantID any) context.Context {
+	if ctx == nil {
+		ctx = context.Background()
+	}
+	return context.WithValue(ctx, tenantCtxKey{}, tenantID)
+}
+
+// TenantFromContext 返回 ctx 中的租户
+func TenantFromContext(ctx context.Context) (any, bool) {
+	if ctx == nil {
+		return nil, false
+	}
+	id := ctx.Value(tenantCtxKey{})
+	return id, id != nil
+}
+
+// ForTenant 返回显式绑定租户的仓库副本，优先于 ctx 中的租户
+func (db *RepoDB[T]) ForTenant(tenantID any) *RepoDB[T] {
+	repo := *db
+	repo.tenantID = tenantID
+	return &repo
+}
+
+// AdminBypass 返回不做租户隔离的仓库副本，仅用于后台管理、跨租户统计等明确需要的场景
+func (db *RepoDB[T]) AdminBypass() *RepoDB[T] {
+	repo := *db
+	repo.bypassTenant = true
+	return &repo
+}
+
+// tenant 当前租户；未开启隔离或 AdminBypass 时 scoped 为 false
+func (db *RepoDB[T]) tenant() (id any, scoped bool, err errors.Error) {
+	if db.config.TenantColumn == "" || db.bypassTenant {
+		return nil, false, nil
+	}
+	if db.tenantID != nil {
+		return db.tenantID, true, nil
+	}
+	if id, ok := TenantFromContext(db.ctx); ok {
+		return id, true, nil
+	}
+	return nil, false, ErrTenantRequired
+}
+
+// tenantCond 租户过滤条件，列名带上表别名以兼容联表查询；不需要隔离时为 nil
+func (db *RepoDB[T]) tenantCond(tableName string) (Cond, errors.Error) {
+	id, scoped, err := db.tenant()
+	if err != nil || !scoped {
+		return nil, err
+	}
+	return Eq(tableAlias(tableName)+"."+db.config.TenantColumn, id), nil
+}
+
+// writeTable 在 dbs 上开始对 tableName 的更新/删除，并附加租户条件
+func (db *RepoDB[T]) writeTable(dbs *gorm.DB, tableName string) (*gorm.DB, errors.Error) {
+	rs := db.bind(dbs).Table(tableName)
+	c, err := db.tenantCond(tableName)
+	if err != nil {
+		return nil, err
+	}
+	if c != nil {
+		rs = rs.Where(c.expr())
+	}
+	return rs, nil
+}
+
+// checkTenantUpdates 禁止通过更新把行移到其他租户
+func (db *RepoDB[T]) checkTenantUpdates(columns map[string]any) errors.Error {
+	if _, scoped, _ := db.tenant(); !scoped {
+		return nil
+	}
+	for col := range columns {
+		if bareColumn(col) == db.config.TenantColumn {
+			return invalidQuery("tenant column %s can not be updated", col)
+		}
+	}
+	return nil
+}
+
+// stampTenant 写入前为每个 item 填充租户；item 已带有其他租户时返回 ErrTenantMismatch
+func (db *RepoDB[T]) stampTenant(items ...*T) errors.Error {
+	id, scoped, err := db.tenant()
+	if err != nil || !scoped {
+		return err
+	}
+
+	sch, e := db.modelSchema()
+	if e != nil {
+		return db.wrapErr(e)
+	}
+	field := lookupField(sch, db.config.TenantColumn)
+	if field == nil {
+		return errors.NewError(http.StatusBadRequest, errors.NewMsg("tenant column %s not found in model", db.config.TenantColumn))
+	}
+
+	ctx := db.Context()
+	for _, item := range items {
+		rv := reflect.ValueOf(item).Elem()
+		if cur, zero := field.ValueOf(ctx, rv); !zero && !sameTenant(cur, id) {
+			return ErrTenantMismatch
+		}
+		if e := field.Set(ctx, rv, id); e != nil {
+			return errors.NewError(http.StatusBadRequest, errors.NewMsg("set tenant column %s: %v", db.config.TenantColumn, e))
+		}
+	}
+	return nil
+}
+
+// tenantUpsert 租户隔离时冲突更新不覆盖租户列；UpdateAll 展开为除主键、冲突列、租户列与创建时间外的全部列。
+// 冲突行（主键或任一唯一键相同）可能属于其他租户，因此更新只在冲突行与插入行租户相同时生效，否则保持原行不变（影响行数为 0），见 guardTenant
+func (db *RepoDB[T]) tenantUpsert(rs *gorm.DB, onConflict clause.OnConflict) (clause.OnConflict, errors.Error) {
+	if _, scoped, _ := db.tenant(); !scoped {
+		return onConflict, nil
+	}
+
+	if !onConflict.UpdateAll {
+		var set clause.Set
+		for _, a := range onConflict.DoUpdates {
+			if a.Column.Name != db.config.TenantColumn {
+				set = append(set, a)
+			}
+		}
+		onConflict.DoUpdates = set
+		return db.guardTenant(rs, onConflict), nil
+	}
+
+	skip := map[string]struct{}{db.config.TenantColumn: {}}
+	for _, col := range onConflict.Columns {
+		skip[col.Name] = struct{}{}
+	}
+
+	sch, err := db.modelSchema()
+	if err != nil {
+		return onConflict, db.wrapErr(err)
+	}
+	var cols []string
+	for _, field := range sch.Fields {
+		if field.DBName == "" || field.PrimaryKey || field.AutoCreateTime > 0 || !field.Creatable {
+			continue
+		}
+		if _, ok := skip[field.DBName]; ok {
+			continue
+		}
+		cols = append(cols, field.DBName)
+	}
+	onConflict.UpdateAll = false
+	onConflict.DoUpdates = clause.AssignmentColumns(cols)
+	return db.guardTenant(rs, onConflict), nil
+}
+
+// guardTenant 为冲突更新加上租户条件：SQLite / PostgreSQL 使用 DO UPDATE ... WHERE，
+// MySQL 的 ON DUPLICATE KEY UPDATE 不支持条件，每列改写为 col = IF(tenant = VALUES(tenant), 新值, col)
+func (db *RepoDB[T]) guardTenant(rs *gorm.DB, onConflict clause.OnConflict) clause.OnConflict {
+	tenant := clause.Column{Name: db.config.TenantColumn}
+	if Dialect(rs) != DialectMySQL {
+		onConflict.Where.Exprs = append(onConflict.Where.Exprs, clause.Expr{
+			SQL:  "? = ?",
+			Vars: []any{clause.Column{Table: clause.CurrentTable, Name: tenant.Name}, clause.Column{Table: "excluded", Name: tenant.Name}},
+		})
+		return onConflict
+	}
+
+	set := make(clause.Set, 0, len(onConflict.DoUpdates))
+	for _, a := range onConflict.DoUpdates {
+		value := a.Value
+		if col, ok := value.(clause.Column); ok && col.Table == "excluded" {
+			value = clause.Expr{SQL: "VALUES(?)", Vars: []any{clause.Column{Name: col.Name}}}
+		}
+		set = append(set, clause.Assignment{Column: a.Column, Value: clause.Expr{
+			SQL:  "IF(? = VALUES(?), ?, ?)",
+			Vars: []any{tenant, tenant, value, clause.Column{Name: a.Column.Name}},
+		}})
+	}
+	onConflict.DoUpdates = set
+	return onConflict
+}
+
+// sameTenant 比较租户值，兼容 int64 与 uint 等不同整数类型
+func sameTenant(a, b any) bool {
+	if x, ok := toInt64(a); ok {
+		y, ok := toInt64(b)
+		return ok && x == y
+	}
+	return reflect.DeepEqual(reflect.Indirect(reflect.ValueOf(a)).Interface(), b)
+}
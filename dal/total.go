@@ -0,0 +1,201 @@
+package dal
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"strings"
+	"time"
+
+	"github.com/xsda-pixel/common-infra/errors"
+	"github.com/xsda-pixel/common-infra/logs"
+
+	rds "github.com/redis/go-redis/v9"
+	"gorm.io/gorm"
+)
+
+const cacheOpTotal = "total"
+
+// TotalConfig FindPageWithTotal 总数的获取方式
+type TotalConfig struct {
+	CacheTTL time.Duration // > 0 时按规范化后的查询条件在 DBS.RDS 中缓存总数，写入后随整表缓存一起失效；与 WithCache 相互独立
+
+	// Approximate 为 true 时返回估算总数：没有过滤条件时取表统计信息（MySQL information_schema.TABLES.TABLE_ROWS、
+	// PostgreSQL pg_class.reltuples），否则取执行计划的估算行数；SQLite 及估算失败时回退为精确 COUNT
+	Approximate     bool
+	ApproxThreshold int64 // 估算值小于该值时改用精确 COUNT，小表的总数仍然准确
+}
+
+// WithTotal 配置 FindPageWithTotal 的总数缓存与估算，不影响 Count
+func WithTotal(cfg TotalConfig) func(*RepoConfig) {
+	return func(c *RepoConfig) {
+		c.Total = &cfg
+	}
+}
+
+func (db *RepoDB[T]) totalCacheEnabled() bool {
+	return db.config.Total != nil && db.config.Total.CacheTTL > 0 && db.RDS != nil
+}
+
+// total FindPageWithTotal 的总数：估算 -> 总数缓存 -> 精确 COUNT（经由 Count 的读穿缓存）
+func (db *RepoDB[T]) total(tableName string, where WhereOption) (int64, errors.Error) {
+	cfg := db.config.Total
+	if cfg == nil {
+		return db.count(tableName, where)
+	}
+
+	rs := applyWhere(db.table(db.conn(), tableName), where)
+	if rs.Error != nil {
+		return 0, db.wrapErr(rs.Error)
+	}
+
+	if cfg.Approximate {
+		if n, ok := db.approxCount(rs, tableName); ok && n >= cfg.ApproxThreshold {
+			return n, nil
+		}
+	}
+
+	if !db.totalCacheEnabled() || db.noCache || db.boundTx() {
+		return db.count(tableName, where)
+	}
+
+	ctx := db.Context()
+	key, err := db.cacheKey(rs, cacheOpTotal, cacheTable(tableName))
+	if err != nil {
+		logs.Logger.Warn(err)
+		return db.count(tableName, where)
+	}
+
+	if n, err := db.RDS.Get(ctx, key).Int64(); err == nil {
+		return n, nil
+	} else if err != rds.Nil {
+		logs.Logger.Warn(err)
+		return db.count(tableName, where)
+	}
+
+	v, err, _ := db.share(key, func(ctx context.Context) (any, error) {
+		n, e := db.WithContext(ctx).Primary().count(tableName, where) // 同 cachedConn，写入缓存的总数只从主库读取
+		if e != nil {
+			return nil, e
+		}
+		if err := db.RDS.Set(ctx, key, n, cfg.CacheTTL).Err(); err != nil {
+			logs.Logger.Warn(err)
+		}
+		return n, nil
+	})
+	if err != nil {
+		return 0, err.(errors.Error)
+	}
+	return v.(int64), nil
+}
+
+// approxCount 估算 rs 的行数，ok 为 false 表示当前方言不支持或估算失败
+func (db *RepoDB[T]) approxCount(rs *gorm.DB, tableName string) (int64, bool) {
+	var list []*T
+	stmt := rs.Session(&gorm.Session{DryRun: true}).Find(&list).Statement
+	if stmt.Error != nil {
+		return 0, false
+	}
+
+	var (
+		n   int64
+		err error
+	)
+	_, filtered := stmt.Clauses["WHERE"]
+	switch dialect := Dialect(rs); {
+	case dialect == DialectSQLite:
+		return 0, false
+	case !filtered:
+		n, err = db.tableRows(rs, dialect, cacheTable(tableName))
+	case dialect == DialectPostgres:
+		n, err = explainPostgres(stmt)
+	default:
+		n, err = explainMySQL(stmt)
+	}
+	if err != nil {
+		logs.Logger.Warn(err)
+		return 0, false
+	}
+	return n, n >= 0
+}
+
+// tableRows 表统计信息中的行数；PostgreSQL 从未 ANALYZE 的表 reltuples 为 -1
+func (db *RepoDB[T]) tableRows(rs *gorm.DB, dialect, table string) (int64, error) {
+	var n sql.NullInt64
+	var err error
+	if dialect == DialectPostgres {
+		err = rs.Session(&gorm.Session{NewDB: true}).
+			Raw("SELECT reltuples::bigint FROM pg_class WHERE oid = to_regclass(?)", table).Scan(&n).Error
+	} else {
+		err = rs.Session(&gorm.Session{NewDB: true}).
+			Raw("SELECT TABLE_ROWS FROM information_schema.TABLES WHERE TABLE_SCHEMA = DATABASE() AND TABLE_NAME = ?", table).Scan(&n).Error
+	}
+	if err != nil || !n.Valid {
+		return -1, err
+	}
+	return n.Int64, nil
+}
+
+// explainMySQL 取 EXPLAIN 第一行的 rows * filtered / 100
+func explainMySQL(stmt *gorm.Statement) (int64, error) {
+	rows, err := stmt.ConnPool.QueryContext(stmt.Context, "EXPLAIN "+stmt.SQL.String(), stmt.Vars...)
+	if err != nil {
+		return -1, err
+	}
+	defer rows.Close()
+
+	cols, err := rows.Columns()
+	if err != nil || !rows.Next() {
+		return -1, err
+	}
+	values := make([]sql.NullFloat64, len(cols))
+	dest := make([]any, len(cols))
+	for i, col := range cols {
+		switch strings.ToLower(col) {
+		case "rows", "filtered":
+			dest[i] = &values[i]
+		default:
+			dest[i] = new(sql.RawBytes)
+		}
+	}
+	if err := rows.Scan(dest...); err != nil {
+		return -1, err
+	}
+
+	est, ratio := -1.0, 1.0
+	for i, col := range cols {
+		switch strings.ToLower(col) {
+		case "rows":
+			if values[i].Valid {
+				est = values[i].Float64
+			}
+		case "filtered":
+			if values[i].Valid {
+				ratio = values[i].Float64 / 100
+			}
+		}
+	}
+	if est < 0 {
+		return -1, nil
+	}
+	return int64(est * ratio), nil
+}
+
+// explainPostgres 取 EXPLAIN (FORMAT JSON) 顶层节点的 Plan Rows
+func explainPostgres(stmt *gorm.Statement) (int64, error) {
+	var raw string
+	err := stmt.ConnPool.QueryRowContext(stmt.Context, "EXPLAIN (FORMAT JSON) "+stmt.SQL.String(), stmt.Vars...).Scan(&raw)
+	if err != nil {
+		return -1, err
+	}
+
+	var plans []struct {
+		Plan struct {
+			PlanRows float64 `json:"Plan Rows"`
+		} `json:"Plan"`
+	}
+	if err := json.Unmarshal([]byte(raw), &plans); err != nil || len(plans) == 0 {
+		return -1, err
+	}
+	return int64(plans[0].Plan.PlanRows), nil
+}
@@ -0,0 +1,204 @@
+package dal
+
+import (
+	"context"
+	"math/rand"
+	"sync"
+	"time"
+
+	stdErrors "errors"
+
+	"github.com/xsda-pixel/common-infra/logs"
+
+	"gorm.io/gorm"
+)
+
+// TxRetryConfig 事务遇到死锁（1213）/ 锁等待超时（1205）时的自动重试配置
+type TxRetryConfig struct {
+	MaxRetries int           // 最大重试次数，0 表示不重试
+	BaseDelay  time.Duration // 首次重试前的等待，之后指数增长
+	MaxDelay   time.Duration // 单次等待上限
+}
+
+var defaultTxRetry = TxRetryConfig{
+	MaxRetries: 3,
+	BaseDelay:  20 * time.Millisecond,
+	MaxDelay:   500 * time.Millisecond,
+}
+
+// WithTxRetry 配置 WithTx 的重试策略
+func WithTxRetry(cfg TxRetryConfig) func(*DBS) {
+	return func(d *DBS) {
+		d.txRetry = &cfg
+	}
+}
+
+type txCtxKey struct{}
+
+// txState 一层事务（或 savepoint）的状态与回调
+type txState struct {
+	tx            *gorm.DB
+	mu            sync.Mutex
+	afterCommit   []func(context.Context)
+	afterRollback []func(context.Context)
+}
+
+// WithTx 在事务中执行 fn，fn 返回 error 或 panic 时回滚并执行 AfterRollback 回调，panic 随后继续上抛。
+// 嵌套调用（ctx 来自外层 WithTx）使用 savepoint，内层失败只回滚到 savepoint；
+// 最外层遇到死锁或锁等待超时时按 TxRetryConfig 整体重试 fn，因此 fn 需要可重入。
+// fn 内应使用传入的 ctx 与 tx，仓库通过 WithContext(ctx) 即可在同一事务中读取。
+func (d *DBS) WithTx(ctx context.Context, fn func(ctx context.Context, tx *gorm.DB) error) error {
+	if ctx == nil {
+		ctx = context.Background()
+	}
+
+	if parent, ok := ctx.Value(txCtxKey{}).(*txState); ok {
+		return d.runSavepoint(ctx, parent, fn)
+	}
+
+	cfg := d.txRetry
+	if cfg == nil {
+		cfg = &defaultTxRetry
+	}
+
+	for attempt := 0; ; attempt++ {
+		err := d.runTx(ctx, fn)
+		if err == nil || attempt >= cfg.MaxRetries || !isRetryableTxErr(err) {
+			return err
+		}
+
+		logs.Logger.Warnf("tx retry %d after: %v", attempt+1, err)
+
+		timer := time.NewTimer(txBackoff(cfg, attempt))
+		select {
+		case <-ctx.Done():
+			timer.Stop()
+			return err
+		case <-timer.C:
+		}
+	}
+}
+
+func (d *DBS) runTx(ctx context.Context, fn func(ctx context.Context, tx *gorm.DB) error) error {
+	st := &txState{}
+	txCtx := context.WithValue(ctx, txCtxKey{}, st)
+	defer st.rollbackOnPanic(ctx)
+
+	err := d.MySQL.WithContext(txCtx).Transaction(func(tx *gorm.DB) error {
+		st.tx = tx
+		return fn(txCtx, tx)
+	})
+	if err != nil {
+		st.runHooks(ctx, st.afterRollback)
+		return err
+	}
+
+	st.runHooks(ctx, st.afterCommit)
+	return nil
+}
+
+// runSavepoint 嵌套事务：成功时回调并入外层，等最外层提交/回滚后再执行；失败时立即执行本层的回滚回调
+func (d *DBS) runSavepoint(ctx context.Context, parent *txState, fn func(ctx context.Context, tx *gorm.DB) error) error {
+	st := &txState{}
+	txCtx := context.WithValue(ctx, txCtxKey{}, st)
+	defer st.rollbackOnPanic(ctx)
+
+	err := parent.tx.WithContext(txCtx).Transaction(func(tx *gorm.DB) error {
+		st.tx = tx
+		return fn(txCtx, tx)
+	})
+	if err != nil {
+		st.runHooks(ctx, st.afterRollback)
+		return err
+	}
+
+	parent.mu.Lock()
+	parent.afterCommit = append(parent.afterCommit, st.afterCommit...)
+	parent.afterRollback = append(parent.afterRollback, st.afterRollback...)
+	parent.mu.Unlock()
+	return nil
+}
+
+// rollbackOnPanic fn panic 时 gorm 回滚事务后原样上抛，不经过 err 分支；此处补执行回滚回调后继续 panic
+func (st *txState) rollbackOnPanic(ctx context.Context) {
+	if r := recover(); r != nil {
+		st.runHooks(ctx, st.afterRollback)
+		panic(r)
+	}
+}
+
+// runHooks 依次执行回调，单个回调 panic 不影响其他回调
+func (st *txState) runHooks(ctx context.Context, hooks []func(context.Context)) {
+	for _, hook := range hooks {
+		func() {
+			defer func() {
+				if r := recover(); r != nil {
+					logs.Logger.Errorf("tx hook panic: %v", r)
+				}
+			}()
+			hook(ctx)
+		}()
+	}
+}
+
+// AfterCommit 注册事务真正提交后执行的回调（如失效缓存、发送事件）。
+// ctx 不在 WithTx 中时立即执行。
+func AfterCommit(ctx context.Context, fn func(ctx context.Context)) {
+	st, ok := txStateFrom(ctx)
+	if !ok {
+		fn(ctx)
+		return
+	}
+	st.mu.Lock()
+	st.afterCommit = append(st.afterCommit, fn)
+	st.mu.Unlock()
+}
+
+// AfterRollback 注册事务回滚后执行的回调；ctx 不在 WithTx 中时不会执行
+func AfterRollback(ctx context.Context, fn func(ctx context.Context)) {
+	st, ok := txStateFrom(ctx)
+	if !ok {
+		return
+	}
+	st.mu.Lock()
+	st.afterRollback = append(st.afterRollback, fn)
+	st.mu.Unlock()
+}
+
+// TxFromContext 返回 ctx 所在 WithTx 的事务连接
+func TxFromContext(ctx context.Context) (*gorm.DB, bool) {
+	st, ok := txStateFrom(ctx)
+	if !ok || st.tx == nil {
+		return nil, false
+	}
+	return st.tx, true
+}
+
+func txStateFrom(ctx context.Context) (*txState, bool) {
+	if ctx == nil {
+		return nil, false
+	}
+	st, ok := ctx.Value(txCtxKey{}).(*txState)
+	return st, ok
+}
+
+// isRetryableTxErr 死锁与锁等待超时可以整体重试
+func isRetryableTxErr(err error) bool {
+	if stdErrors.Is(err, ErrDeadlock) || stdErrors.Is(err, ErrLockWaitTimeout) {
+		return true
+	}
+	n, ok := mysqlErrNumber(err)
+	return ok && (n == mysqlErrDeadlock || n == mysqlErrLockWaitTimeout)
+}
+
+func txBackoff(cfg *TxRetryConfig, attempt int) time.Duration {
+	delay := cfg.BaseDelay << attempt
+	if delay <= 0 || (cfg.MaxDelay > 0 && delay > cfg.MaxDelay) {
+		delay = cfg.MaxDelay
+	}
+	if delay <= 0 {
+		return 0
+	}
+	// 加入随机抖动，避免冲突双方同时重试再次死锁
+	return delay/2 + time.Duration(rand.Int63n(int64(delay/2)+1))
+}
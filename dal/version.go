@@ -0,0 +1,142 @@
+package dal
+
+import (
+	"net/http"
+	"reflect"
+
+	stdErrors "errors"
+
+	"github.com/xsda-pixel/common-infra/errors"
+
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+const defaultVersionColumn = "version"
+
+var (
+	ErrVersionConflict = errors.NewError(http.StatusConflict, errors.NewMsg("version conflict"))
+
+	errVersionNotFound = errors.NewError(http.StatusNotFound, errors.NewMsg("record not found"))
+)
+
+// WithVersionColumn 配置乐观锁版本列，默认 version
+func WithVersionColumn(column string) func(*RepoConfig) {
+	return func(c *RepoConfig) {
+		c.VersionColumn = column
+	}
+}
+
+func (db *RepoDB[T]) versionColumn() string {
+	if db.config.VersionColumn != "" {
+		return db.config.VersionColumn
+	}
+	return defaultVersionColumn
+}
+
+// UpdateWithVersion 乐观锁更新：在 where 上追加 version = ?，同时将 version 原子加 1。
+// 没有行被更新时返回 ErrVersionConflict（409），表示数据已被他人修改或不存在。
+func (db *RepoDB[T]) UpdateWithVersion(
+	dbs *gorm.DB,
+	tableName string,
+	where WhereOption,
+	version int64,
+	updates map[string]any,
+) (int64, errors.Error) {
+	if len(updates) == 0 {
+		return 0, nil
+	}
+
+	col := db.versionColumn()
+
+	// 复制一份，不修改调用方的 map
+	values := make(map[string]any, len(updates)+1)
+	for k, v := range updates {
+		values[k] = v
+	}
+	values[col] = gorm.Expr("? + 1", clause.Column{Name: col})
+
+	where.Cond = And(where.Cond, Eq(col, version))
+
+	rows, err := db.Update(dbs, tableName, where, values)
+	if err != nil {
+		return 0, err
+	}
+	if rows == 0 {
+		return 0, ErrVersionConflict
+	}
+	return rows, nil
+}
+
+// UpdateWithVersionRetry 读取最新行 -> mutate 计算更新 -> UpdateWithVersion，版本冲突时重新读取再试，最多重试 maxRetries 次。
+// 读取与更新都在 dbs 上执行（dbs 为事务时能读到本事务已写入的数据），不经过缓存与从库；mutate 返回空 updates 时不做更新。
+// 不建议在持有大量锁的长事务中调用。
+func (db *RepoDB[T]) UpdateWithVersionRetry(
+	dbs *gorm.DB,
+	tableName string,
+	where WhereOption,
+	maxRetries int,
+	mutate func(item *T) (map[string]any, errors.Error),
+) (int64, errors.Error) {
+	sch, err := db.modelSchema()
+	if err != nil {
+		return 0, db.wrapErr(err)
+	}
+	field := lookupField(sch, db.versionColumn())
+	if field == nil {
+		return 0, errors.NewError(http.StatusBadRequest, errors.NewMsg("version column %s not found in model", db.versionColumn()))
+	}
+
+	for attempt := 0; ; attempt++ {
+		item, e := db.latest(dbs, tableName, where)
+		if e != nil {
+			return 0, e
+		}
+		if item == nil {
+			return 0, errVersionNotFound
+		}
+
+		version, ok := toInt64(db.fieldValue(field, item))
+		if !ok {
+			return 0, errors.NewError(http.StatusBadRequest, errors.NewMsg("version column %s is not an integer", db.versionColumn()))
+		}
+
+		updates, e := mutate(item)
+		if e != nil {
+			return 0, e
+		}
+
+		rows, e := db.UpdateWithVersion(dbs, tableName, where, version, updates)
+		if !stdErrors.Is(e, ErrVersionConflict) || attempt >= maxRetries {
+			return rows, e
+		}
+	}
+}
+
+// latest UpdateWithVersionRetry 的读取：以 OpFindOne 经过拦截器，Operation.DB 为 dbs
+func (db *RepoDB[T]) latest(dbs *gorm.DB, tableName string, where WhereOption) (*T, errors.Error) {
+	op := &Operation{Kind: OpFindOne, DB: dbs, Table: tableName, Where: where}
+	return intercept(db, op, func(op *Operation) (*T, errors.Error) {
+		var item T
+		rs := applyWhere(db.table(db.bind(op.DB), op.Table), op.Where)
+		if err := rs.Take(&item).Error; err != nil {
+			if stdErrors.Is(err, gorm.ErrRecordNotFound) {
+				return nil, nil
+			}
+			return nil, db.wrapErr(err)
+		}
+		return &item, nil
+	})
+}
+
+func toInt64(v any) (int64, bool) {
+	rv := reflect.Indirect(reflect.ValueOf(v))
+	switch rv.Kind() {
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		return rv.Int(), true
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		return int64(rv.Uint()), true
+	default:
+		return 0, false
+	}
+}
@@ -15,6 +15,22 @@ func NewError(code int, msg Msg) Error {
 	return &err{Code: code, Msg: msg}
 }
 
+// WithCause 返回携带底层原因的副本，Code / Msg 与 e 相同。
+// 原因仅用于日志与 errors.Is / errors.As，副本与 e 满足 errors.Is(副本, e)。
+func WithCause(e Error, cause error) Error {
+	if e == nil {
+		return nil
+	}
+	base, ok := e.(*err)
+	if !ok {
+		return e
+	}
+	if base.base != nil {
+		base = base.base
+	}
+	return &err{Code: base.Code, Msg: base.Msg, cause: cause, base: base}
+}
+
 func (m Msg) String() string {
 	if len(m.Args) == 0 {
 		return m.Str
@@ -30,8 +46,10 @@ type Error interface {
 }
 
 type err struct {
-	Code int
-	Msg  Msg
+	Code  int
+	Msg   Msg
+	cause error
+	base  *err // WithCause 的来源，用于 errors.Is 比较
 }
 
 func (e *err) i() {}
@@ -47,3 +65,12 @@ func (e *err) ErrMsg() Msg {
 func (e *err) Error() string {
 	return e.Msg.String()
 }
+
+func (e *err) Unwrap() error {
+	return e.cause
+}
+
+func (e *err) Is(target error) bool {
+	t, ok := target.(*err)
+	return ok && e.base != nil && e.base == t
+}
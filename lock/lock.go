@@ -0,0 +1,300 @@
+// Package lock 基于 Redis 的分布式锁：
+//
+//	l, err := lock.New(dbs.RDS).Lock(ctx, "job:settle", 30*time.Second)
+//	if err != nil { ... }
+//	defer l.Unlock(context.Background())
+//	// 写入下游存储时带上 l.Token()，存储侧拒绝小于已见最大值的 token，防止锁过期后旧持有者的延迟写入
+//
+// 每次加锁生成唯一的持有者标识，续期与释放通过 Lua 校验持有者，不会误删他人的锁；
+// 持有期间 watchdog 每 ttl/3 续期一次，续期失败（锁已过期被他人获取、Redis 长时间不可用）时关闭 Lost()。
+package lock
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	stdErrors "errors"
+	mathrand "math/rand"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/xsda-pixel/common-infra/errors"
+	"github.com/xsda-pixel/common-infra/logs"
+
+	rds "github.com/redis/go-redis/v9"
+)
+
+const (
+	defaultPrefix    = "lock"
+	defaultRetryBase = 50 * time.Millisecond
+	defaultRetryMax  = time.Second
+)
+
+var (
+	ErrNotAcquired = errors.NewError(http.StatusConflict, errors.NewMsg("lock: not acquired"))
+	ErrNotHeld     = errors.NewError(http.StatusConflict, errors.NewMsg("lock: not held"))
+	ErrInvalidTTL  = errors.NewError(http.StatusBadRequest, errors.NewMsg("lock: ttl must be at least 1ms"))
+	ErrRedis       = errors.NewError(http.StatusInternalServerError, errors.NewMsg("lock: redis error"))
+)
+
+var (
+	// KEYS[1] 锁，KEYS[2] fencing 计数器；加锁成功时返回递增后的 token，否则返回 0
+	acquireScript = rds.NewScript(`
+if redis.call("SET", KEYS[1], ARGV[1], "NX", "PX", ARGV[2]) then
+	return redis.call("INCR", KEYS[2])
+end
+return 0`)
+	refreshScript = rds.NewScript(`
+if redis.call("GET", KEYS[1]) == ARGV[1] then
+	return redis.call("PEXPIRE", KEYS[1], ARGV[2])
+end
+return 0`)
+	releaseScript = rds.NewScript(`
+if redis.call("GET", KEYS[1]) == ARGV[1] then
+	return redis.call("DEL", KEYS[1])
+end
+return 0`)
+)
+
+// Config 锁配置
+type Config struct {
+	Prefix    string        // key 前缀，默认 lock；锁 key 为 <Prefix>:{<key>}，fencing 计数器为 <Prefix>:{<key>}:fence
+	RetryBase time.Duration // 阻塞加锁的初始退避，按次数翻倍并加随机抖动，默认 50ms
+	RetryMax  time.Duration // 阻塞加锁的最大退避，默认 1s
+	Watchdog  bool          // 持有期间自动续期，默认开启
+	RawKey    bool          // 直接以 key 作为锁 key（fencing 计数器为 <key>:fence），忽略 Prefix
+}
+
+// Locker 锁工厂，可在多个 goroutine 间共享
+type Locker struct {
+	client *rds.Client
+	cfg    Config
+}
+
+func New(client *rds.Client, opts ...func(*Config)) *Locker {
+	cfg := Config{
+		Prefix:    defaultPrefix,
+		RetryBase: defaultRetryBase,
+		RetryMax:  defaultRetryMax,
+		Watchdog:  true,
+	}
+	for _, opt := range opts {
+		opt(&cfg)
+	}
+	return &Locker{client: client, cfg: cfg}
+}
+
+func WithPrefix(prefix string) func(*Config) {
+	return func(c *Config) {
+		if prefix != "" {
+			c.Prefix = prefix
+		}
+	}
+}
+
+// WithRawKey 不加前缀直接使用 key，用于与按原 key 加锁的旧实现互斥
+func WithRawKey() func(*Config) {
+	return func(c *Config) {
+		c.RawKey = true
+	}
+}
+
+// WithRetry 配置阻塞加锁的退避区间
+func WithRetry(base, max time.Duration) func(*Config) {
+	return func(c *Config) {
+		if base > 0 {
+			c.RetryBase = base
+		}
+		if max > 0 {
+			c.RetryMax = max
+		}
+	}
+}
+
+// WithoutWatchdog 关闭自动续期，锁在 ttl 后过期，需要时由调用方 Refresh
+func WithoutWatchdog() func(*Config) {
+	return func(c *Config) {
+		c.Watchdog = false
+	}
+}
+
+// Lock 已持有的锁
+type Lock struct {
+	locker *Locker
+	key    string
+	owner  string
+	token  int64
+	ttl    time.Duration
+
+	stopOnce sync.Once
+	stop     chan struct{}
+	done     chan struct{}
+	lost     chan struct{}
+}
+
+// TryLock 尝试加锁一次，锁被占用时返回 ErrNotAcquired
+func (l *Locker) TryLock(ctx context.Context, key string, ttl time.Duration) (*Lock, errors.Error) {
+	if ttl < time.Millisecond {
+		return nil, ErrInvalidTTL
+	}
+
+	lockKey := l.lockKey(key)
+	owner := newOwner()
+	token, err := acquireScript.Run(ctx, l.client, []string{lockKey, lockKey + ":fence"}, owner, ttl.Milliseconds()).Int64()
+	if err != nil {
+		return nil, errors.WithCause(ErrRedis, err)
+	}
+	if token == 0 {
+		return nil, ErrNotAcquired
+	}
+
+	lk := &Lock{
+		locker: l,
+		key:    lockKey,
+		owner:  owner,
+		token:  token,
+		ttl:    ttl,
+		stop:   make(chan struct{}),
+		done:   make(chan struct{}),
+		lost:   make(chan struct{}),
+	}
+	if l.cfg.Watchdog {
+		go lk.watchdog()
+	} else {
+		close(lk.done)
+	}
+	return lk, nil
+}
+
+// Lock 阻塞加锁，锁被占用时按指数退避重试直到成功或 ctx 结束；ctx 结束时返回携带 ctx.Err() 的 ErrNotAcquired
+func (l *Locker) Lock(ctx context.Context, key string, ttl time.Duration) (*Lock, errors.Error) {
+	for attempt := 0; ; attempt++ {
+		lk, err := l.TryLock(ctx, key, ttl)
+		if err == nil || !stdErrors.Is(err, ErrNotAcquired) {
+			return lk, err
+		}
+
+		timer := time.NewTimer(l.backoff(attempt))
+		select {
+		case <-ctx.Done():
+			timer.Stop()
+			return nil, errors.WithCause(ErrNotAcquired, ctx.Err())
+		case <-timer.C:
+		}
+	}
+}
+
+func (l *Locker) lockKey(key string) string {
+	if l.cfg.RawKey {
+		return key
+	}
+	return l.cfg.Prefix + ":{" + key + "}"
+}
+
+// backoff 第 attempt 次重试前的等待：min(RetryMax, RetryBase*2^attempt) 的 [1/2, 1] 区间内随机
+func (l *Locker) backoff(attempt int) time.Duration {
+	d := l.cfg.RetryMax
+	if attempt < 30 {
+		if exp := l.cfg.RetryBase << attempt; exp > 0 && exp < d {
+			d = exp
+		}
+	}
+	half := d / 2
+	if half <= 0 {
+		return d
+	}
+	return half + time.Duration(mathrand.Int63n(int64(half)+1))
+}
+
+// Key 锁在 Redis 中的完整 key
+func (lk *Lock) Key() string {
+	return lk.key
+}
+
+// Owner 本次加锁的唯一持有者标识
+func (lk *Lock) Owner() string {
+	return lk.owner
+}
+
+// Token fencing token，同一 key 每次加锁成功单调递增
+func (lk *Lock) Token() int64 {
+	return lk.token
+}
+
+// Lost watchdog 确认锁已丢失时关闭；未开启 watchdog 时永不关闭
+func (lk *Lock) Lost() <-chan struct{} {
+	return lk.lost
+}
+
+// Refresh 将锁的过期时间重置为 ttl，锁已不属于自己时返回 ErrNotHeld
+func (lk *Lock) Refresh(ctx context.Context, ttl time.Duration) errors.Error {
+	if ttl < time.Millisecond {
+		return ErrInvalidTTL
+	}
+	ok, err := refreshScript.Run(ctx, lk.locker.client, []string{lk.key}, lk.owner, ttl.Milliseconds()).Int64()
+	if err != nil {
+		return errors.WithCause(ErrRedis, err)
+	}
+	if ok == 0 {
+		return ErrNotHeld
+	}
+	return nil
+}
+
+// Unlock 停止续期并释放锁；锁已过期或被他人持有时返回 ErrNotHeld，不会删除他人的锁
+func (lk *Lock) Unlock(ctx context.Context) errors.Error {
+	lk.stopOnce.Do(func() { close(lk.stop) })
+	<-lk.done
+
+	ok, err := releaseScript.Run(ctx, lk.locker.client, []string{lk.key}, lk.owner).Int64()
+	if err != nil {
+		return errors.WithCause(ErrRedis, err)
+	}
+	if ok == 0 {
+		return ErrNotHeld
+	}
+	return nil
+}
+
+// watchdog 每 ttl/3 续期一次；锁已不属于自己，或距上次续期成功超过 ttl 时判定丢失
+func (lk *Lock) watchdog() {
+	defer close(lk.done)
+
+	interval := lk.ttl / 3
+	if interval <= 0 {
+		interval = lk.ttl
+	}
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	extended := time.Now()
+	for {
+		select {
+		case <-lk.stop:
+			return
+		case <-ticker.C:
+		}
+
+		ctx, cancel := context.WithTimeout(context.Background(), interval)
+		err := lk.Refresh(ctx, lk.ttl)
+		cancel()
+
+		switch {
+		case err == nil:
+			extended = time.Now()
+		case stdErrors.Is(err, ErrNotHeld) || time.Since(extended) >= lk.ttl:
+			logs.Logger.Warnf("lock %s lost: %v", lk.key, err)
+			close(lk.lost)
+			return
+		default:
+			logs.Logger.Warnf("lock %s refresh: %v", lk.key, err)
+		}
+	}
+}
+
+func newOwner() string {
+	b := make([]byte, 16)
+	_, _ = rand.Read(b)
+	return hex.EncodeToString(b)
+}
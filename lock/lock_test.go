@@ -0,0 +1,201 @@
+package lock
+
+import (
+	"context"
+	stdErrors "errors"
+	"os"
+	"testing"
+	"time"
+
+	"github.com/xsda-pixel/common-infra/logs"
+
+	"github.com/alicebob/miniredis/v2"
+	rds "github.com/redis/go-redis/v9"
+)
+
+func TestMain(m *testing.M) {
+	dir, err := os.MkdirTemp("", "lock-logs-")
+	if err != nil {
+		panic(err)
+	}
+	logs.LogFilePath = dir + "/"
+	if err := logs.Init(false, false, false); err != nil {
+		panic(err)
+	}
+
+	code := m.Run()
+	_ = os.RemoveAll(dir)
+	os.Exit(code)
+}
+
+func newLocker(t *testing.T, opts ...func(*Config)) (*Locker, *miniredis.Miniredis) {
+	t.Helper()
+	mr := miniredis.RunT(t)
+	client := rds.NewClient(&rds.Options{Addr: mr.Addr()})
+	t.Cleanup(func() { _ = client.Close() })
+	return New(client, opts...), mr
+}
+
+func TestTryLockContention(t *testing.T) {
+	l, _ := newLocker(t)
+	ctx := context.Background()
+
+	a, err := l.TryLock(ctx, "job", time.Second)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer a.Unlock(ctx)
+
+	if _, err := l.TryLock(ctx, "job", time.Second); !stdErrors.Is(err, ErrNotAcquired) {
+		t.Fatalf("second TryLock error = %v, want ErrNotAcquired", err)
+	}
+	if _, err := l.TryLock(ctx, "other", time.Second); err != nil {
+		t.Fatalf("TryLock on another key: %v", err)
+	}
+	if _, err := l.TryLock(ctx, "job", 0); !stdErrors.Is(err, ErrInvalidTTL) {
+		t.Fatalf("TryLock with zero ttl error = %v, want ErrInvalidTTL", err)
+	}
+}
+
+func TestRawKey(t *testing.T) {
+	l, mr := newLocker(t, WithRawKey())
+	ctx := context.Background()
+
+	// 旧实现以 SET NX 占用同名 key 时不能加锁
+	mr.Set("migrate:schema", "legacy")
+	if _, err := l.TryLock(ctx, "migrate:schema", time.Second); !stdErrors.Is(err, ErrNotAcquired) {
+		t.Fatalf("TryLock on legacy-held key error = %v, want ErrNotAcquired", err)
+	}
+	mr.Del("migrate:schema")
+
+	a, err := l.TryLock(ctx, "migrate:schema", time.Second)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer a.Unlock(ctx)
+	if a.Key() != "migrate:schema" {
+		t.Fatalf("Key() = %q, want migrate:schema", a.Key())
+	}
+}
+
+func TestLockWaitsWithBackoff(t *testing.T) {
+	l, _ := newLocker(t, WithRetry(10*time.Millisecond, 50*time.Millisecond))
+	ctx := context.Background()
+
+	a, err := l.TryLock(ctx, "job", time.Second)
+	if err != nil {
+		t.Fatal(err)
+	}
+	go func() {
+		time.Sleep(150 * time.Millisecond)
+		_ = a.Unlock(ctx)
+	}()
+
+	start := time.Now()
+	b, err := l.Lock(ctx, "job", time.Second)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer b.Unlock(ctx)
+	if waited := time.Since(start); waited < 100*time.Millisecond {
+		t.Fatalf("Lock returned after %s, before the holder released", waited)
+	}
+}
+
+func TestLockCanceled(t *testing.T) {
+	l, _ := newLocker(t)
+	a, err := l.TryLock(context.Background(), "job", time.Second)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer a.Unlock(context.Background())
+
+	ctx, cancel := context.WithTimeout(context.Background(), 100*time.Millisecond)
+	defer cancel()
+	_, err = l.Lock(ctx, "job", time.Second)
+	if !stdErrors.Is(err, ErrNotAcquired) || !stdErrors.Is(err, context.DeadlineExceeded) {
+		t.Fatalf("Lock error = %v, want ErrNotAcquired caused by DeadlineExceeded", err)
+	}
+}
+
+func TestUnlockKeepsOtherOwner(t *testing.T) {
+	l, mr := newLocker(t, WithoutWatchdog())
+	ctx := context.Background()
+
+	a, err := l.TryLock(ctx, "job", 100*time.Millisecond)
+	if err != nil {
+		t.Fatal(err)
+	}
+	mr.FastForward(200 * time.Millisecond) // a 过期
+
+	b, err := l.TryLock(ctx, "job", time.Second)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := a.Unlock(ctx); !stdErrors.Is(err, ErrNotHeld) {
+		t.Fatalf("stale Unlock error = %v, want ErrNotHeld", err)
+	}
+	if got, _ := mr.Get(b.Key()); got != b.Owner() {
+		t.Fatalf("lock owner after stale Unlock = %q, want %q", got, b.Owner())
+	}
+	if err := b.Unlock(ctx); err != nil {
+		t.Fatal(err)
+	}
+	if mr.Exists(b.Key()) {
+		t.Fatal("lock still exists after Unlock")
+	}
+}
+
+func TestWatchdogRenewsAndReportsLost(t *testing.T) {
+	l, mr := newLocker(t)
+	ctx := context.Background()
+	ttl := 300 * time.Millisecond
+
+	a, err := l.TryLock(ctx, "job", ttl)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// 持有超过 ttl 后锁仍在，且 TTL 被续期
+	time.Sleep(2 * ttl)
+	mr.FastForward(ttl / 2)
+	if got, _ := mr.Get(a.Key()); got != a.Owner() {
+		t.Fatalf("lock owner after %s = %q, want %q", 2*ttl, got, a.Owner())
+	}
+	select {
+	case <-a.Lost():
+		t.Fatal("Lost closed while the lock is held")
+	default:
+	}
+
+	// 锁被他人占有后 watchdog 判定丢失
+	mr.Set(a.Key(), "someone-else")
+	select {
+	case <-a.Lost():
+	case <-time.After(2 * ttl):
+		t.Fatal("Lost not closed after the lock was taken over")
+	}
+	if err := a.Unlock(ctx); !stdErrors.Is(err, ErrNotHeld) {
+		t.Fatalf("Unlock after lost error = %v, want ErrNotHeld", err)
+	}
+}
+
+func TestFencingTokenIncreases(t *testing.T) {
+	l, _ := newLocker(t)
+	ctx := context.Background()
+
+	var prev int64
+	for i := 0; i < 3; i++ {
+		lk, err := l.TryLock(ctx, "job", time.Second)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if lk.Token() <= prev {
+			t.Fatalf("token %d not greater than previous %d", lk.Token(), prev)
+		}
+		prev = lk.Token()
+		if err := lk.Unlock(ctx); err != nil {
+			t.Fatal(err)
+		}
+	}
+}
@@ -0,0 +1,108 @@
+package migrate
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"strconv"
+	"text/tabwriter"
+
+	"github.com/xsda-pixel/common-infra/errors"
+)
+
+// Usage 命令行用法
+const Usage = `commands:
+  status      列出全部迁移及其状态
+  up [N]      执行未执行的迁移，N 省略时执行全部
+  down N      回滚最近执行的 N 个迁移
+  redo        回滚并重新执行最近执行的一个迁移`
+
+// Command 执行命令行 status | up [N] | down N | redo，结果写到 w。
+// cmd/migrate 只能加载 SQL 迁移，包含 Go 迁移的服务可在自己的 main 中构造 Migrator 后调用它
+func Command(ctx context.Context, m *Migrator, args []string, w io.Writer) errors.Error {
+	if len(args) == 0 {
+		return invalid("migrate: missing command\n%s", Usage)
+	}
+
+	cmd, rest := args[0], args[1:]
+	n, err := countArg(cmd, rest)
+	if err != nil {
+		return err
+	}
+
+	switch cmd {
+	case "status":
+		states, err := m.Status(ctx)
+		if err != nil {
+			return err
+		}
+		printStatus(w, states)
+		return nil
+
+	case "up":
+		versions, err := m.Up(ctx, n)
+		printVersions(w, m.cfg.DryRun, "applied", versions)
+		return err
+
+	case "down":
+		if n <= 0 {
+			return invalid("migrate: down requires N > 0")
+		}
+		versions, err := m.Down(ctx, n)
+		printVersions(w, m.cfg.DryRun, "rolled back", versions)
+		return err
+
+	case "redo":
+		version, err := m.Redo(ctx)
+		if version > 0 {
+			printVersions(w, m.cfg.DryRun, "redone", []int64{version})
+		} else if err == nil {
+			fmt.Fprintln(w, "no applied migration")
+		}
+		return err
+
+	default:
+		return invalid("migrate: unknown command %s\n%s", cmd, Usage)
+	}
+}
+
+// countArg 解析 up / down 的 N 参数
+func countArg(cmd string, rest []string) (int, errors.Error) {
+	switch {
+	case len(rest) == 0:
+		return 0, nil
+	case len(rest) > 1 || (cmd != "up" && cmd != "down"):
+		return 0, invalid("migrate: too many arguments for %s", cmd)
+	}
+	n, err := strconv.Atoi(rest[0])
+	if err != nil || n < 0 {
+		return 0, invalid("migrate: invalid N %q", rest[0])
+	}
+	return n, nil
+}
+
+func printStatus(w io.Writer, states []Status) {
+	tw := tabwriter.NewWriter(w, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(tw, "VERSION\tNAME\tSTATE\tAPPLIED AT")
+	for _, s := range states {
+		applied := "-"
+		if s.AppliedAt != nil {
+			applied = s.AppliedAt.Format("2006-01-02 15:04:05")
+		}
+		fmt.Fprintf(tw, "%d\t%s\t%s\t%s\n", s.Version, s.Name, s.State, applied)
+	}
+	_ = tw.Flush()
+}
+
+func printVersions(w io.Writer, dryRun bool, action string, versions []int64) {
+	if dryRun {
+		return
+	}
+	if len(versions) == 0 {
+		fmt.Fprintf(w, "nothing %s\n", action)
+		return
+	}
+	for _, v := range versions {
+		fmt.Fprintf(w, "%s %d\n", action, v)
+	}
+}
@@ -0,0 +1,63 @@
+package migrate
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"time"
+
+	"github.com/xsda-pixel/common-infra/errors"
+
+	"gorm.io/gorm"
+	"gorm.io/gorm/logger"
+)
+
+// dryRun 输出迁移将要执行的语句：SQL 迁移原样拆分输出，Go 迁移在 DryRun 会话中运行并输出 gorm 生成的语句
+func (m *Migrator) dryRun(ctx context.Context, mig *Migration, up bool) errors.Error {
+	w := m.cfg.Output
+	direction := "up"
+	if !up {
+		direction = "down"
+	}
+	fmt.Fprintf(w, "-- %s %d %s\n", direction, mig.Version, mig.Name)
+
+	fn, sql := mig.Up, mig.UpSQL
+	if !up {
+		fn, sql = mig.Down, mig.DownSQL
+	}
+	if fn != nil {
+		tx := m.dbs.MySQL.Session(&gorm.Session{DryRun: true, Logger: sqlPrinter{w: w}, Context: ctx})
+		if err := fn(tx); err != nil {
+			return wrapErr(ctx, fmt.Errorf("migrate dry-run %s %d %s: %w", direction, mig.Version, mig.Name, err))
+		}
+	} else {
+		for _, stmt := range SplitStatements(sql) {
+			fmt.Fprintf(w, "%s;\n", stmt)
+		}
+	}
+
+	if up {
+		fmt.Fprintf(w, "-- record version %d in %s\n\n", mig.Version, m.cfg.Table)
+	} else {
+		fmt.Fprintf(w, "-- remove version %d from %s\n\n", mig.Version, m.cfg.Table)
+	}
+	return nil
+}
+
+// sqlPrinter 把 DryRun 会话生成的语句写到 w
+type sqlPrinter struct {
+	w io.Writer
+}
+
+func (p sqlPrinter) LogMode(logger.LogLevel) logger.Interface { return p }
+
+func (p sqlPrinter) Info(context.Context, string, ...any) {}
+
+func (p sqlPrinter) Warn(context.Context, string, ...any) {}
+
+func (p sqlPrinter) Error(context.Context, string, ...any) {}
+
+func (p sqlPrinter) Trace(_ context.Context, _ time.Time, fc func() (string, int64), _ error) {
+	sql, _ := fc()
+	fmt.Fprintf(p.w, "%s;\n", sql)
+}
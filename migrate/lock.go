@@ -0,0 +1,161 @@
+package migrate
+
+import (
+	"context"
+	"database/sql"
+	stdErrors "errors"
+	"hash/fnv"
+	"math"
+	"net/http"
+	"time"
+
+	"github.com/xsda-pixel/common-infra/dal"
+	"github.com/xsda-pixel/common-infra/errors"
+	"github.com/xsda-pixel/common-infra/lock"
+	"github.com/xsda-pixel/common-infra/logs"
+)
+
+// 锁方式
+const (
+	LockDB    = "db"    // 数据库 advisory lock：MySQL GET_LOCK / PostgreSQL pg_advisory_lock，SQLite 不加锁
+	LockRedis = "redis" // DBS.RDS 上的 lock 包分布式锁，持有期间自动续期
+	LockNone  = "none"  // 不加锁，由调用方保证只有一个实例在迁移
+)
+
+const lockPollInterval = 500 * time.Millisecond
+
+var (
+	ErrLocked   = errors.NewError(http.StatusConflict, errors.NewMsg("migrate: lock is held by another instance"))
+	ErrNoRedis  = errors.NewError(http.StatusInternalServerError, errors.NewMsg("migrate: redis lock requires DBS.RDS"))
+	ErrLockLost = errors.NewError(http.StatusConflict, errors.NewMsg("migrate: lock lost during migration"))
+)
+
+// lock 按 LockMode 加锁，返回持有锁期间使用的 ctx；返回的 unlock 不会失败（释放失败只记录日志，连接关闭或 TTL 到期后锁自然释放）
+func (m *Migrator) lock(ctx context.Context) (context.Context, func(), errors.Error) {
+	switch m.cfg.LockMode {
+	case LockNone:
+		return ctx, func() {}, nil
+	case LockRedis:
+		return m.redisLock(ctx)
+	case LockDB, "":
+		unlock, err := m.dbLock(ctx)
+		return ctx, unlock, err
+	default:
+		return nil, nil, invalid("migrate: unknown lock mode %s", m.cfg.LockMode)
+	}
+}
+
+// dbLock advisory lock 绑定在会话上，因此单独取一个连接持有锁直到迁移结束
+func (m *Migrator) dbLock(ctx context.Context) (func(), errors.Error) {
+	var acquire, release string
+	var args []any
+	switch dal.Dialect(m.dbs.MySQL) {
+	case dal.DialectSQLite:
+		return func() {}, nil
+	case dal.DialectPostgres:
+		key := lockKey(m.cfg.LockName)
+		acquire, release, args = "SELECT pg_try_advisory_lock($1)", "SELECT pg_advisory_unlock($1)", []any{key}
+	default:
+		acquire, release, args = "SELECT GET_LOCK(?, 0)", "SELECT RELEASE_LOCK(?)", []any{m.cfg.LockName}
+	}
+
+	sqlDB, err := m.dbs.MySQL.DB()
+	if err != nil {
+		return nil, wrapErr(ctx, err)
+	}
+	conn, err := sqlDB.Conn(ctx)
+	if err != nil {
+		return nil, wrapErr(ctx, err)
+	}
+
+	// GET_LOCK 超时为 0 时立即返回，与 pg_try_advisory_lock 一样由这里轮询，便于响应 ctx 取消
+	e := poll(ctx, m.cfg.LockTimeout, func() (bool, error) {
+		var ok sql.NullBool
+		if err := conn.QueryRowContext(ctx, acquire, args...).Scan(&ok); err != nil {
+			return false, err
+		}
+		return ok.Valid && ok.Bool, nil
+	})
+	if e != nil {
+		_ = conn.Close()
+		return nil, e
+	}
+
+	return func() {
+		if _, err := conn.ExecContext(context.WithoutCancel(ctx), release, args...); err != nil {
+			logs.Logger.Warnf("migrate: release lock %s: %v", m.cfg.LockName, err)
+		}
+		_ = conn.Close()
+	}, nil
+}
+
+// redisLock 基于 lock 包加锁，等待超过 LockTimeout 时返回 ErrLocked。
+// 锁 key 直接使用 LockName，与旧版本的 SET NX 实现互斥；续期确认锁已丢失时取消返回的 ctx，
+// 正在执行的迁移随之中断，由 locked 返回 ErrLockLost
+func (m *Migrator) redisLock(ctx context.Context) (context.Context, func(), errors.Error) {
+	if m.dbs.RDS == nil {
+		return nil, nil, ErrNoRedis
+	}
+
+	waitCtx, cancel := context.WithTimeout(ctx, m.cfg.LockTimeout)
+	defer cancel()
+	l, err := lock.New(m.dbs.RDS, lock.WithRawKey()).Lock(waitCtx, m.cfg.LockName, m.cfg.LockTTL)
+	if err != nil {
+		if stdErrors.Is(err, lock.ErrNotAcquired) {
+			if ctx.Err() != nil {
+				return nil, nil, wrapErr(ctx, ctx.Err())
+			}
+			return nil, nil, ErrLocked
+		}
+		return nil, nil, err
+	}
+
+	heldCtx, abort := context.WithCancelCause(ctx)
+	go func() {
+		select {
+		case <-l.Lost():
+			logs.Logger.Errorf("migrate: lock %s lost, aborting migration", m.cfg.LockName)
+			abort(ErrLockLost)
+		case <-heldCtx.Done():
+		}
+	}()
+
+	return heldCtx, func() {
+		abort(nil)
+		if err := l.Unlock(context.WithoutCancel(ctx)); err != nil {
+			logs.Logger.Warnf("migrate: release lock %s: %v", m.cfg.LockName, err)
+		}
+	}, nil
+}
+
+// poll 反复调用 try 直到成功、出错、ctx 结束或超过 timeout（返回 ErrLocked）
+func poll(ctx context.Context, timeout time.Duration, try func() (bool, error)) errors.Error {
+	deadline := time.Now().Add(timeout)
+	for {
+		ok, err := try()
+		if err != nil {
+			return wrapErr(ctx, err)
+		}
+		if ok {
+			return nil
+		}
+		if !time.Now().Before(deadline) {
+			return ErrLocked
+		}
+
+		timer := time.NewTimer(lockPollInterval)
+		select {
+		case <-ctx.Done():
+			timer.Stop()
+			return wrapErr(ctx, ctx.Err())
+		case <-timer.C:
+		}
+	}
+}
+
+// lockKey PostgreSQL advisory lock 使用 bigint 键
+func lockKey(name string) int64 {
+	h := fnv.New64a()
+	_, _ = h.Write([]byte(name))
+	return int64(h.Sum64() & math.MaxInt64)
+}
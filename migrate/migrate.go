@@ -0,0 +1,457 @@
+// Package migrate 基于 DBS.MySQL 的版本化表结构迁移：按版本号顺序执行 SQL 或 Go 迁移，
+// 在版本表中记录已执行的版本与校验和，执行期间持有数据库 advisory lock（或 DBS.RDS 上的 Redis 锁），保证同一时刻只有一个实例在迁移。
+//
+//	migrations, err := migrate.LoadFS(embedFS, "migrations")
+//	m, err := migrate.New(dbs, append(migrations, goMigrations...))
+//	applied, err := m.Up(ctx, 0)
+package migrate
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	stdErrors "errors"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/xsda-pixel/common-infra/dal"
+	"github.com/xsda-pixel/common-infra/errors"
+	"github.com/xsda-pixel/common-infra/logs"
+
+	"gorm.io/gorm"
+)
+
+const (
+	defaultTable       = "schema_migrations"
+	defaultLockTimeout = time.Minute
+	defaultLockTTL     = 30 * time.Second
+)
+
+// 迁移状态
+const (
+	StateApplied  = "applied"  // 已执行
+	StatePending  = "pending"  // 未执行
+	StateModified = "modified" // 已执行，但迁移内容的校验和与执行时不一致
+	StateMissing  = "missing"  // 版本表中有记录，但找不到对应的迁移
+)
+
+var (
+	ErrChecksumMismatch = errors.NewError(http.StatusConflict, errors.NewMsg("migrate: applied migration has been modified"))
+	ErrOutOfOrder       = errors.NewError(http.StatusConflict, errors.NewMsg("migrate: pending migration is older than the latest applied version"))
+	ErrMissingSource    = errors.NewError(http.StatusConflict, errors.NewMsg("migrate: applied migration not found in source"))
+	ErrNoDown           = errors.NewError(http.StatusBadRequest, errors.NewMsg("migrate: migration has no down"))
+)
+
+// Config 迁移配置
+type Config struct {
+	Table           string        // 版本表，默认 schema_migrations
+	LockMode        string        // LockDB（默认）/ LockRedis / LockNone
+	LockName        string        // 锁名，默认 migrate:<Table>；MySQL GET_LOCK 的锁名不能超过 64 个字符
+	LockTimeout     time.Duration // 等待锁的最长时间，默认 1m
+	LockTTL         time.Duration // Redis 锁的过期时间，持有期间自动续期，默认 30s
+	AllowOutOfOrder bool          // 允许执行版本号小于已执行最大版本的迁移（多分支合并后常见），默认拒绝
+	DryRun          bool          // 只把将要执行的 SQL 写到 Output，不执行、不加锁、不写版本表
+	Output          io.Writer     // dry-run 输出，默认 os.Stdout
+}
+
+// Migration 单个迁移版本。SQL 迁移一般由 LoadFS 加载，Go 迁移直接构造并设置 Up / Down
+type Migration struct {
+	Version  int64  // 版本号，正整数，常用序号或 20240102150405 形式的时间戳
+	Name     string // 描述，仅用于展示
+	UpSQL    string
+	DownSQL  string
+	Up       func(tx *gorm.DB) error // 非 nil 时代替 UpSQL
+	Down     func(tx *gorm.DB) error // 非 nil 时代替 DownSQL
+	NoTx     bool                    // 不在事务中执行，如 PostgreSQL 的 CREATE INDEX CONCURRENTLY
+	Checksum string                  // 为空时 SQL 迁移取 UpSQL 的 sha256，Go 迁移取版本与名称的 sha256
+}
+
+// Record 版本表中的一行
+type Record struct {
+	Version   int64     `gorm:"primaryKey;autoIncrement:false"`
+	Name      string    `gorm:"size:255;not null"`
+	Checksum  string    `gorm:"size:64;not null"`
+	AppliedAt time.Time `gorm:"not null"`
+}
+
+// Status 单个版本的迁移状态
+type Status struct {
+	Version   int64
+	Name      string
+	State     string     // StateApplied / StatePending / StateModified / StateMissing
+	AppliedAt *time.Time // 未执行时为 nil
+}
+
+// Migrator 迁移执行器
+type Migrator struct {
+	dbs        *dal.DBS
+	cfg        Config
+	migrations []Migration // 按版本升序
+}
+
+// New 校验并按版本排序 migrations：版本号必须为正且不重复，每个迁移必须有 up
+func New(dbs *dal.DBS, migrations []Migration, opts ...func(*Config)) (*Migrator, errors.Error) {
+	cfg := Config{
+		Table:       defaultTable,
+		LockMode:    LockDB,
+		LockTimeout: defaultLockTimeout,
+		LockTTL:     defaultLockTTL,
+		Output:      os.Stdout,
+	}
+	for _, opt := range opts {
+		opt(&cfg)
+	}
+	if cfg.LockName == "" {
+		cfg.LockName = "migrate:" + cfg.Table
+	}
+
+	list := make([]Migration, len(migrations))
+	copy(list, migrations)
+	sort.Slice(list, func(i, j int) bool { return list[i].Version < list[j].Version })
+
+	for i := range list {
+		mig := &list[i]
+		if mig.Version <= 0 {
+			return nil, invalid("migration %s: version must be positive", mig.Name)
+		}
+		if i > 0 && list[i-1].Version == mig.Version {
+			return nil, invalid("migration version %d is duplicated", mig.Version)
+		}
+		if mig.Up == nil && strings.TrimSpace(mig.UpSQL) == "" {
+			return nil, invalid("migration %d: up is empty", mig.Version)
+		}
+		if mig.Checksum == "" {
+			mig.Checksum = checksum(*mig)
+		}
+	}
+
+	return &Migrator{dbs: dbs, cfg: cfg, migrations: list}, nil
+}
+
+func WithTable(table string) func(*Config) {
+	return func(c *Config) {
+		if table != "" {
+			c.Table = table
+		}
+	}
+}
+
+// WithLock 配置锁方式与等待时间，name 为空时使用默认锁名
+func WithLock(mode, name string, timeout time.Duration) func(*Config) {
+	return func(c *Config) {
+		if mode != "" {
+			c.LockMode = mode
+		}
+		c.LockName = name
+		if timeout > 0 {
+			c.LockTimeout = timeout
+		}
+	}
+}
+
+// WithLockTTL 配置 Redis 锁的过期时间，应明显大于一次续期的网络耗时
+func WithLockTTL(ttl time.Duration) func(*Config) {
+	return func(c *Config) {
+		if ttl > 0 {
+			c.LockTTL = ttl
+		}
+	}
+}
+
+func WithAllowOutOfOrder() func(*Config) {
+	return func(c *Config) {
+		c.AllowOutOfOrder = true
+	}
+}
+
+// WithDryRun 只输出 SQL 不执行；Go 迁移在 DryRun 会话中运行，输出其通过 gorm 发出的语句，其中的查询均返回空结果
+func WithDryRun(w io.Writer) func(*Config) {
+	return func(c *Config) {
+		c.DryRun = true
+		if w != nil {
+			c.Output = w
+		}
+	}
+}
+
+// Status 返回全部迁移（含版本表中有记录但找不到迁移的版本）的状态，按版本升序
+func (m *Migrator) Status(ctx context.Context) ([]Status, errors.Error) {
+	records, err := m.records(ctx)
+	if err != nil {
+		return nil, err
+	}
+	return m.status(records), nil
+}
+
+// Up 按版本升序执行未执行的迁移，n <= 0 表示全部，返回本次执行的版本
+func (m *Migrator) Up(ctx context.Context, n int) ([]int64, errors.Error) {
+	var done []int64
+	err := m.locked(ctx, func(ctx context.Context, states []Status) errors.Error {
+		if err := m.checkModified(states); err != nil {
+			return err
+		}
+
+		var maxApplied int64
+		for _, s := range states {
+			if s.State != StatePending && s.Version > maxApplied {
+				maxApplied = s.Version
+			}
+		}
+
+		for _, s := range states {
+			if s.State != StatePending {
+				continue
+			}
+			if n > 0 && len(done) >= n {
+				break
+			}
+			if s.Version < maxApplied && !m.cfg.AllowOutOfOrder {
+				return errors.WithCause(ErrOutOfOrder, fmt.Errorf("version %d < %d", s.Version, maxApplied))
+			}
+			if err := m.apply(ctx, m.migration(s.Version), true); err != nil {
+				return err
+			}
+			done = append(done, s.Version)
+		}
+		return nil
+	})
+	return done, err
+}
+
+// Down 按版本降序回滚最近执行的 n 个迁移，返回本次回滚的版本
+func (m *Migrator) Down(ctx context.Context, n int) ([]int64, errors.Error) {
+	if n <= 0 {
+		return nil, invalid("down: n must be positive")
+	}
+
+	var done []int64
+	err := m.locked(ctx, func(ctx context.Context, states []Status) errors.Error {
+		for i := len(states) - 1; i >= 0 && len(done) < n; i-- {
+			if states[i].State == StatePending {
+				continue
+			}
+			if err := m.rollback(ctx, states[i]); err != nil {
+				return err
+			}
+			done = append(done, states[i].Version)
+		}
+		return nil
+	})
+	return done, err
+}
+
+// Redo 回滚并重新执行最近执行的一个迁移，返回该版本；没有已执行的迁移时返回 0
+func (m *Migrator) Redo(ctx context.Context) (int64, errors.Error) {
+	var version int64
+	err := m.locked(ctx, func(ctx context.Context, states []Status) errors.Error {
+		for i := len(states) - 1; i >= 0; i-- {
+			if states[i].State == StatePending {
+				continue
+			}
+			if err := m.rollback(ctx, states[i]); err != nil {
+				return err
+			}
+			if err := m.apply(ctx, m.migration(states[i].Version), true); err != nil {
+				return err
+			}
+			version = states[i].Version
+			return nil
+		}
+		return nil
+	})
+	return version, err
+}
+
+// locked 加锁后读取最新状态并执行 fn，fn 应使用传入的 ctx（锁丢失时被取消）；dry-run 不加锁
+func (m *Migrator) locked(ctx context.Context, fn func(ctx context.Context, states []Status) errors.Error) errors.Error {
+	if !m.cfg.DryRun {
+		heldCtx, unlock, err := m.lock(ctx)
+		if err != nil {
+			return err
+		}
+		defer unlock()
+		ctx = heldCtx
+
+		if err := m.ensureTable(ctx); err != nil {
+			return lockLost(ctx, err)
+		}
+	}
+
+	records, err := m.records(ctx)
+	if err != nil {
+		return lockLost(ctx, err)
+	}
+	return lockLost(ctx, fn(ctx, m.status(records)))
+}
+
+// lockLost 持有的锁中途丢失导致 ctx 被取消时，以 ErrLockLost 代替 ctx 取消错误
+func lockLost(ctx context.Context, err errors.Error) errors.Error {
+	if err != nil && stdErrors.Is(context.Cause(ctx), ErrLockLost) {
+		return errors.WithCause(ErrLockLost, err)
+	}
+	return err
+}
+
+func (m *Migrator) rollback(ctx context.Context, s Status) errors.Error {
+	mig := m.migration(s.Version)
+	if mig == nil {
+		return errors.WithCause(ErrMissingSource, fmt.Errorf("version %d", s.Version))
+	}
+	if s.State == StateModified {
+		return errors.WithCause(ErrChecksumMismatch, fmt.Errorf("version %d", s.Version))
+	}
+	if mig.Down == nil && strings.TrimSpace(mig.DownSQL) == "" {
+		return errors.WithCause(ErrNoDown, fmt.Errorf("version %d", s.Version))
+	}
+	return m.apply(ctx, mig, false)
+}
+
+// apply 执行单个迁移并更新版本表，二者在同一事务中（NoTx 除外）。
+// MySQL 的 DDL 会隐式提交，失败时可能已部分生效，需要人工检查后修复
+func (m *Migrator) apply(ctx context.Context, mig *Migration, up bool) errors.Error {
+	if m.cfg.DryRun {
+		return m.dryRun(ctx, mig, up)
+	}
+
+	start := time.Now()
+	run := func(tx *gorm.DB) error {
+		if err := execMigration(tx, mig, up); err != nil {
+			return err
+		}
+		if up {
+			return tx.Table(m.cfg.Table).Create(&Record{
+				Version:   mig.Version,
+				Name:      mig.Name,
+				Checksum:  mig.Checksum,
+				AppliedAt: time.Now(),
+			}).Error
+		}
+		return tx.Table(m.cfg.Table).Where("version = ?", mig.Version).Delete(&Record{}).Error
+	}
+
+	db := m.dbs.MySQL.WithContext(ctx)
+	var err error
+	if mig.NoTx {
+		err = run(db)
+	} else {
+		err = db.Transaction(run)
+	}
+
+	direction := "up"
+	if !up {
+		direction = "down"
+	}
+	if err != nil {
+		logs.Logger.Errorf("migrate %s %d %s failed: %v", direction, mig.Version, mig.Name, err)
+		return wrapErr(ctx, fmt.Errorf("migrate %s %d %s: %w", direction, mig.Version, mig.Name, err))
+	}
+	logs.Logger.Infof("migrate %s %d %s done in %s", direction, mig.Version, mig.Name, time.Since(start))
+	return nil
+}
+
+func execMigration(tx *gorm.DB, mig *Migration, up bool) error {
+	fn, sql := mig.Up, mig.UpSQL
+	if !up {
+		fn, sql = mig.Down, mig.DownSQL
+	}
+	if fn != nil {
+		return fn(tx)
+	}
+	for _, stmt := range SplitStatements(sql) {
+		if err := tx.Exec(stmt).Error; err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (m *Migrator) ensureTable(ctx context.Context) errors.Error {
+	if err := m.dbs.MySQL.WithContext(ctx).Table(m.cfg.Table).AutoMigrate(&Record{}); err != nil {
+		return wrapErr(ctx, err)
+	}
+	return nil
+}
+
+// records 读取版本表，版本表不存在时视为没有已执行的版本
+func (m *Migrator) records(ctx context.Context) ([]Record, errors.Error) {
+	db := m.dbs.MySQL.WithContext(ctx)
+	if !db.Migrator().HasTable(m.cfg.Table) {
+		return nil, nil
+	}
+	var records []Record
+	if err := db.Table(m.cfg.Table).Order("version").Find(&records).Error; err != nil {
+		return nil, wrapErr(ctx, err)
+	}
+	return records, nil
+}
+
+func (m *Migrator) status(records []Record) []Status {
+	applied := make(map[int64]Record, len(records))
+	for _, r := range records {
+		applied[r.Version] = r
+	}
+
+	states := make([]Status, 0, len(m.migrations)+len(records))
+	for _, mig := range m.migrations {
+		s := Status{Version: mig.Version, Name: mig.Name, State: StatePending}
+		if r, ok := applied[mig.Version]; ok {
+			s.State = StateApplied
+			if r.Checksum != mig.Checksum {
+				s.State = StateModified
+			}
+			s.AppliedAt = &r.AppliedAt
+			delete(applied, mig.Version)
+		}
+		states = append(states, s)
+	}
+	for _, r := range applied {
+		r := r
+		states = append(states, Status{Version: r.Version, Name: r.Name, State: StateMissing, AppliedAt: &r.AppliedAt})
+	}
+
+	sort.Slice(states, func(i, j int) bool { return states[i].Version < states[j].Version })
+	return states
+}
+
+func (m *Migrator) checkModified(states []Status) errors.Error {
+	var versions []string
+	for _, s := range states {
+		if s.State == StateModified {
+			versions = append(versions, fmt.Sprint(s.Version))
+		}
+	}
+	if len(versions) > 0 {
+		return errors.WithCause(ErrChecksumMismatch, fmt.Errorf("versions %s", strings.Join(versions, ", ")))
+	}
+	return nil
+}
+
+func (m *Migrator) migration(version int64) *Migration {
+	i := sort.Search(len(m.migrations), func(i int) bool { return m.migrations[i].Version >= version })
+	if i < len(m.migrations) && m.migrations[i].Version == version {
+		return &m.migrations[i]
+	}
+	return nil
+}
+
+func checksum(mig Migration) string {
+	content := mig.UpSQL
+	if mig.Up != nil {
+		content = fmt.Sprintf("go:%d:%s", mig.Version, mig.Name)
+	}
+	sum := sha256.Sum256([]byte(content))
+	return hex.EncodeToString(sum[:])
+}
+
+func invalid(format string, args ...any) errors.Error {
+	return errors.NewError(http.StatusBadRequest, errors.NewMsg(format, args...))
+}
+
+func wrapErr(ctx context.Context, err error) errors.Error {
+	return dal.ClassifyError(ctx, err)
+}
@@ -0,0 +1,457 @@
+package migrate_test
+
+import (
+	"bytes"
+	"context"
+	stdErrors "errors"
+	"fmt"
+	"net/http"
+	"os"
+	"strings"
+	"testing"
+	"testing/fstest"
+	"time"
+
+	"github.com/xsda-pixel/common-infra/dal"
+	"github.com/xsda-pixel/common-infra/logs"
+	"github.com/xsda-pixel/common-infra/migrate"
+
+	"github.com/alicebob/miniredis/v2"
+	"github.com/glebarez/sqlite"
+	rds "github.com/redis/go-redis/v9"
+	"gorm.io/gorm"
+	"gorm.io/gorm/logger"
+)
+
+func TestMain(m *testing.M) {
+	dir, err := os.MkdirTemp("", "migrate-logs-")
+	if err != nil {
+		panic(err)
+	}
+	logs.LogFilePath = dir + "/"
+	if err := logs.Init(false, false, false); err != nil {
+		panic(err)
+	}
+
+	code := m.Run()
+	_ = os.RemoveAll(dir)
+	os.Exit(code)
+}
+
+func openDB(t *testing.T) *gorm.DB {
+	t.Helper()
+	name := strings.NewReplacer("/", "_", " ", "_").Replace(t.Name())
+	db, err := gorm.Open(sqlite.Open("file:"+name+"?mode=memory&cache=shared"), &gorm.Config{Logger: logger.Discard})
+	if err != nil {
+		t.Fatal(err)
+	}
+	sqlDB, err := db.DB()
+	if err != nil {
+		t.Fatal(err)
+	}
+	// 内存库在最后一个连接关闭时销毁，ctx 取消会使连接池丢弃连接，因此固定持有一个连接
+	conn, err := sqlDB.Conn(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() {
+		_ = conn.Close()
+		_ = sqlDB.Close()
+	})
+	return db
+}
+
+// sqlMigrations 版本 1、2 为 SQL 迁移，版本 3 为 Go 迁移
+func sqlMigrations(t *testing.T) []migrate.Migration {
+	t.Helper()
+	migrations, err := migrate.LoadFS(fstest.MapFS{
+		"migrations/1_users.up.sql": {Data: []byte(`
+CREATE TABLE users (id INTEGER PRIMARY KEY, name TEXT NOT NULL);
+-- 字符串中的 ; 不拆分
+INSERT INTO users (id, name) VALUES (1, 'a;b');
+`)},
+		"migrations/1_users.down.sql":  {Data: []byte("DROP TABLE users;")},
+		"migrations/2_orders.up.sql":   {Data: []byte("CREATE TABLE orders (id INTEGER PRIMARY KEY, user_id INTEGER NOT NULL)")},
+		"migrations/2_orders.down.sql": {Data: []byte("DROP TABLE orders")},
+		"migrations/README.md":         {Data: []byte("ignored")},
+	}, "migrations")
+	if err != nil {
+		t.Fatal(err)
+	}
+	return append(migrations, migrate.Migration{
+		Version: 3,
+		Name:    "rename_user",
+		Up:      func(tx *gorm.DB) error { return tx.Exec("UPDATE users SET name = ? WHERE id = ?", "renamed", 1).Error },
+		Down:    func(tx *gorm.DB) error { return tx.Exec("UPDATE users SET name = ? WHERE id = ?", "a;b", 1).Error },
+	})
+}
+
+func newMigrator(t *testing.T, dbs *dal.DBS, migrations []migrate.Migration, opts ...func(*migrate.Config)) *migrate.Migrator {
+	t.Helper()
+	m, err := migrate.New(dbs, migrations, opts...)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return m
+}
+
+func states(t *testing.T, m *migrate.Migrator) string {
+	t.Helper()
+	list, err := m.Status(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+	out := make([]string, len(list))
+	for i, s := range list {
+		out[i] = fmt.Sprintf("%d:%s", s.Version, s.State)
+	}
+	return strings.Join(out, " ")
+}
+
+func userName(t *testing.T, db *gorm.DB) string {
+	t.Helper()
+	var name string
+	if err := db.Raw("SELECT name FROM users WHERE id = 1").Scan(&name).Error; err != nil {
+		t.Fatal(err)
+	}
+	return name
+}
+
+func TestUpDownRedo(t *testing.T) {
+	db := openDB(t)
+	m := newMigrator(t, dal.NewDB(db, nil), sqlMigrations(t))
+	ctx := context.Background()
+
+	if got := states(t, m); got != "1:pending 2:pending 3:pending" {
+		t.Fatalf("initial status = %s", got)
+	}
+
+	done, err := m.Up(ctx, 1)
+	if err != nil || fmt.Sprint(done) != "[1]" {
+		t.Fatalf("Up(1) = %v, %v", done, err)
+	}
+	if name := userName(t, db); name != "a;b" {
+		t.Fatalf("user name = %q, want a;b", name)
+	}
+	done, err = m.Up(ctx, 0)
+	if err != nil || fmt.Sprint(done) != "[2 3]" {
+		t.Fatalf("Up(0) = %v, %v", done, err)
+	}
+	if name := userName(t, db); name != "renamed" {
+		t.Fatalf("user name after Go migration = %q", name)
+	}
+	if done, err = m.Up(ctx, 0); err != nil || len(done) != 0 {
+		t.Fatalf("Up with nothing pending = %v, %v", done, err)
+	}
+	if got := states(t, m); got != "1:applied 2:applied 3:applied" {
+		t.Fatalf("status after Up = %s", got)
+	}
+
+	version, err := m.Redo(ctx)
+	if err != nil || version != 3 {
+		t.Fatalf("Redo = %d, %v", version, err)
+	}
+	if name := userName(t, db); name != "renamed" {
+		t.Fatalf("user name after Redo = %q", name)
+	}
+
+	done, err = m.Down(ctx, 2)
+	if err != nil || fmt.Sprint(done) != "[3 2]" {
+		t.Fatalf("Down(2) = %v, %v", done, err)
+	}
+	if db.Migrator().HasTable("orders") {
+		t.Fatal("orders still exists after Down")
+	}
+	if got := states(t, m); got != "1:applied 2:pending 3:pending" {
+		t.Fatalf("status after Down = %s", got)
+	}
+	if _, err := m.Down(ctx, 0); err == nil || err.ErrCode() != http.StatusBadRequest {
+		t.Fatalf("Down(0) error = %v, want 400", err)
+	}
+
+	done, err = m.Down(ctx, 5)
+	if err != nil || fmt.Sprint(done) != "[1]" {
+		t.Fatalf("Down(5) = %v, %v", done, err)
+	}
+	if version, err = m.Redo(ctx); err != nil || version != 0 {
+		t.Fatalf("Redo with nothing applied = %d, %v", version, err)
+	}
+}
+
+func TestFailedMigrationRollsBack(t *testing.T) {
+	db := openDB(t)
+	migrations := append(sqlMigrations(t)[:1], migrate.Migration{
+		Version: 2,
+		Name:    "broken",
+		UpSQL:   "CREATE TABLE orders (id INTEGER PRIMARY KEY); INSERT INTO no_such_table VALUES (1)",
+	})
+	m := newMigrator(t, dal.NewDB(db, nil), migrations)
+
+	done, err := m.Up(context.Background(), 0)
+	if err == nil || fmt.Sprint(done) != "[1]" {
+		t.Fatalf("Up = %v, %v; want [1] and an error", done, err)
+	}
+	if db.Migrator().HasTable("orders") {
+		t.Fatal("failed migration was not rolled back")
+	}
+	if got := states(t, m); got != "1:applied 2:pending" {
+		t.Fatalf("status after failure = %s", got)
+	}
+}
+
+func TestOutOfOrder(t *testing.T) {
+	dbs := dal.NewDB(openDB(t), nil)
+	all := sqlMigrations(t)
+	ctx := context.Background()
+
+	if _, err := newMigrator(t, dbs, []migrate.Migration{all[0], all[2]}).Up(ctx, 0); err != nil {
+		t.Fatal(err)
+	}
+
+	// 合并后出现了比已执行最大版本更小的 2
+	if _, err := newMigrator(t, dbs, all).Up(ctx, 0); !stdErrors.Is(err, migrate.ErrOutOfOrder) {
+		t.Fatalf("Up error = %v, want ErrOutOfOrder", err)
+	}
+	done, err := newMigrator(t, dbs, all, migrate.WithAllowOutOfOrder()).Up(ctx, 0)
+	if err != nil || fmt.Sprint(done) != "[2]" {
+		t.Fatalf("Up with AllowOutOfOrder = %v, %v", done, err)
+	}
+}
+
+func TestChecksumAndMissing(t *testing.T) {
+	dbs := dal.NewDB(openDB(t), nil)
+	all := sqlMigrations(t)
+	ctx := context.Background()
+	if _, err := newMigrator(t, dbs, all[:2]).Up(ctx, 0); err != nil {
+		t.Fatal(err)
+	}
+
+	modified := append([]migrate.Migration{}, all...)
+	modified[0].UpSQL += "\nCREATE INDEX idx_users_name ON users (name);"
+	modified[0].Checksum = ""
+	m := newMigrator(t, dbs, modified)
+	if got := states(t, m); got != "1:modified 2:applied 3:pending" {
+		t.Fatalf("status = %s", got)
+	}
+	if _, err := m.Up(ctx, 0); !stdErrors.Is(err, migrate.ErrChecksumMismatch) {
+		t.Fatalf("Up error = %v, want ErrChecksumMismatch", err)
+	}
+	// 2 未被修改可以回滚，轮到 1 时停止
+	if done, err := m.Down(ctx, 2); !stdErrors.Is(err, migrate.ErrChecksumMismatch) || fmt.Sprint(done) != "[2]" {
+		t.Fatalf("Down over modified migration = %v, %v; want [2] and ErrChecksumMismatch", done, err)
+	}
+	if _, err := newMigrator(t, dbs, all[:2]).Up(ctx, 0); err != nil {
+		t.Fatal(err)
+	}
+
+	// 版本表中有 2，但源里没有
+	m = newMigrator(t, dbs, all[:1])
+	if got := states(t, m); got != "1:applied 2:missing" {
+		t.Fatalf("status = %s", got)
+	}
+	if _, err := m.Down(ctx, 1); !stdErrors.Is(err, migrate.ErrMissingSource) {
+		t.Fatalf("Down of missing migration error = %v, want ErrMissingSource", err)
+	}
+
+	noDown := append([]migrate.Migration{}, all[:2]...)
+	noDown[1].DownSQL = ""
+	if _, err := newMigrator(t, dbs, noDown).Down(ctx, 1); !stdErrors.Is(err, migrate.ErrNoDown) {
+		t.Fatalf("Down without down error = %v, want ErrNoDown", err)
+	}
+}
+
+func TestNewValidates(t *testing.T) {
+	for name, migrations := range map[string][]migrate.Migration{
+		"zero version": {{Version: 0, UpSQL: "SELECT 1"}},
+		"duplicated":   {{Version: 1, UpSQL: "SELECT 1"}, {Version: 1, UpSQL: "SELECT 2"}},
+		"empty up":     {{Version: 1, UpSQL: "  "}},
+	} {
+		if _, err := migrate.New(nil, migrations); err == nil || err.ErrCode() != http.StatusBadRequest {
+			t.Fatalf("New with %s error = %v, want 400", name, err)
+		}
+	}
+}
+
+func TestLoadFS(t *testing.T) {
+	migrations, err := migrate.LoadFS(fstest.MapFS{
+		"m/1_index.up.sql": {Data: []byte("-- migrate:no-transaction\nCREATE INDEX CONCURRENTLY idx ON t (c);")},
+		"m/2_plain.up.sql": {Data: []byte("SELECT 1")},
+	}, "m")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(migrations) != 2 || !migrations[0].NoTx || migrations[1].NoTx || migrations[0].Name != "index" {
+		t.Fatalf("LoadFS = %+v", migrations)
+	}
+
+	for name, fsys := range map[string]fstest.MapFS{
+		"no up":      {"m/1_a.down.sql": {Data: []byte("SELECT 1")}},
+		"name clash": {"m/1_a.up.sql": {Data: []byte("SELECT 1")}, "m/1_b.down.sql": {Data: []byte("SELECT 1")}},
+		"no dir":     {"other/1_a.up.sql": {Data: []byte("SELECT 1")}},
+	} {
+		if _, err := migrate.LoadFS(fsys, "m"); err == nil {
+			t.Fatalf("LoadFS with %s succeeded", name)
+		}
+	}
+}
+
+func TestSplitStatements(t *testing.T) {
+	cases := []struct {
+		name string
+		sql  string
+		want []string
+	}{
+		{"simple", "SELECT 1; SELECT 2;", []string{"SELECT 1", "SELECT 2"}},
+		{"no trailing", "SELECT 1;\nSELECT 2", []string{"SELECT 1", "SELECT 2"}},
+		{"single quote", "INSERT INTO t VALUES ('a;b'); SELECT 1", []string{"INSERT INTO t VALUES ('a;b')", "SELECT 1"}},
+		{"escaped quote", `SELECT 'it''s;', 'a\';b'; SELECT 2`, []string{`SELECT 'it''s;', 'a\';b'`, "SELECT 2"}},
+		{"double quote", `SELECT "a;b"; SELECT 2`, []string{`SELECT "a;b"`, "SELECT 2"}},
+		{"backtick", "SELECT `a;b` FROM t; SELECT 2", []string{"SELECT `a;b` FROM t", "SELECT 2"}},
+		{"line comment", "SELECT 1; -- a;b\n# c;d\nSELECT 2", []string{"SELECT 1", "-- a;b\n# c;d\nSELECT 2"}},
+		{"block comment", "SELECT /* a;b */ 1; /* only; comment */;", []string{"SELECT /* a;b */ 1"}},
+		{"dollar", "CREATE FUNCTION f() AS $$ BEGIN; END; $$; SELECT 2", []string{"CREATE FUNCTION f() AS $$ BEGIN; END; $$", "SELECT 2"}},
+		{"dollar tag", "DO $body$ SELECT 1; $body$; SELECT $1", []string{"DO $body$ SELECT 1; $body$", "SELECT $1"}},
+		{"empty", " ;\n; -- nothing\n", nil},
+	}
+	for _, tc := range cases {
+		if got := migrate.SplitStatements(tc.sql); fmt.Sprintf("%q", got) != fmt.Sprintf("%q", tc.want) {
+			t.Fatalf("%s: SplitStatements = %q, want %q", tc.name, got, tc.want)
+		}
+	}
+}
+
+func TestDryRun(t *testing.T) {
+	db := openDB(t)
+	var out bytes.Buffer
+	m := newMigrator(t, dal.NewDB(db, nil), sqlMigrations(t), migrate.WithDryRun(&out))
+
+	done, err := m.Up(context.Background(), 0)
+	if err != nil || fmt.Sprint(done) != "[1 2 3]" {
+		t.Fatalf("dry-run Up = %v, %v", done, err)
+	}
+	for _, want := range []string{
+		"-- up 1 users\nCREATE TABLE users (id INTEGER PRIMARY KEY, name TEXT NOT NULL);\n",
+		"-- 字符串中的 ; 不拆分\nINSERT INTO users (id, name) VALUES (1, 'a;b');\n",
+		"-- record version 1 in schema_migrations\n",
+		"-- up 3 rename_user\nUPDATE users SET name = \"renamed\" WHERE id = 1;\n",
+	} {
+		if !strings.Contains(out.String(), want) {
+			t.Fatalf("dry-run output missing %q:\n%s", want, out.String())
+		}
+	}
+	if db.Migrator().HasTable("users") || db.Migrator().HasTable("schema_migrations") {
+		t.Fatal("dry-run changed the database")
+	}
+}
+
+func TestCommand(t *testing.T) {
+	m := newMigrator(t, dal.NewDB(openDB(t), nil), sqlMigrations(t))
+	ctx := context.Background()
+
+	for _, args := range [][]string{nil, {"frob"}, {"up", "x"}, {"up", "-1"}, {"up", "1", "2"}, {"down"}, {"down", "0"}, {"status", "1"}, {"redo", "1"}} {
+		var out bytes.Buffer
+		if err := migrate.Command(ctx, m, args, &out); err == nil || err.ErrCode() != http.StatusBadRequest {
+			t.Fatalf("Command(%q) error = %v, want 400", args, err)
+		}
+	}
+
+	run := func(args ...string) string {
+		t.Helper()
+		var out bytes.Buffer
+		if err := migrate.Command(ctx, m, args, &out); err != nil {
+			t.Fatalf("Command(%q): %v", args, err)
+		}
+		return out.String()
+	}
+	if got := run("redo"); got != "no applied migration\n" {
+		t.Fatalf("redo = %q", got)
+	}
+	if got := run("up", "2"); got != "applied 1\napplied 2\n" {
+		t.Fatalf("up 2 = %q", got)
+	}
+	status := run("status")
+	if !strings.HasPrefix(status, "VERSION") || !strings.Contains(status, "rename_user  pending") || strings.Count(status, "applied") != 2 {
+		t.Fatalf("status =\n%s", status)
+	}
+	if got := run("up"); got != "applied 3\n" {
+		t.Fatalf("up = %q", got)
+	}
+	if got := run("up"); got != "nothing applied\n" {
+		t.Fatalf("up with nothing pending = %q", got)
+	}
+	if got := run("redo"); got != "redone 3\n" {
+		t.Fatalf("redo = %q", got)
+	}
+	if got := run("down", "2"); got != "rolled back 3\nrolled back 2\n" {
+		t.Fatalf("down 2 = %q", got)
+	}
+}
+
+func newRedisDBS(t *testing.T) (*dal.DBS, *miniredis.Miniredis) {
+	t.Helper()
+	mr := miniredis.RunT(t)
+	client := rds.NewClient(&rds.Options{Addr: mr.Addr()})
+	t.Cleanup(func() { _ = client.Close() })
+	return dal.NewDB(openDB(t), client), mr
+}
+
+func TestRedisLock(t *testing.T) {
+	dbs, mr := newRedisDBS(t)
+	ctx := context.Background()
+	m := newMigrator(t, dbs, sqlMigrations(t), migrate.WithLock(migrate.LockRedis, "", 100*time.Millisecond))
+
+	// 旧实现或其他实例以同名 key 持有锁
+	mr.Set("migrate:schema_migrations", "other-instance")
+	if _, err := m.Up(ctx, 0); !stdErrors.Is(err, migrate.ErrLocked) {
+		t.Fatalf("Up while locked error = %v, want ErrLocked", err)
+	}
+	mr.Del("migrate:schema_migrations")
+
+	done, err := m.Up(ctx, 0)
+	if err != nil || fmt.Sprint(done) != "[1 2 3]" {
+		t.Fatalf("Up = %v, %v", done, err)
+	}
+	if mr.Exists("migrate:schema_migrations") {
+		t.Fatal("lock not released after Up")
+	}
+
+	if _, err := newMigrator(t, dal.NewDB(openDB(t), nil), sqlMigrations(t), migrate.WithLock(migrate.LockRedis, "", 0)).Up(ctx, 0); !stdErrors.Is(err, migrate.ErrNoRedis) {
+		t.Fatalf("Up without redis error = %v, want ErrNoRedis", err)
+	}
+}
+
+func TestRedisLockLost(t *testing.T) {
+	dbs, mr := newRedisDBS(t)
+	const key = "migrate:test"
+
+	migrations := append(sqlMigrations(t)[:1], migrate.Migration{
+		Version: 2,
+		Name:    "slow",
+		Up: func(tx *gorm.DB) error {
+			// 迁移执行中锁被其他实例抢占，续期失败后 ctx 被取消
+			mr.Set(key, "other-instance")
+			ctx := tx.Statement.Context
+			select {
+			case <-ctx.Done():
+				return ctx.Err()
+			case <-time.After(5 * time.Second):
+				return stdErrors.New("lock loss not detected")
+			}
+		},
+	})
+	m := newMigrator(t, dbs, migrations,
+		migrate.WithLock(migrate.LockRedis, key, time.Second),
+		migrate.WithLockTTL(300*time.Millisecond),
+	)
+
+	done, err := m.Up(context.Background(), 0)
+	if !stdErrors.Is(err, migrate.ErrLockLost) || fmt.Sprint(done) != "[1]" {
+		t.Fatalf("Up = %v, %v; want [1] and ErrLockLost", done, err)
+	}
+	if got := states(t, m); got != "1:applied 2:pending" {
+		t.Fatalf("status after lost lock = %s", got)
+	}
+	if got, _ := mr.Get(key); got != "other-instance" {
+		t.Fatalf("lock owner after abort = %q, want the new owner kept", got)
+	}
+}
@@ -0,0 +1,164 @@
+package migrate
+
+import (
+	"bufio"
+	"io/fs"
+	"path"
+	"regexp"
+	"strconv"
+	"strings"
+
+	"github.com/xsda-pixel/common-infra/errors"
+)
+
+// noTxMarker 出现在 up 文件的某一行时，该迁移不在事务中执行
+const noTxMarker = "-- migrate:no-transaction"
+
+var fileRe = regexp.MustCompile(`^(\d+)_(.+)\.(up|down)\.sql$`)
+
+// LoadFS 加载 dir 下的 SQL 迁移，文件名为 <version>_<name>.up.sql 与可选的 <version>_<name>.down.sql，
+// 其余文件忽略。一个文件可包含多条以 ; 分隔的语句；存储过程、触发器等语句体内含 ; 的迁移请改用 Go 迁移
+func LoadFS(fsys fs.FS, dir string) ([]Migration, errors.Error) {
+	entries, err := fs.ReadDir(fsys, dir)
+	if err != nil {
+		return nil, invalid("migrate: read dir %s: %v", dir, err)
+	}
+
+	byVersion := make(map[int64]*Migration)
+	var order []int64
+	for _, entry := range entries {
+		if entry.IsDir() {
+			continue
+		}
+		match := fileRe.FindStringSubmatch(entry.Name())
+		if match == nil {
+			continue
+		}
+		version, err := strconv.ParseInt(match[1], 10, 64)
+		if err != nil {
+			return nil, invalid("migrate: invalid version in %s", entry.Name())
+		}
+		content, err := fs.ReadFile(fsys, path.Join(dir, entry.Name()))
+		if err != nil {
+			return nil, invalid("migrate: read %s: %v", entry.Name(), err)
+		}
+
+		mig, ok := byVersion[version]
+		if !ok {
+			mig = &Migration{Version: version, Name: match[2]}
+			byVersion[version] = mig
+			order = append(order, version)
+		} else if mig.Name != match[2] {
+			return nil, invalid("migrate: version %d has different names %s and %s", version, mig.Name, match[2])
+		}
+
+		if match[3] == "up" {
+			mig.UpSQL = string(content)
+			mig.NoTx = hasNoTxMarker(mig.UpSQL)
+		} else {
+			mig.DownSQL = string(content)
+		}
+	}
+
+	list := make([]Migration, 0, len(order))
+	for _, version := range order {
+		if byVersion[version].UpSQL == "" {
+			return nil, invalid("migrate: version %d has no up file", version)
+		}
+		list = append(list, *byVersion[version])
+	}
+	return list, nil
+}
+
+func hasNoTxMarker(sql string) bool {
+	scanner := bufio.NewScanner(strings.NewReader(sql))
+	for scanner.Scan() {
+		if strings.TrimSpace(scanner.Text()) == noTxMarker {
+			return true
+		}
+	}
+	return false
+}
+
+// SplitStatements 按 ; 拆分多条语句，忽略引号、反引号、注释与 PostgreSQL $tag$ 字符串中的 ;，丢弃只有注释或空白的片段
+func SplitStatements(sql string) []string {
+	var (
+		stmts   []string
+		start   int
+		hasCode bool
+	)
+	flush := func(end int) {
+		if hasCode {
+			stmts = append(stmts, strings.TrimSpace(sql[start:end]))
+		}
+		start, hasCode = end+1, false
+	}
+
+	for i := 0; i < len(sql); i++ {
+		c := sql[i]
+		switch {
+		case c == '\'' || c == '"' || c == '`':
+			i = skipQuoted(sql, i, c)
+			hasCode = true
+		case c == '-' && strings.HasPrefix(sql[i:], "--"), c == '#':
+			i = skipUntil(sql, i, "\n")
+		case c == '/' && strings.HasPrefix(sql[i:], "/*"):
+			i = skipUntil(sql, i+2, "*/") + 1
+		case c == '$':
+			if tag, ok := dollarTag(sql[i:]); ok {
+				i = skipUntil(sql, i+len(tag), tag) + len(tag) - 1
+			}
+			hasCode = true
+		case c == ';':
+			flush(i)
+		case c != ' ' && c != '\t' && c != '\r' && c != '\n':
+			hasCode = true
+		}
+	}
+	flush(len(sql))
+	return stmts
+}
+
+// skipQuoted 返回与 sql[i] 配对的结束引号位置，支持反斜杠转义与双写引号
+func skipQuoted(sql string, i int, quote byte) int {
+	for j := i + 1; j < len(sql); j++ {
+		switch sql[j] {
+		case '\\':
+			if quote != '`' {
+				j++
+			}
+		case quote:
+			if j+1 < len(sql) && sql[j+1] == quote {
+				j++
+				continue
+			}
+			return j
+		}
+	}
+	return len(sql)
+}
+
+// skipUntil 返回 sql[i:] 中 end 的起始位置，找不到时返回 len(sql)
+func skipUntil(sql string, i int, end string) int {
+	if i > len(sql) {
+		return len(sql)
+	}
+	if j := strings.Index(sql[i:], end); j >= 0 {
+		return i + j
+	}
+	return len(sql)
+}
+
+// dollarTag 识别 PostgreSQL 的 $$ / $tag$ 开头
+func dollarTag(s string) (string, bool) {
+	for j := 1; j < len(s); j++ {
+		c := s[j]
+		if c == '$' {
+			return s[:j+1], true
+		}
+		if !(c == '_' || c >= 'a' && c <= 'z' || c >= 'A' && c <= 'Z' || j > 1 && c >= '0' && c <= '9') {
+			return "", false
+		}
+	}
+	return "", false
+}